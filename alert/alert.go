@@ -0,0 +1,124 @@
+package alert
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"mailer-service/mailer"
+	"mailer-service/storage"
+)
+
+// Alertas de fallos definitivos:
+//
+//   - FAILURE_ALERT_TO: dirección que recibe el aviso. Vacía las desactiva.
+//   - FAILURE_ALERT_WINDOW: como mucho un aviso por ventana (por defecto 5m);
+//     los fallos que llegan dentro de ella se agrupan en el siguiente.
+//
+// El aviso se envía directamente con mailer.Deliver, sin pasar por la cola,
+// así que su propio fallo nunca genera otro aviso.
+
+// Header marca los avisos; un correo con esta cabecera no genera avisos.
+const Header = "X-Mailer-Alert"
+
+// maxListed limita los correos detallados en un aviso.
+const maxListed = 50
+
+// Failure es un correo fallido definitivamente.
+type Failure struct {
+	ID    int64
+	To    string
+	Error string
+}
+
+// Notifier agrupa los fallos y envía un resumen a To como mucho una vez por
+// Window. Un *Notifier nil no avisa de nada.
+type Notifier struct {
+	To     string
+	Window time.Duration
+	// Send entrega el aviso; por defecto mailer.Deliver.
+	Send func(mailer.Message) error
+
+	mu       sync.Mutex
+	pending  []Failure
+	dropped  int
+	timer    *time.Timer
+	lastSent time.Time
+}
+
+// FromEnv crea el notificador a partir de FAILURE_ALERT_TO y
+// FAILURE_ALERT_WINDOW. Devuelve nil si no está configurado.
+func FromEnv() *Notifier {
+	to := strings.TrimSpace(os.Getenv("FAILURE_ALERT_TO"))
+	if to == "" {
+		return nil
+	}
+	window, err := time.ParseDuration(os.Getenv("FAILURE_ALERT_WINDOW"))
+	if err != nil || window <= 0 {
+		window = 5 * time.Minute
+	}
+	return &Notifier{
+		To:     to,
+		Window: window,
+		Send: func(m mailer.Message) error {
+			_, err := mailer.Deliver(m, 0)
+			return err
+		},
+	}
+}
+
+// Failed anota que e falló definitivamente con msg. El primer fallo tras
+// una ventana sin avisos se envía enseguida; los siguientes esperan al final
+// de la ventana y van juntos en un solo aviso.
+func (n *Notifier) Failed(e storage.Email, msg string) {
+	if n == nil || e.Headers[Header] != "" || strings.EqualFold(e.To, n.To) {
+		return
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if len(n.pending) < maxListed {
+		n.pending = append(n.pending, Failure{ID: e.ID, To: e.To, Error: msg})
+	} else {
+		n.dropped++
+	}
+	if n.timer == nil {
+		n.timer = time.AfterFunc(max(time.Until(n.lastSent.Add(n.Window)), 0), n.flush)
+	}
+}
+
+func (n *Notifier) flush() {
+	n.mu.Lock()
+	failures, dropped := n.pending, n.dropped
+	n.pending, n.dropped, n.timer = nil, 0, nil
+	n.lastSent = time.Now()
+	n.mu.Unlock()
+
+	total := len(failures) + dropped
+	subject := "Correo fallido definitivamente"
+	if total > 1 {
+		subject = fmt.Sprintf("%d correos fallidos definitivamente", total)
+	}
+	var b strings.Builder
+	for _, f := range failures {
+		fmt.Fprintf(&b, "Correo %d a %s: %s\n", f.ID, f.To, f.Error)
+	}
+	if dropped > 0 {
+		fmt.Fprintf(&b, "... y %d más.\n", dropped)
+	}
+	fmt.Fprintf(&b, "\nSe envía como mucho un aviso cada %s.\n", n.Window)
+
+	err := n.Send(mailer.Message{
+		To:          n.To,
+		Subject:     subject,
+		Body:        b.String(),
+		ContentType: mailer.ContentTypePlain,
+		Headers:     map[string]string{Header: "failure"},
+	})
+	if err != nil {
+		log.Printf("Error enviando aviso de %d fallos a %s: %v", total, n.To, err)
+	}
+}
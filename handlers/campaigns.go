@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"regexp"
+)
+
+// ==========================================================
+// /campaigns — PAUSA Y REANUDACIÓN POR CAMPAÑA
+// ==========================================================
+
+var campaignRe = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,100}$`)
+
+// GET /campaigns
+// Campañas con sus correos en cola, enviándose, enviados y fallidos.
+func (h *EmailHandler) ListCampaignsHandler(w http.ResponseWriter, r *http.Request) {
+	setHeaders(w)
+	if r.Method != http.MethodGet {
+		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	list, err := h.Store.ListCampaigns(r.Context())
+	if err != nil {
+		http.Error(w, "Error en base de datos: "+err.Error(), 500)
+		return
+	}
+	writeData(w, r, list)
+}
+
+// POST /campaigns/{name}/pause
+// Deja de despachar los correos pendientes de la campaña del inquilino; los
+// que ya se están enviando terminan. Requiere ADMIN_API_KEY.
+func (h *EmailHandler) PauseCampaignHandler(w http.ResponseWriter, r *http.Request) {
+	h.setCampaignPaused(w, r, true)
+}
+
+// POST /campaigns/{name}/resume
+// Reanuda la campaña del inquilino y vuelve a encolar sus correos
+// pendientes. Requiere ADMIN_API_KEY.
+func (h *EmailHandler) ResumeCampaignHandler(w http.ResponseWriter, r *http.Request) {
+	h.setCampaignPaused(w, r, false)
+}
+
+func (h *EmailHandler) setCampaignPaused(w http.ResponseWriter, r *http.Request, paused bool) {
+	setHeaders(w)
+	if r.Method != http.MethodPost {
+		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+	if !requireAdmin(w, r) {
+		return
+	}
+	name := r.PathValue("name")
+	if !campaignRe.MatchString(name) {
+		http.Error(w, "Campaña inválida", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.Store.SetCampaignPaused(r.Context(), name, paused); err != nil {
+		http.Error(w, "Error en base de datos: "+err.Error(), 500)
+		return
+	}
+
+	// Con la cola de Redis los correos pausados se descartan al reclamarlos,
+	// así que al reanudar se encolan de nuevo (un id repetido no se envía
+	// dos veces).
+	if !paused {
+		pending, err := h.Store.PendingInCampaign(r.Context(), name)
+		if err != nil {
+			http.Error(w, "Error en base de datos: "+err.Error(), 500)
+			return
+		}
+		for _, p := range pending {
+			if err := h.Queue.Enqueue(r.Context(), p.ID, p.At); err != nil {
+				log.Printf("Error encolando correo %d: %v", p.ID, err)
+			}
+		}
+	}
+
+	c, err := h.Store.GetCampaign(r.Context(), name)
+	if err != nil {
+		http.Error(w, "Error en base de datos: "+err.Error(), 500)
+		return
+	}
+	writeData(w, r, c)
+}
@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"mailer-service/queue"
+	"mailer-service/storage"
+)
+
+// enqueueRecorder anota los ids que se vuelven a encolar.
+type enqueueRecorder struct {
+	queue.Queue
+	ids []int64
+}
+
+func (q *enqueueRecorder) Enqueue(ctx context.Context, id int64, at time.Time) error {
+	q.ids = append(q.ids, id)
+	return nil
+}
+
+// Pausar o reanudar una campaña solo afecta a la del inquilino que lo pide,
+// aunque otro use el mismo nombre.
+func TestCampaignPauseTenantScoped(t *testing.T) {
+	h, s := newTestHandler(t)
+	t.Setenv("ADMIN_API_KEY", "admin")
+	q := &enqueueRecorder{Queue: h.Queue}
+	h.Queue = q
+
+	a := storage.WithTenant(context.Background(), "a")
+	b := storage.WithTenant(context.Background(), "b")
+	insert := func(ctx context.Context, to string) int64 {
+		t.Helper()
+		id, err := s.InsertEmail(ctx, storage.Email{To: to, Subject: "s", Body: "b", Status: "queued", Campaign: "newsletter"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	idA := insert(a, "ana@example.com")
+	idB := insert(b, "luis@example.com")
+
+	setPaused := func(ctx context.Context, action string) {
+		t.Helper()
+		r := httptest.NewRequest(http.MethodPost, "/campaigns/newsletter/"+action, nil).WithContext(ctx)
+		r.SetPathValue("name", "newsletter")
+		r.Header.Set("X-Admin-Key", "admin")
+		w := httptest.NewRecorder()
+		h.setCampaignPaused(w, r, action == "pause")
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: status %d %s", action, w.Code, w.Body)
+		}
+	}
+
+	setPaused(a, "pause")
+
+	w := httptest.NewRecorder()
+	h.ListCampaignsHandler(w, httptest.NewRequest(http.MethodGet, "/campaigns", nil).WithContext(b))
+	var resp struct {
+		Data []storage.Campaign `json:"data"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Data) != 1 || resp.Data[0].Paused || resp.Data[0].Queued != 1 {
+		t.Errorf("campañas del inquilino b: %+v, se esperaba newsletter sin pausar con 1 en cola", resp.Data)
+	}
+
+	claimed, err := s.ClaimDue(context.Background(), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(claimed) != 1 || claimed[0].ID != idB {
+		t.Errorf("reclamados %v, se esperaba solo el correo %d de b", claimed, idB)
+	}
+
+	setPaused(a, "resume")
+	if !slices.Equal(q.ids, []int64{idA}) {
+		t.Errorf("reanudar la campaña de a encoló %v, se esperaba [%d]", q.ids, idA)
+	}
+}
@@ -2,27 +2,190 @@ package handlers
 
 import (
 	"bytes"
+	"context"
+	"crypto/subtle"
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log"
+	"math"
 	"net/http"
-	"net/smtp"
+	"net/mail"
+	"net/url"
 	"os"
+	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
+	"mailer-service/mailer"
+	"mailer-service/metrics"
 	"mailer-service/models"
+	"mailer-service/pdf"
+	"mailer-service/queue"
+	"mailer-service/ratelimit"
+	"mailer-service/render"
+	"mailer-service/scheduler"
 	"mailer-service/storage"
+	"mailer-service/verify"
+	"mailer-service/warmup"
+	"mailer-service/webhook"
+	"mailer-service/worker"
 )
 
 // ==========================================================
 // HANDLER PRINCIPAL
 // ==========================================================
 
-type EmailHandler struct{ Store *storage.Store }
+type EmailHandler struct {
+	Store storage.Repository
 
-func NewEmailHandler(s *storage.Store) *EmailHandler {
-	return &EmailHandler{Store: s}
+	// Async encola los correos para el worker en lugar de enviarlos en la petición.
+	Async bool
+	// Hybrid intenta un envío síncrono de como mucho HybridTimeout y, si no
+	// termina o falla de forma transitoria, deja el correo en la cola.
+	Hybrid        bool
+	HybridTimeout time.Duration
+
+	// Queue recibe los correos encolados o programados.
+	Queue     queue.Queue
+	Callbacks *webhook.Dispatcher
+	Renderer  *render.Renderer
+	Verifier  *verify.Verifier
+	// Limiter es el límite global de envíos (nil = sin límite).
+	Limiter *ratelimit.Bucket
+	// Warmup es el cupo diario de calentamiento de la IP (nil = sin límite).
+	Warmup *warmup.Ramp
+	// Worker procesa la cola; lo usa /admin/flush-queue.
+	Worker *worker.Worker
+
+	// MaxQueueDepth limita los correos en estado queued; 0 = sin límite.
+	MaxQueueDepth int64
+	stats         statsCache
+
+	// sendSlots limita los envíos síncronos simultáneos
+	// (MAX_CONCURRENT_SENDS); nil = sin límite.
+	sendSlots chan struct{}
+}
+
+func NewEmailHandler(s storage.Repository) *EmailHandler {
+	max, _ := strconv.ParseInt(getEnv("MAX_QUEUE_DEPTH", "0"), 10, 64)
+	v, err := verify.New()
+	if err != nil {
+		log.Println("Error cargando dominios desechables:", err)
+	}
+	if _, err := mailer.Senders(); err != nil {
+		log.Println(err)
+	}
+	if _, err := mailer.Routes(); err != nil {
+		log.Println(err)
+	}
+	if err := mailer.CheckDefaultReplyTo(); err != nil {
+		log.Println(err)
+	}
+	var slots chan struct{}
+	if n, _ := strconv.Atoi(getEnv("MAX_CONCURRENT_SENDS", "0")); n > 0 {
+		slots = make(chan struct{}, n)
+	}
+	hybridTimeout, err := time.ParseDuration(getEnv("HYBRID_SEND_TIMEOUT", "3s"))
+	if err != nil || hybridTimeout <= 0 {
+		hybridTimeout = 3 * time.Second
+	}
+	mode := getEnv("SEND_MODE", "sync")
+	h := &EmailHandler{
+		Store:         s,
+		Queue:         queue.NewDB(s),
+		Async:         mode == "async",
+		Hybrid:        mode == "hybrid",
+		HybridTimeout: hybridTimeout,
+		Callbacks:     webhook.New(s),
+		Renderer:      &render.Renderer{Store: s},
+		Verifier:      v,
+		MaxQueueDepth: max,
+		sendSlots:     slots,
+	}
+	h.registerStatsMetrics()
+	return h
+}
+
+// ==========================================================
+// CONTROL DE COLA (BACKPRESSURE)
+// ==========================================================
+
+const (
+	queueRetryAfter = "30"
+	sendRetryAfter  = "1"
+	maxPriority     = 10
+
+	maxConversationIDLen = 255
+)
+
+// queueDepth devuelve los correos en cola, de la caché de StatusCounts.
+func (h *EmailHandler) queueDepth(ctx context.Context) (int64, error) {
+	// La cola es común: la profundidad cuenta los correos de todos los
+	// inquilinos.
+	counts, err := h.StatusCounts(storage.AllTenants(ctx))
+	if err != nil {
+		return 0, err
+	}
+	return counts["queued"], nil
+}
+
+var (
+	sendsInFlight = metrics.NewGauge("mailer_sync_sends_in_flight", "Envíos síncronos de /send en curso.")
+	sendsRejected = metrics.NewCounter("mailer_sync_sends_rejected_total", "Envíos síncronos rechazados por MAX_CONCURRENT_SENDS.")
+)
+
+// acquireSend reserva un hueco para un envío síncrono sin esperar. Devuelve
+// false si ya hay MAX_CONCURRENT_SENDS en curso; si no, la función devuelta
+// libera el hueco.
+func (h *EmailHandler) acquireSend() (release func(), ok bool) {
+	if h.sendSlots != nil {
+		select {
+		case h.sendSlots <- struct{}{}:
+		default:
+			sendsRejected.Inc()
+			return nil, false
+		}
+	}
+	sendsInFlight.Inc()
+	return func() {
+		sendsInFlight.Dec()
+		if h.sendSlots != nil {
+			<-h.sendSlots
+		}
+	}, true
+}
+
+// setRateLimitHeaders informa del estado del límite global de envíos
+// (GLOBAL_SEND_RATE), compartido por todos los clientes:
+// X-RateLimit-Limit (envíos por minuto), X-RateLimit-Remaining (envíos
+// disponibles ahora) y X-RateLimit-Reset (segundos hasta recuperar el cupo
+// completo). Sin límite configurado no se envían.
+func (h *EmailHandler) setRateLimitHeaders(w http.ResponseWriter) {
+	if h.Limiter == nil {
+		return
+	}
+	w.Header().Set("X-RateLimit-Limit", strconv.Itoa(h.Limiter.PerMinute()))
+	w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(int(h.Limiter.Available())))
+	w.Header().Set("X-RateLimit-Reset", strconv.Itoa(int(math.Ceil(h.Limiter.ResetIn().Seconds()))))
+}
+
+// queueFull indica si la cola supera MAX_QUEUE_DEPTH.
+func (h *EmailHandler) queueFull(ctx context.Context) (bool, error) {
+	if h.MaxQueueDepth <= 0 {
+		return false, nil
+	}
+	n, err := h.queueDepth(ctx)
+	if err != nil {
+		return false, err
+	}
+	return n >= h.MaxQueueDepth, nil
 }
 
 // ==========================================================
@@ -35,100 +198,1329 @@ func setHeaders(w http.ResponseWriter) {
 	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
 }
 
+// writeData responde con el sobre {"success": true, "data": ...}. Los
+// clientes que prefieren el dato sin sobre pueden pedir ?envelope=false.
+func writeData(w http.ResponseWriter, r *http.Request, data any) {
+	if r.URL.Query().Get("envelope") == "false" {
+		json.NewEncoder(w).Encode(data)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]any{"success": true, "data": data})
+}
+
 func getEnv(k, d string) string {
 	if v := os.Getenv(k); v != "" {
 		return v
 	}
-	return d
+	return d
+}
+
+// maxSubjectLength devuelve MAX_SUBJECT_LENGTH (por defecto 255).
+func maxSubjectLength() int {
+	n, err := strconv.Atoi(getEnv("MAX_SUBJECT_LENGTH", "255"))
+	if err != nil || n <= 0 {
+		return 255
+	}
+	return n
+}
+
+// maxSendTimeout devuelve MAX_SEND_TIMEOUT, el máximo que admite
+// timeout_seconds (por defecto 60s).
+func maxSendTimeout() time.Duration {
+	d, err := time.ParseDuration(getEnv("MAX_SEND_TIMEOUT", "60s"))
+	if err != nil || d <= 0 {
+		return time.Minute
+	}
+	return d
+}
+
+// maxTemplateBytes devuelve MAX_TEMPLATE_BYTES, el tamaño máximo de asunto
+// más cuerpo de una plantilla (por defecto 1 MiB).
+func maxTemplateBytes() int {
+	n, err := strconv.Atoi(getEnv("MAX_TEMPLATE_BYTES", "1048576"))
+	if err != nil || n <= 0 {
+		return 1 << 20
+	}
+	return n
+}
+
+// checkTemplateSize rechaza las plantillas que superan MAX_TEMPLATE_BYTES
+// antes de compilarlas.
+func checkTemplateSize(t storage.Template) error {
+	if n, limit := len(t.Subject)+len(t.Body), maxTemplateBytes(); n > limit {
+		return fmt.Errorf("la plantilla ocupa %d bytes (asunto y cuerpo) y el máximo es %d (MAX_TEMPLATE_BYTES)", n, limit)
+	}
+	return nil
+}
+
+// subjectMaxLen devuelve SUBJECT_MAX_LEN, el recorte global del asunto
+// renderizado (0 = desactivado).
+func subjectMaxLen() int {
+	n, err := strconv.Atoi(getEnv("SUBJECT_MAX_LEN", "0"))
+	if err != nil || n < 0 {
+		return 0
+	}
+	return n
+}
+
+// requireAdmin valida la clave de administración (ADMIN_API_KEY) enviada en
+// X-Admin-Key o como Bearer, y la firma de la petición si ADMIN_HMAC_SECRET
+// está configurado. Sin clave configurada se deniega el acceso.
+func requireAdmin(w http.ResponseWriter, r *http.Request) bool {
+	key := getEnv("ADMIN_API_KEY", "")
+	if key == "" {
+		http.Error(w, "Operación de administración deshabilitada", http.StatusForbidden)
+		return false
+	}
+	got := r.Header.Get("X-Admin-Key")
+	if got == "" {
+		got = strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	}
+	if subtle.ConstantTimeCompare([]byte(got), []byte(key)) != 1 {
+		http.Error(w, "No autorizado", http.StatusUnauthorized)
+		return false
+	}
+	return verifySignature(w, r)
+}
+
+// checkContent rechaza asuntos o cuerpos formados solo por espacios y, con
+// REQUIRE_BODY_TEXT=true (por defecto), cuerpos HTML sin texto visible.
+// Los correos que solo llevan imágenes deben desactivar esta comprobación.
+// Un cuerpo en texto plano (plain) no pasa por la comprobación de HTML.
+func checkContent(subject, body string, plain bool) error {
+	if strings.TrimSpace(subject) == "" {
+		return errors.New("el asunto solo contiene espacios")
+	}
+	if strings.TrimSpace(body) == "" {
+		return errors.New("el cuerpo solo contiene espacios")
+	}
+	if !plain && getEnv("REQUIRE_BODY_TEXT", "true") == "true" && strings.TrimSpace(mailer.HTMLToText(body)) == "" {
+		return errors.New("el cuerpo HTML no contiene texto visible")
+	}
+	return nil
+}
+
+// validateAddrs verifica que cada dirección tenga un formato válido.
+func validateAddrs(addrs []string) error {
+	for _, a := range addrs {
+		if _, err := mail.ParseAddress(a); err != nil {
+			return fmt.Errorf("dirección inválida %q", a)
+		}
+	}
+	return nil
+}
+
+// bareAddrs devuelve las direcciones sin el nombre visible.
+func bareAddrs(addrs []string) []string {
+	out := make([]string, len(addrs))
+	for i, a := range addrs {
+		out[i] = a
+		if p, err := mail.ParseAddress(a); err == nil {
+			out[i] = p.Address
+		}
+	}
+	return out
+}
+
+// checkSendRecipients aplica a las direcciones de un envío las reglas por
+// las que /send rechaza la petición entera: formato, dominios permitidos,
+// supresión por rebotes y, según el Verifier, dominios desechables y buzones
+// de sistema. Devuelve también si alguna es desechable o de sistema; ante un
+// rechazo, el código HTTP y el mensaje a responder.
+func (h *EmailHandler) checkSendRecipients(ctx context.Context, addrs []string) (disposable, role bool, status int, err error) {
+	if err := validateAddrs(addrs); err != nil {
+		return false, false, http.StatusBadRequest, err
+	}
+	if err := mailer.CheckRecipients(addrs); err != nil {
+		return false, false, http.StatusBadRequest, err
+	}
+
+	suppressed, err := h.Store.Suppressed(ctx, bareAddrs(addrs))
+	if err != nil {
+		return false, false, 500, errors.New("Error en base de datos: " + err.Error())
+	}
+	if len(suppressed) > 0 {
+		return false, false, http.StatusBadRequest, errors.New("Destinatario suprimido por rebotes: " + strings.Join(suppressed, ", "))
+	}
+
+	for _, a := range addrs {
+		if h.Verifier.IsDisposable(a) {
+			disposable = true
+			if h.Verifier.BlockDisposable {
+				return false, false, http.StatusBadRequest, fmt.Errorf("Dominio desechable no permitido: %s", a)
+			}
+		}
+	}
+	for _, a := range addrs {
+		if h.Verifier.IsRole(a) {
+			role = true
+			if h.Verifier.BlockRole {
+				return false, false, http.StatusBadRequest, fmt.Errorf("Dirección de sistema no permitida: %s", a)
+			}
+		}
+	}
+	return disposable, role, 0, nil
+}
+
+// mergeAddrs une listas de direcciones sin duplicados, preservando el orden.
+func mergeAddrs(lists ...[]string) []string {
+	seen := map[string]bool{}
+	var out []string
+	for _, l := range lists {
+		for _, a := range l {
+			k := strings.ToLower(strings.TrimSpace(a))
+			if k == "" || seen[k] {
+				continue
+			}
+			seen[k] = true
+			out = append(out, strings.TrimSpace(a))
+		}
+	}
+	return out
+}
+
+// ==========================================================
+// /send — ENVÍO DE CORREOS
+// ==========================================================
+
+func (h *EmailHandler) SendEmailHandler(w http.ResponseWriter, r *http.Request) {
+	setHeaders(w)
+	if r.Method != http.MethodPost {
+		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	full, err := h.queueFull(r.Context())
+	if err != nil {
+		http.Error(w, "Error en base de datos: "+err.Error(), 500)
+		return
+	}
+	if full {
+		w.Header().Set("Retry-After", queueRetryAfter)
+		http.Error(w, "Cola de envío llena, intente más tarde", http.StatusServiceUnavailable)
+		return
+	}
+
+	var req models.EmailRequest
+	files, err := decodeSendRequest(w, r, &req)
+	if errors.Is(err, errTooLarge) {
+		http.Error(w, "Adjunto demasiado grande", http.StatusRequestEntityTooLarge)
+		return
+	}
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		http.Error(w, fmt.Sprintf("Petición demasiado grande (máximo %d bytes)", tooLarge.Limit), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer files.Cleanup()
+
+	n := len(files)
+	if req.PDF != nil {
+		n++
+	}
+	if err := checkAttachmentCount(n); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if _, err := storage.NormalizeLocale(req.Locale); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	contentType, err := mailer.NormalizeContentType(req.ContentType)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	plain := contentType == mailer.ContentTypePlain
+
+	var templateID sql.NullInt64
+	templateCap := 0
+	truncLimit := 0
+	locale := ""
+	if req.TemplateID > 0 || req.TemplateName != "" {
+		t, err := h.sendTemplate(r.Context(), req)
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Plantilla no encontrada", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Error en base de datos: "+err.Error(), 500)
+			return
+		}
+		// Un cuerpo text/plain no es HTML: no se escapa.
+		renderFn := h.Renderer.Render
+		if plain {
+			renderFn = h.Renderer.RenderText
+		}
+		out, err := renderFn(r.Context(), t, req.Variables)
+		var verr *render.VariablesError
+		if errors.As(err, &verr) {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]any{
+				"success": false,
+				"error":   "Variables inválidas para la plantilla",
+				"fields":  verr.Fields,
+			})
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.Subject == "" {
+			req.Subject = out.Subject
+			truncLimit = t.SubjectMaxLen
+			if truncLimit == 0 {
+				truncLimit = subjectMaxLen()
+			}
+		}
+		if req.Body == "" {
+			req.Body = out.Body
+		}
+		req.Cc = mergeAddrs(req.Cc, t.Cc)
+		req.Bcc = mergeAddrs(req.Bcc, t.Bcc)
+		req.Bulk = req.Bulk || t.Bulk
+		if req.ListID == "" {
+			req.ListID = t.ListID
+		}
+		templateID = sql.NullInt64{Int64: t.ID, Valid: true}
+		templateCap = t.MaxPerMinute
+		locale = t.Locale
+	}
+	if req.ListID != "" {
+		if !req.Bulk {
+			http.Error(w, "list_id requiere bulk", http.StatusBadRequest)
+			return
+		}
+		if err := mailer.CheckListID(req.ListID); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if err := mailer.CheckHeaders(req.Headers); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// Se compara en segundos: convertir antes a Duration desborda con
+	// valores enormes y el resultado podría pasar la comprobación.
+	if max := maxSendTimeout(); req.TimeoutSeconds < 0 || req.TimeoutSeconds > int(max/time.Second) {
+		http.Error(w, fmt.Sprintf("timeout_seconds debe estar entre 0 y %d (0 usa el timeout por defecto)", int(max.Seconds())), http.StatusBadRequest)
+		return
+	}
+
+	req.Subject = mailer.NormalizeSubject(req.Subject)
+	var truncated bool
+	req.Subject, truncated = render.TruncateSubject(req.Subject, truncLimit)
+	if req.To == "" || req.Subject == "" || req.Body == "" {
+		http.Error(w, "Campos requeridos: to, subject, body", http.StatusBadRequest)
+		return
+	}
+	if plain && req.TextBody != "" {
+		http.Error(w, "text_body no aplica con content_type text/plain", http.StatusBadRequest)
+		return
+	}
+	if err := checkContent(req.Subject, req.Body, plain); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if max := maxSubjectLength(); utf8.RuneCountInString(req.Subject) > max {
+		http.Error(w, fmt.Sprintf("El asunto supera el máximo de %d caracteres", max), http.StatusBadRequest)
+		return
+	}
+
+	// El layout es HTML: no se aplica a los correos en texto plano.
+	if !req.SkipLayout && !plain {
+		body, err := h.Renderer.Layout(r.Context(), req.Subject, req.Body)
+		if err != nil {
+			http.Error(w, "Error aplicando el layout: "+err.Error(), 500)
+			return
+		}
+		req.Body = body
+	}
+
+	disposable, role, status, err := h.checkSendRecipients(r.Context(), append(append([]string{req.To}, req.Cc...), req.Bcc...))
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	if req.Priority < 0 || req.Priority > maxPriority {
+		http.Error(w, fmt.Sprintf("priority debe estar entre 0 y %d", maxPriority), http.StatusBadRequest)
+		return
+	}
+
+	if req.SendAtLocal != "" || req.Timezone != "" {
+		if req.SendAt != nil {
+			http.Error(w, "send_at y send_at_local son excluyentes", http.StatusBadRequest)
+			return
+		}
+		if req.SendAtLocal == "" || req.Timezone == "" {
+			http.Error(w, "send_at_local y timezone van juntos", http.StatusBadRequest)
+			return
+		}
+		at, err := scheduler.NextLocal(req.SendAtLocal, req.Timezone, time.Now())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		req.SendAt = &at
+	}
+
+	if req.ExpiresAt != nil {
+		if !req.ExpiresAt.After(time.Now()) {
+			http.Error(w, "expires_at debe ser una fecha futura", http.StatusBadRequest)
+			return
+		}
+		if req.SendAt != nil && !req.ExpiresAt.After(*req.SendAt) {
+			http.Error(w, "expires_at debe ser posterior a send_at", http.StatusBadRequest)
+			return
+		}
+	}
+
+	var sender mailer.Sender
+	if req.FromAlias != "" {
+		senders, err := mailer.Senders()
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		s, ok := senders[req.FromAlias]
+		if !ok {
+			http.Error(w, fmt.Sprintf("from_alias desconocido: %s", req.FromAlias), http.StatusBadRequest)
+			return
+		}
+		sender = s
+	}
+	if req.ReplyTo != "" {
+		if _, err := mail.ParseAddressList(req.ReplyTo); err != nil {
+			http.Error(w, "reply_to inválido", http.StatusBadRequest)
+			return
+		}
+		sender.ReplyTo = req.ReplyTo
+	}
+
+	if req.CallbackURL != "" {
+		if u, err := url.Parse(req.CallbackURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			http.Error(w, "callback_url inválida", http.StatusBadRequest)
+			return
+		}
+	}
+	if len(req.ConversationID) > maxConversationIDLen || strings.ContainsFunc(req.ConversationID, unicode.IsControl) {
+		http.Error(w, fmt.Sprintf("conversation_id inválido: máximo %d caracteres imprimibles", maxConversationIDLen), http.StatusBadRequest)
+		return
+	}
+	if req.Campaign != "" && !campaignRe.MatchString(req.Campaign) {
+		http.Error(w, "campaign inválida: use hasta 100 letras, dígitos, '.', '_', ':' o '-'", http.StatusBadRequest)
+		return
+	}
+
+	e := storage.Email{
+		From:        sender.From,
+		ReplyTo:     sender.ReplyTo,
+		ReturnPath:  sender.ReturnPath,
+		To:          req.To,
+		Cc:          req.Cc,
+		Bcc:         req.Bcc,
+		Subject:     req.Subject,
+		Body:        req.Body,
+		TextBody:    req.TextBody,
+		TemplateID:  templateID,
+		Status:      "sending",
+		CallbackURL: req.CallbackURL,
+		Priority:    req.Priority,
+
+		SubjectTruncated: truncated,
+		RequestDSN:       req.RequestDSN,
+		CorrelationID:    requestID(r.Context()),
+		Bulk:             req.Bulk,
+		ListID:           req.ListID,
+		Headers:          req.Headers,
+		TimeoutSeconds:   req.TimeoutSeconds,
+		Campaign:         req.Campaign,
+		ContentType:      contentType,
+	}
+	if !req.SkipAuditCopy {
+		e.AuditBcc = mailer.GlobalBcc()
+	}
+
+	// En un hilo el correo responde al último enviado con el mismo
+	// conversation_id, para que los clientes de correo lo agrupen.
+	e.MessageID = mailer.NewMessageID(e.From)
+	if req.ConversationID != "" {
+		e.ConversationID = req.ConversationID
+		prev, err := h.Store.LastInConversation(r.Context(), req.ConversationID)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+		case err != nil:
+			http.Error(w, "Error en base de datos: "+err.Error(), 500)
+			return
+		case prev.MessageID != "":
+			e.InReplyTo = prev.MessageID
+			e.References = mailer.ThreadReferences(prev.References, prev.MessageID)
+		}
+	}
+	if req.PDF != nil {
+		if !pdf.Enabled() {
+			http.Error(w, "Adjuntos PDF deshabilitados", http.StatusBadRequest)
+			return
+		}
+		if req.PDF.HTML == "" {
+			http.Error(w, "Campo requerido: pdf.html", http.StatusBadRequest)
+			return
+		}
+		name := req.PDF.Filename
+		if name == "" {
+			name = "documento.pdf"
+		}
+		content, err := pdf.Render(r.Context(), req.PDF.HTML)
+		switch {
+		case err == nil:
+			e.Attachments = append(e.Attachments, storage.Attachment{
+				Filename:    name,
+				ContentType: "application/pdf",
+				Content:     content,
+			})
+		case pdf.FailurePolicy() == pdf.PolicyFail:
+			http.Error(w, "Error generando PDF: "+err.Error(), http.StatusBadGateway)
+			return
+		default:
+			log.Println("Error generando PDF, se envía sin adjunto:", err)
+			e.Warning = "No se pudo generar el PDF: " + err.Error()
+		}
+	}
+	e.Attachments = append(e.Attachments, files.Attachments()...)
+	if req.ExpiresAt != nil {
+		e.ExpiresAt = sql.NullTime{Time: *req.ExpiresAt, Valid: true}
+	}
+	if req.Date != nil {
+		e.DateHeader = sql.NullTime{Time: *req.Date, Valid: true}
+	}
+	scheduled := req.SendAt != nil && req.SendAt.After(time.Now())
+	switch {
+	case scheduled:
+		e.Status = "scheduled"
+		e.SendAt = sql.NullTime{Time: *req.SendAt, Valid: true}
+	case h.Async:
+		e.Status = "queued"
+	}
+
+	// Los correos de una campaña pausada se encolan y esperan a que se
+	// reanude.
+	pausedQueued := false
+	if e.Status == "sending" && e.Campaign != "" {
+		c, err := h.Store.GetCampaign(r.Context(), e.Campaign)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Error en base de datos: "+err.Error(), 500)
+			return
+		}
+		if c.Paused {
+			e.Status = "queued"
+			pausedQueued = true
+		}
+	}
+
+	// Con el cupo de calentamiento agotado el correo se encola y el worker
+	// lo envía cuando se renueve el cupo. El cupo es de la IP: cuenta los
+	// envíos de todos los inquilinos, como el worker.
+	warmupQueued := false
+	if e.Status == "sending" {
+		n, err := h.Warmup.Limit(storage.AllTenants(r.Context()), 1)
+		if err != nil {
+			http.Error(w, "Error en base de datos: "+err.Error(), 500)
+			return
+		}
+		if n == 0 {
+			e.Status = "queued"
+			warmupQueued = true
+		}
+	}
+
+	// Con el cupo por minuto de la plantilla agotado también se encola: el
+	// worker lo envía cuando el cupo deje sitio.
+	templateQueued := false
+	if e.Status == "sending" && templateCap > 0 {
+		used, err := h.Store.TemplateSendCounts(r.Context(), time.Now().Add(-time.Minute))
+		if err != nil {
+			http.Error(w, "Error en base de datos: "+err.Error(), 500)
+			return
+		}
+		if used[templateID.Int64] >= int64(templateCap) {
+			e.Status = "queued"
+			templateQueued = true
+		}
+	}
+
+	if e.Status == "sending" {
+		release, ok := h.acquireSend()
+		if !ok {
+			w.Header().Set("Retry-After", sendRetryAfter)
+			http.Error(w, "Demasiados envíos simultáneos, intente más tarde", http.StatusServiceUnavailable)
+			return
+		}
+		defer release()
+	}
+
+	limited := e.Status == "sending" && !h.Limiter.Allow()
+	h.setRateLimitHeaders(w)
+	if limited {
+		secs := int(math.Ceil(h.Limiter.RetryAfter().Seconds()))
+		w.Header().Set("Retry-After", strconv.Itoa(max(secs, 1)))
+		http.Error(w, "Límite global de envíos alcanzado", http.StatusTooManyRequests)
+		return
+	}
+
+	id, err := h.Store.InsertEmail(r.Context(), e)
+	if errors.Is(err, storage.ErrBodyTooLarge) {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error en base de datos: "+err.Error(), 500)
+		return
+	}
+
+	if e.Status != "sending" {
+		at := time.Now()
+		if scheduled {
+			at = *req.SendAt
+		}
+		if err := h.Queue.Enqueue(r.Context(), id, at); err != nil {
+			log.Printf("Error encolando correo %d: %v", id, err)
+		}
+		msg := "Correo encolado"
+		switch {
+		case scheduled:
+			msg = "Correo programado"
+		case warmupQueued:
+			msg = "Correo encolado: cupo diario de calentamiento agotado"
+		case templateQueued:
+			msg = "Correo encolado: límite por minuto de la plantilla alcanzado"
+		case pausedQueued:
+			msg = "Correo encolado: campaña pausada"
+		}
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(models.EmailResponse{
+			Success:    true,
+			Message:    msg,
+			Delivery:   models.DeliveryQueued,
+			Disposable: disposable,
+			Role:       role,
+
+			SubjectTruncated: truncated,
+			Warning:          e.Warning,
+			CorrelationID:    e.CorrelationID,
+			ID:               id,
+			Status:           e.Status,
+			MessageID:        e.MessageID,
+			Locale:           locale,
+		})
+		return
+	}
+
+	m := mailer.Message{
+		From:       e.From,
+		ReplyTo:    e.ReplyTo,
+		ReturnPath: e.ReturnPath,
+		To:         req.To,
+		Cc:         req.Cc,
+		Bcc:        req.Bcc,
+		Subject:    req.Subject,
+		Body:       req.Body,
+		TextBody:   req.TextBody,
+		Date:       e.DateHeader.Time,
+		RequestDSN: e.RequestDSN,
+
+		Attachments: mailAttachments(e.Attachments),
+		AuditBcc:    e.AuditBcc,
+		MessageID:   e.MessageID,
+		InReplyTo:   e.InReplyTo,
+		References:  e.References,
+		Bulk:        e.Bulk,
+		ListID:      e.ListID,
+		Headers:     e.Headers,
+		ContentType: e.ContentType,
+	}
+	// timeout_seconds tiene prioridad sobre HYBRID_SEND_TIMEOUT.
+	timeout := time.Duration(e.TimeoutSeconds) * time.Second
+	if timeout == 0 && h.Hybrid {
+		timeout = h.HybridTimeout
+	}
+	att, err := mailer.Deliver(m, timeout)
+	h.recordAttempt(r.Context(), id, att, err)
+
+	// En modo híbrido un timeout o un fallo transitorio no es un error: el
+	// intento cuenta y el worker reintenta el correo desde la cola.
+	if err != nil && h.Hybrid && mailer.Classify(err) == mailer.ClassTransient {
+		log.Printf("Envío de correo %d diferido a la cola: %v", id, err)
+		now := time.Now()
+		_ = h.Store.MarkRetry(r.Context(), id, err.Error(), now)
+		if err := h.Queue.Enqueue(r.Context(), id, now); err != nil {
+			log.Printf("Error encolando correo %d: %v", id, err)
+		}
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(models.EmailResponse{
+			Success:    true,
+			Message:    "Envío diferido: el correo se reintentará desde la cola",
+			Delivery:   models.DeliveryDeferred,
+			Disposable: disposable,
+			Role:       role,
+
+			SubjectTruncated: truncated,
+			Warning:          e.Warning,
+			CorrelationID:    e.CorrelationID,
+			ID:               id,
+			Status:           "retrying",
+			MessageID:        e.MessageID,
+			Locale:           locale,
+		})
+		return
+	}
+	if err != nil {
+		_ = h.Store.MarkFailed(r.Context(), id, err.Error(), mailer.Classify(err))
+		h.recordBounces(r.Context(), err)
+		h.notify(id, req.CallbackURL)
+		http.Error(w, "Error enviando correo: "+err.Error(), 500)
+		return
+	}
+
+	_ = h.Store.MarkSent(r.Context(), id)
+	h.notify(id, req.CallbackURL)
+	json.NewEncoder(w).Encode(models.EmailResponse{
+		Success:    true,
+		Message:    "Correo enviado exitosamente",
+		Delivery:   models.DeliverySent,
+		Disposable: disposable,
+		Role:       role,
+
+		SubjectTruncated: truncated,
+		Warning:          e.Warning,
+		CorrelationID:    e.CorrelationID,
+		ID:               id,
+		Status:           "sent",
+		MessageID:        e.MessageID,
+		Locale:           locale,
+	})
+}
+
+// recordAttempt guarda el intento de envío en el historial del correo.
+func (h *EmailHandler) recordAttempt(ctx context.Context, id int64, a mailer.Attempt, err error) {
+	at := storage.Attempt{
+		EmailID:    id,
+		Provider:   strings.Join(a.Relays, ","),
+		Code:       a.Code,
+		DurationMs: a.Duration.Milliseconds(),
+	}
+	if err != nil {
+		at.Error = err.Error()
+	}
+	for _, l := range a.Transcript {
+		at.Transcript = append(at.Transcript, storage.TranscriptLine(l))
+	}
+	if err := h.Store.RecordAttempt(ctx, at); err != nil {
+		log.Printf("Error registrando intento del correo %d: %v", id, err)
+	}
+	// Con un fallo parcial, los destinatarios que ya lo recibieron no se
+	// incluyen en el reintento.
+	if err != nil {
+		if err := h.Store.AddDelivered(ctx, id, a.Delivered); err != nil {
+			log.Printf("Error anotando destinatarios entregados del correo %d: %v", id, err)
+		}
+	}
+}
+
+// sendTemplate busca la plantilla de un envío por template_id o, si no hay,
+// por template_name. Con locale (o al buscar por nombre) se usa la variante
+// de ese nombre más cercana al idioma pedido; si ninguna encaja se queda la
+// plantilla del id.
+func (h *EmailHandler) sendTemplate(ctx context.Context, req models.EmailRequest) (storage.Template, error) {
+	if req.TemplateID <= 0 {
+		return h.Store.GetTemplateByNameLocale(ctx, req.TemplateName, req.Locale)
+	}
+	t, err := h.Store.GetTemplate(ctx, req.TemplateID)
+	if err != nil || req.Locale == "" {
+		return t, err
+	}
+	v, err := h.Store.GetTemplateByNameLocale(ctx, t.Name, req.Locale)
+	if errors.Is(err, sql.ErrNoRows) {
+		return t, nil
+	}
+	return v, err
+}
+
+// mailAttachments convierte los adjuntos guardados (o por guardar, con
+// Open) al formato del mailer.
+func mailAttachments(in []storage.Attachment) []mailer.Attachment {
+	out := make([]mailer.Attachment, 0, len(in))
+	for _, a := range in {
+		out = append(out, mailer.Attachment{Filename: a.Filename, ContentType: a.ContentType, Content: a.Content, Open: a.Open})
+	}
+	return out
+}
+
+// notify lanza en segundo plano el callback del correo, si tiene uno.
+func (h *EmailHandler) notify(id int64, callbackURL string) {
+	if callbackURL != "" {
+		go h.Callbacks.Notify(id)
+	}
+}
+
+// ==========================================================
+// /emails — LISTAR Y ELIMINAR EMAILS
+// ==========================================================
+
+func (h *EmailHandler) ListEmailsHandler(w http.ResponseWriter, r *http.Request) {
+	setHeaders(w)
+	if r.Method != http.MethodGet {
+		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	format := negotiate(r.Header.Get("Accept"), mimeJSON, mimeCSV)
+	if format == "" {
+		http.Error(w, "Formato no soportado: use application/json o text/csv", http.StatusNotAcceptable)
+		return
+	}
+
+	f, err := parseEmailFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Con limit se pide un correo más para saber si hay otra página.
+	limit := f.Limit
+	if limit > 0 {
+		f.Limit++
+	}
+
+	// fields=id,to,status limita las columnas devueltas.
+	if fields := parseFields(r.URL.Query().Get("fields")); len(fields) > 0 {
+		// Los cursores necesitan el id aunque no se haya pedido.
+		query, withID := fields, slices.Contains(fields, "id")
+		if limit > 0 && !withID {
+			query = append(slices.Clone(fields), "id")
+		}
+		items, err := h.Store.ListEmailFields(r.Context(), f, query)
+		if errors.Is(err, storage.ErrUnknownField) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		if limit == 0 {
+			if format == mimeCSV {
+				writeFieldsCSV(w, fields, items)
+				return
+			}
+			writeData(w, r, items)
+			return
+		}
+
+		ids := make([]int64, len(items))
+		for i, it := range items {
+			ids[i], _ = it["id"].(int64)
+			if !withID {
+				delete(it, "id")
+			}
+		}
+		lo, hi, next, prev := paginate(f, limit, ids)
+		items = items[lo:hi]
+		var csv func()
+		if format == mimeCSV {
+			csv = func() { writeFieldsCSV(w, fields, items) }
+		}
+		writePage(w, r, items, next, prev, csv)
+		return
+	}
+
+	items, err := h.Store.ListEmailsFiltered(r.Context(), f)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	if limit == 0 {
+		if format == mimeCSV {
+			writeEmailsCSV(w, items)
+			return
+		}
+		writeData(w, r, items)
+		return
+	}
+
+	ids := make([]int64, len(items))
+	for i, e := range items {
+		ids[i] = e.ID
+	}
+	lo, hi, next, prev := paginate(f, limit, ids)
+	items = items[lo:hi]
+	var csv func()
+	if format == mimeCSV {
+		csv = func() { writeEmailsCSV(w, items) }
+	}
+	writePage(w, r, items, next, prev, csv)
+}
+
+// parseEmailFilter lee los filtros de /emails: status, recipient,
+// from/to (RFC3339) y date_field (created_at | sent_at).
+func parseEmailFilter(r *http.Request) (storage.EmailFilter, error) {
+	q := r.URL.Query()
+	f := storage.EmailFilter{
+		Status:         q.Get("status"),
+		CallbackStatus: q.Get("callback_status"),
+		CorrelationID:  q.Get("correlation_id"),
+		ConversationID: q.Get("conversation_id"),
+		Recipient:      q.Get("recipient"),
+		Campaign:       q.Get("campaign"),
+		ErrorContains:  q.Get("error_contains"),
+		DateField:      q.Get("date_field"),
+	}
+
+	if f.DateField != "" && f.DateField != "created_at" && f.DateField != "sent_at" {
+		return f, fmt.Errorf("date_field inválido: use created_at o sent_at")
+	}
+
+	var err error
+	if v := q.Get("from"); v != "" {
+		if f.From, err = time.Parse(time.RFC3339, v); err != nil {
+			return f, fmt.Errorf("from inválido: se espera RFC3339")
+		}
+	}
+	if v := q.Get("to"); v != "" {
+		if f.To, err = time.Parse(time.RFC3339, v); err != nil {
+			return f, fmt.Errorf("to inválido: se espera RFC3339")
+		}
+	}
+	if !f.From.IsZero() && !f.To.IsZero() && f.From.After(f.To) {
+		return f, fmt.Errorf("rango inválido: from debe ser anterior o igual a to")
+	}
+
+	for _, p := range []struct {
+		name string
+		dst  *int64
+	}{{"after", &f.After}, {"before", &f.Before}} {
+		if v := q.Get(p.name); v != "" {
+			if *p.dst, err = strconv.ParseInt(v, 10, 64); err != nil || *p.dst <= 0 {
+				return f, fmt.Errorf("%s inválido: se espera el id de un correo", p.name)
+			}
+		}
+	}
+	if v := q.Get("limit"); v != "" {
+		if f.Limit, err = strconv.Atoi(v); err != nil || f.Limit <= 0 || f.Limit > maxPageSize {
+			return f, fmt.Errorf("limit inválido: debe estar entre 1 y %d", maxPageSize)
+		}
+	}
+	if v := q.Get("offset"); v != "" {
+		if f.Offset, err = strconv.Atoi(v); err != nil || f.Offset < 0 {
+			return f, fmt.Errorf("offset inválido")
+		}
+	}
+	if f.After > 0 && f.Before > 0 {
+		return f, fmt.Errorf("after y before son excluyentes")
+	}
+	if (f.After > 0 || f.Before > 0) && f.Offset > 0 {
+		return f, fmt.Errorf("offset no se combina con after/before")
+	}
+	if (f.After > 0 || f.Before > 0) && f.Limit == 0 {
+		f.Limit = defaultPageSize
+	}
+	return f, nil
+}
+
+// parseFields lee el parámetro fields de /emails: la lista de campos
+// separados por comas, en minúsculas y sin vacíos ni repetidos.
+func parseFields(v string) []string {
+	var out []string
+	seen := map[string]bool{}
+	for _, f := range strings.Split(v, ",") {
+		f = strings.ToLower(strings.TrimSpace(f))
+		if f == "" || seen[f] {
+			continue
+		}
+		seen[f] = true
+		out = append(out, f)
+	}
+	return out
+}
+
+const (
+	defaultPageSize = 100
+	maxPageSize     = 1000
+)
+
+// paginate recorta el elemento extra que se pide para saber si hay otra
+// página (limit+1) y calcula los cursores. ids son los ids del resultado en
+// orden de listado; [lo, hi) es el rango a devolver.
+func paginate(f storage.EmailFilter, limit int, ids []int64) (lo, hi int, next, prev int64) {
+	lo, hi = 0, len(ids)
+	more := len(ids) > limit
+	if more {
+		if f.Before > 0 {
+			lo = 1
+		} else {
+			hi = limit
+		}
+	}
+	if lo == hi {
+		return lo, hi, 0, 0
+	}
+	first, last := ids[lo], ids[hi-1]
+	if f.Before > 0 {
+		// El correo del cursor sigue a esta página.
+		next = last
+		if more {
+			prev = first
+		}
+		return lo, hi, next, prev
+	}
+	if more {
+		next = last
+	}
+	if f.After > 0 || f.Offset > 0 {
+		prev = first
+	}
+	return lo, hi, next, prev
+}
+
+// writePage escribe una página del listado con sus cursores, también en las
+// cabeceras X-Next-Cursor y X-Prev-Cursor para CSV y envelope=false.
+func writePage(w http.ResponseWriter, r *http.Request, data any, next, prev int64, csv func()) {
+	cursor := func(id int64) any {
+		if id == 0 {
+			return nil
+		}
+		return id
+	}
+	if next > 0 {
+		w.Header().Set("X-Next-Cursor", strconv.FormatInt(next, 10))
+	}
+	if prev > 0 {
+		w.Header().Set("X-Prev-Cursor", strconv.FormatInt(prev, 10))
+	}
+	if csv != nil {
+		csv()
+		return
+	}
+	if r.URL.Query().Get("envelope") == "false" {
+		json.NewEncoder(w).Encode(data)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]any{
+		"success":     true,
+		"data":        data,
+		"next_cursor": cursor(next),
+		"prev_cursor": cursor(prev),
+	})
+}
+
+func (h *EmailHandler) DeleteEmailHandler(w http.ResponseWriter, r *http.Request) {
+	setHeaders(w)
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+	idStr := strings.TrimPrefix(r.URL.Path, "/emails/")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil || id <= 0 {
+		http.Error(w, "ID inválido", 400)
+		return
+	}
+	err = h.Store.DeleteEmail(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Correo no encontrado", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	json.NewEncoder(w).Encode(models.EmailResponse{Success: true, Message: "Correo eliminado"})
+}
+
+// GET /emails/{id}/raw
+// Devuelve el mensaje MIME completo recompuesto con el mismo builder del envío.
+func (h *EmailHandler) RawEmailHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || id <= 0 {
+		http.Error(w, "ID inválido", 400)
+		return
+	}
+
+	e, err := h.Store.GetEmail(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Correo no encontrado", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	atts, err := h.Store.Attachments(r.Context(), e.ID)
+	if err != nil {
+		http.Error(w, "Error en base de datos: "+err.Error(), 500)
+		return
+	}
+
+	date := e.DateHeader.Time
+	if !e.DateHeader.Valid && e.SentAt.Valid {
+		date = e.SentAt.Time
+	}
+
+	w.Header().Set("Content-Type", "message/rfc822")
+	mailer.WriteMessage(w, mailer.Message{
+		From:       e.From,
+		ReplyTo:    e.ReplyTo,
+		ReturnPath: e.ReturnPath,
+		To:         e.To,
+		Cc:         e.Cc,
+		Bcc:        e.Bcc,
+		Subject:    e.Subject,
+		Body:       e.Body,
+		TextBody:   e.TextBody,
+		Date:       date,
+
+		Attachments: mailAttachments(atts),
+		MessageID:   e.MessageID,
+		InReplyTo:   e.InReplyTo,
+		References:  e.References,
+		Bulk:        e.Bulk,
+		ListID:      e.ListID,
+		Headers:     e.Headers,
+		ContentType: e.ContentType,
+	})
+}
+
+// GET /emails/{id}/position
+func (h *EmailHandler) QueuePositionHandler(w http.ResponseWriter, r *http.Request) {
+	setHeaders(w)
+	if r.Method != http.MethodGet {
+		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || id <= 0 {
+		http.Error(w, "ID inválido", 400)
+		return
+	}
+
+	status, pos, err := h.Store.QueuePosition(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Correo no encontrado", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	resp := map[string]any{"success": true, "id": id, "status": status, "position": pos}
+	if status != "queued" && status != "scheduled" && status != "retrying" {
+		resp["position"] = 0
+		resp["note"] = "El correo ya no está en cola"
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// GET /emails/{id}/attempts
+// Historial de intentos de envío del correo, del más antiguo al más reciente.
+func (h *EmailHandler) ListAttemptsHandler(w http.ResponseWriter, r *http.Request) {
+	setHeaders(w)
+	if r.Method != http.MethodGet {
+		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || id <= 0 {
+		http.Error(w, "ID inválido", 400)
+		return
+	}
+
+	if _, err := h.Store.GetEmail(r.Context(), id); errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Correo no encontrado", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Error en base de datos: "+err.Error(), 500)
+		return
+	}
+
+	list, err := h.Store.ListAttempts(r.Context(), id)
+	if err != nil {
+		http.Error(w, "Error en base de datos: "+err.Error(), 500)
+		return
+	}
+	writeData(w, r, list)
 }
 
-// ==========================================================
-// /send — ENVÍO DE CORREOS
-// ==========================================================
+// maxStatusIDs limita los ids de una consulta de estados por lotes.
+const maxStatusIDs = 1000
 
-func (h *EmailHandler) SendEmailHandler(w http.ResponseWriter, r *http.Request) {
+// POST /emails/status
+// Estado de varios correos en una sola llamada: {"ids": [1, 2, 3]} devuelve
+// un mapa id → {status, error, sent_at}. Los ids que no existen aparecen
+// con status "not_found".
+func (h *EmailHandler) EmailStatusesHandler(w http.ResponseWriter, r *http.Request) {
 	setHeaders(w)
 	if r.Method != http.MethodPost {
 		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
 		return
 	}
 
-	var req models.EmailRequest
+	var req models.StatusQueryRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-
-	if req.To == "" || req.Subject == "" || req.Body == "" {
-		http.Error(w, "Campos requeridos: to, subject, body", http.StatusBadRequest)
+	if len(req.IDs) == 0 {
+		http.Error(w, "Campo requerido: ids", http.StatusBadRequest)
+		return
+	}
+	if len(req.IDs) > maxStatusIDs {
+		http.Error(w, fmt.Sprintf("Demasiados ids: máximo %d", maxStatusIDs), http.StatusBadRequest)
 		return
 	}
 
-	id, err := h.Store.InsertQueued(r.Context(), req.To, req.Subject, req.Body)
+	found, err := h.Store.EmailStatuses(r.Context(), req.IDs)
 	if err != nil {
 		http.Error(w, "Error en base de datos: "+err.Error(), 500)
 		return
 	}
-
-	if err := h.sendSMTP(req.To, req.Subject, req.Body); err != nil {
-		_ = h.Store.MarkFailed(r.Context(), id, err.Error())
-		http.Error(w, "Error enviando correo: "+err.Error(), 500)
-		return
+	out := make(map[int64]storage.EmailStatus, len(req.IDs))
+	for _, id := range req.IDs {
+		st, ok := found[id]
+		if !ok {
+			st = storage.EmailStatus{Status: "not_found"}
+		}
+		out[id] = st
 	}
-
-	_ = h.Store.MarkSent(r.Context(), id)
-	json.NewEncoder(w).Encode(models.EmailResponse{
-		Success: true,
-		Message: "Correo enviado exitosamente",
-	})
+	writeData(w, r, out)
 }
 
-// ==========================================================
-// /emails — LISTAR Y ELIMINAR EMAILS
-// ==========================================================
-
-func (h *EmailHandler) ListEmailsHandler(w http.ResponseWriter, r *http.Request) {
+// POST /emails/delete
+func (h *EmailHandler) BulkDeleteEmailsHandler(w http.ResponseWriter, r *http.Request) {
 	setHeaders(w)
-	if r.Method != http.MethodGet {
+	if r.Method != http.MethodPost {
 		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
 		return
 	}
 
-	items, err := h.Store.ListEmails(r.Context())
+	// El cuerpo se conserva para que requireAdmin pueda verificar su firma.
+	raw, err := io.ReadAll(r.Body)
 	if err != nil {
-		http.Error(w, err.Error(), 500)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	r.Body = io.NopCloser(bytes.NewReader(raw))
 
-	json.NewEncoder(w).Encode(map[string]any{
-		"success": true,
-		"data":    items,
-	})
-}
-
-func (h *EmailHandler) DeleteEmailHandler(w http.ResponseWriter, r *http.Request) {
-	setHeaders(w)
-	if r.Method != http.MethodDelete {
-		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+	var req models.BulkDeleteRequest
+	if err := json.Unmarshal(raw, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	idStr := strings.TrimPrefix(r.URL.Path, "/emails/")
-	id, err := strconv.ParseInt(idStr, 10, 64)
-	if err != nil || id <= 0 {
-		http.Error(w, "ID inválido", 400)
+
+	var deleted int64
+	switch {
+	case len(req.IDs) > 0 && req.Filter != nil:
+		http.Error(w, "Use ids o filter, no ambos", http.StatusBadRequest)
+		return
+	case len(req.IDs) > 0:
+		deleted, err = h.Store.DeleteEmails(r.Context(), req.IDs)
+	case req.Filter != nil:
+		if !requireAdmin(w, r) {
+			return
+		}
+		if !req.Confirm {
+			http.Error(w, "El borrado por filtro requiere confirm: true", http.StatusBadRequest)
+			return
+		}
+		f := storage.DeleteFilter{Status: req.Filter.Status}
+		if req.Filter.Before != "" {
+			if f.Before, err = time.Parse(time.RFC3339, req.Filter.Before); err != nil {
+				http.Error(w, "before inválido: se espera RFC3339", http.StatusBadRequest)
+				return
+			}
+		}
+		if f.Status == "" && f.Before.IsZero() {
+			http.Error(w, "El filtro requiere status o before", http.StatusBadRequest)
+			return
+		}
+		deleted, err = h.Store.DeleteByFilter(r.Context(), f)
+	default:
+		http.Error(w, "Campos requeridos: ids o filter", http.StatusBadRequest)
 		return
 	}
-	if err := h.Store.DeleteEmail(r.Context(), id); err != nil {
+	if err != nil {
 		http.Error(w, err.Error(), 500)
 		return
 	}
-	json.NewEncoder(w).Encode(models.EmailResponse{Success: true, Message: "Correo eliminado"})
+
+	json.NewEncoder(w).Encode(map[string]any{"success": true, "deleted": deleted})
 }
 
 // ==========================================================
 // /CRUD  DE PLANTILLAS
 // ==========================================================
 
+// canonicalLocale normaliza el idioma de una plantilla (es_mx -> es-MX); uno
+// inválido se deja tal cual para que lo rechace validateTemplate.
+func canonicalLocale(locale string) string {
+	if l, err := storage.NormalizeLocale(locale); err == nil {
+		return l
+	}
+	return locale
+}
+
+// validateTemplate comprueba las direcciones fijas, que la plantilla compile
+// y que sus parciales no formen inclusiones cíclicas.
+func (h *EmailHandler) validateTemplate(ctx context.Context, t storage.Template) error {
+	if err := checkTemplateSize(t); err != nil {
+		return err
+	}
+	if t.SubjectMaxLen < 0 {
+		return fmt.Errorf("subject_max_len no puede ser negativo")
+	}
+	if t.MaxPerMinute < 0 {
+		return fmt.Errorf("max_per_minute no puede ser negativo")
+	}
+	if _, err := storage.NormalizeLocale(t.Locale); err != nil {
+		return err
+	}
+	if err := validateAddrs(append(t.Cc, t.Bcc...)); err != nil {
+		return err
+	}
+	if err := render.CheckSchema(t.VariablesSchema); err != nil {
+		return err
+	}
+	if t.ListID != "" {
+		if !t.Bulk {
+			return fmt.Errorf("list_id requiere bulk")
+		}
+		if err := mailer.CheckListID(t.ListID); err != nil {
+			return err
+		}
+	}
+	return h.Renderer.Check(ctx, t)
+}
+
 // POST /templates
 func (h *EmailHandler) CreateTemplateHandler(w http.ResponseWriter, r *http.Request) {
 	setHeaders(w)
@@ -137,12 +1529,7 @@ func (h *EmailHandler) CreateTemplateHandler(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	var t struct {
-		Name    string `json:"name"`
-		Subject string `json:"subject"`
-		Body    string `json:"body"`
-	}
-
+	var t models.TemplateRequest
 	if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
@@ -153,7 +1540,31 @@ func (h *EmailHandler) CreateTemplateHandler(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	id, err := h.Store.InsertTemplate(r.Context(), t.Name, t.Subject, t.Body)
+	tpl := storage.Template{
+		Name:    t.Name,
+		Subject: t.Subject,
+		Body:    t.Body,
+		Cc:      t.Cc,
+		Bcc:     t.Bcc,
+		Delims:  t.Delims,
+		Locale:  canonicalLocale(t.Locale),
+
+		SubjectMaxLen:   t.SubjectMaxLen,
+		VariablesSchema: t.VariablesSchema,
+		Bulk:            t.Bulk,
+		ListID:          t.ListID,
+		MaxPerMinute:    t.MaxPerMinute,
+	}
+	if err := h.validateTemplate(r.Context(), tpl); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	id, err := h.Store.InsertTemplate(r.Context(), tpl)
+	if errors.Is(err, storage.ErrDuplicateTemplateName) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
 	if err != nil {
 		http.Error(w, "Error al crear plantilla: "+err.Error(), 500)
 		return
@@ -162,6 +1573,49 @@ func (h *EmailHandler) CreateTemplateHandler(w http.ResponseWriter, r *http.Requ
 	json.NewEncoder(w).Encode(map[string]any{"success": true, "id": id})
 }
 
+// GET /templates
+func (h *EmailHandler) ListTemplatesHandler(w http.ResponseWriter, r *http.Request) {
+	setHeaders(w)
+	if r.Method != http.MethodGet {
+		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	list, err := h.Store.ListTemplates(r.Context())
+	if err != nil {
+		http.Error(w, "Error en base de datos: "+err.Error(), 500)
+		return
+	}
+	writeData(w, r, list)
+}
+
+// GET /templates/{id}
+func (h *EmailHandler) GetTemplateHandler(w http.ResponseWriter, r *http.Request) {
+	setHeaders(w)
+	if r.Method != http.MethodGet {
+		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	idStr := strings.TrimPrefix(r.URL.Path, "/templates/")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil || id <= 0 {
+		http.Error(w, "ID inválido", 400)
+		return
+	}
+
+	t, err := h.Store.GetTemplate(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Plantilla no encontrada", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error en base de datos: "+err.Error(), 500)
+		return
+	}
+	writeData(w, r, t)
+}
+
 // PUT /templates/{id}
 func (h *EmailHandler) UpdateTemplateHandler(w http.ResponseWriter, r *http.Request) {
 	setHeaders(w)
@@ -177,18 +1631,43 @@ func (h *EmailHandler) UpdateTemplateHandler(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	var t struct {
-		Name    string `json:"name"`
-		Subject string `json:"subject"`
-		Body    string `json:"body"`
+	var t models.TemplateRequest
+	if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+	tpl := storage.Template{
+		ID:      id,
+		Name:    t.Name,
+		Subject: t.Subject,
+		Body:    t.Body,
+		Cc:      t.Cc,
+		Bcc:     t.Bcc,
+		Delims:  t.Delims,
+		Locale:  canonicalLocale(t.Locale),
+
+		SubjectMaxLen:   t.SubjectMaxLen,
+		VariablesSchema: t.VariablesSchema,
+		Bulk:            t.Bulk,
+		ListID:          t.ListID,
+		MaxPerMinute:    t.MaxPerMinute,
+	}
+	if err := h.validateTemplate(r.Context(), tpl); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	if err := h.Store.UpdateTemplate(r.Context(), id, t.Name, t.Subject, t.Body); err != nil {
+	err = h.Store.UpdateTemplate(r.Context(), tpl)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Plantilla no encontrada", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, storage.ErrDuplicateTemplateName) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
 		http.Error(w, "Error al actualizar plantilla: "+err.Error(), 500)
 		return
 	}
@@ -211,7 +1690,12 @@ func (h *EmailHandler) DeleteTemplateHandler(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	if err := h.Store.DeleteTemplate(r.Context(), id); err != nil {
+	err = h.Store.DeleteTemplate(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Plantilla no encontrada", http.StatusNotFound)
+		return
+	}
+	if err != nil {
 		http.Error(w, "Error al eliminar plantilla: "+err.Error(), 500)
 		return
 	}
@@ -219,35 +1703,342 @@ func (h *EmailHandler) DeleteTemplateHandler(w http.ResponseWriter, r *http.Requ
 	json.NewEncoder(w).Encode(map[string]any{"success": true, "message": "Plantilla eliminada"})
 }
 
-// ==========================================================
-// SMTP ENVÍO DIRECTO
-// ==========================================================
+// GET /templates/export
+func (h *EmailHandler) ExportTemplatesHandler(w http.ResponseWriter, r *http.Request) {
+	setHeaders(w)
+	if r.Method != http.MethodGet {
+		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	list, err := h.Store.ListTemplates(r.Context())
+	if err != nil {
+		http.Error(w, "Error en base de datos: "+err.Error(), 500)
+		return
+	}
 
-func (h *EmailHandler) sendSMTP(to, subject, body string) error {
-	host := getEnv("SMTP_HOST", "smtp.gmail.com")
-	port := getEnv("SMTP_PORT", "587")
-	user := getEnv("SMTP_USERNAME", "")
-	pass := getEnv("SMTP_PASSWORD", "")
-	from := getEnv("FROM_EMAIL", user)
+	out := make([]models.TemplateRequest, 0, len(list))
+	for _, t := range list {
+		out = append(out, models.TemplateRequest{
+			Name:    t.Name,
+			Subject: t.Subject,
+			Body:    t.Body,
+			Cc:      t.Cc,
+			Bcc:     t.Bcc,
+			Delims:  t.Delims,
+			Locale:  t.Locale,
+
+			SubjectMaxLen:   t.SubjectMaxLen,
+			VariablesSchema: t.VariablesSchema,
+			Bulk:            t.Bulk,
+			ListID:          t.ListID,
+			MaxPerMinute:    t.MaxPerMinute,
+		})
+	}
+
+	w.Header().Set("Content-Disposition", `attachment; filename="templates.json"`)
+	json.NewEncoder(w).Encode(out)
+}
 
-	if user == "" || pass == "" {
-		return fmt.Errorf("SMTP no configurado")
+// POST /templates/import
+// Recibe el arreglo producido por /templates/export. Las plantillas inválidas
+// se omiten y se reportan como fallidas; las demás se guardan por nombre en
+// una sola transacción.
+func (h *EmailHandler) ImportTemplatesHandler(w http.ResponseWriter, r *http.Request) {
+	setHeaders(w)
+	if r.Method != http.MethodPost {
+		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+		return
 	}
 
-	addr := host + ":" + port
-	auth := smtp.PlainAuth("", user, pass, host)
+	var in []models.TemplateRequest
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	msg := bytes.NewBuffer(nil)
-	msg.WriteString(fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\n", from, to, subject))
-	msg.WriteString("MIME-Version: 1.0\r\nContent-Type: text/html; charset=UTF-8\r\n\r\n")
-	msg.WriteString(body)
+	var valid []storage.Template
+	failures := []map[string]string{}
+	for i, t := range in {
+		tpl := storage.Template{
+			Name:    t.Name,
+			Subject: t.Subject,
+			Body:    t.Body,
+			Cc:      t.Cc,
+			Bcc:     t.Bcc,
+			Delims:  t.Delims,
+			Locale:  canonicalLocale(t.Locale),
 
-	c := make(chan error, 1)
-	go func() { c <- smtp.SendMail(addr, auth, from, []string{to}, msg.Bytes()) }()
-	select {
-	case err := <-c:
-		return err
-	case <-time.After(30 * time.Second):
-		return fmt.Errorf("timeout en envío SMTP")
+			SubjectMaxLen:   t.SubjectMaxLen,
+			VariablesSchema: t.VariablesSchema,
+			Bulk:            t.Bulk,
+			ListID:          t.ListID,
+			MaxPerMinute:    t.MaxPerMinute,
+		}
+		var err error
+		if t.Name == "" || t.Subject == "" || t.Body == "" {
+			err = errors.New("Campos requeridos: name, subject, body")
+		} else {
+			err = h.validateTemplate(r.Context(), tpl)
+		}
+		if err != nil {
+			failures = append(failures, map[string]string{
+				"index": strconv.Itoa(i),
+				"name":  t.Name,
+				"error": err.Error(),
+			})
+			continue
+		}
+		valid = append(valid, tpl)
+	}
+
+	created, updated, err := h.Store.UpsertTemplates(r.Context(), valid)
+	if err != nil {
+		http.Error(w, "Error al importar plantillas: "+err.Error(), 500)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]any{
+		"success": true,
+		"created": created,
+		"updated": updated,
+		"failed":  len(failures),
+		"errors":  failures,
+	})
+}
+
+// POST /templates/{id}/test
+// Envía una muestra de la plantilla a to o a TEST_RECIPIENT, con las
+// variables dadas y valores de ejemplo para las que falten. El envío es
+// síncrono, no consulta la lista de supresión, no registra rebotes y se
+// guarda con test=true para que no cuente en las estadísticas.
+func (h *EmailHandler) TestTemplateHandler(w http.ResponseWriter, r *http.Request) {
+	setHeaders(w)
+	if r.Method != http.MethodPost {
+		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || id <= 0 {
+		http.Error(w, "ID inválido", 400)
+		return
+	}
+
+	var req models.TemplateTestRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.To == "" {
+		req.To = getEnv("TEST_RECIPIENT", "")
+	}
+	if req.To == "" {
+		http.Error(w, "Campo requerido: to (o configure TEST_RECIPIENT)", http.StatusBadRequest)
+		return
+	}
+	if err := mailer.CheckRecipients([]string{req.To}); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	t, err := h.Store.GetTemplate(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Plantilla no encontrada", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error en base de datos: "+err.Error(), 500)
+		return
+	}
+
+	out, err := h.Renderer.Render(r.Context(), t, render.PlaceholderVariables(t.VariablesSchema, req.Variables))
+	var verr *render.VariablesError
+	if errors.As(err, &verr) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]any{
+			"success": false,
+			"error":   "Variables inválidas para la plantilla",
+			"fields":  verr.Fields,
+		})
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	subject := mailer.NormalizeSubject("[Prueba] " + out.Subject)
+	body, err := h.Renderer.Layout(r.Context(), subject, out.Body)
+	if err != nil {
+		http.Error(w, "Error aplicando el layout: "+err.Error(), 500)
+		return
+	}
+
+	e := storage.Email{
+		To:            req.To,
+		Subject:       subject,
+		Body:          body,
+		TemplateID:    sql.NullInt64{Int64: t.ID, Valid: true},
+		Status:        "sending",
+		CorrelationID: requestID(r.Context()),
+		Bulk:          t.Bulk,
+		ListID:        t.ListID,
+		Test:          true,
+	}
+	e.MessageID = mailer.NewMessageID(e.From)
+	e.ID, err = h.Store.InsertEmail(r.Context(), e)
+	if err != nil {
+		http.Error(w, "Error en base de datos: "+err.Error(), 500)
+		return
+	}
+
+	att, err := mailer.Deliver(mailer.Message{
+		To:        e.To,
+		Subject:   e.Subject,
+		Body:      e.Body,
+		MessageID: e.MessageID,
+		Bulk:      e.Bulk,
+		ListID:    e.ListID,
+	}, 0)
+	h.recordAttempt(r.Context(), e.ID, att, err)
+	if err != nil {
+		_ = h.Store.MarkFailed(r.Context(), e.ID, err.Error(), mailer.Classify(err))
+		http.Error(w, "Error enviando correo: "+err.Error(), 500)
+		return
+	}
+	_ = h.Store.MarkSent(r.Context(), e.ID)
+
+	json.NewEncoder(w).Encode(models.EmailResponse{
+		Success:       true,
+		Message:       "Correo de prueba enviado a " + e.To,
+		Delivery:      models.DeliverySent,
+		CorrelationID: e.CorrelationID,
+		ID:            e.ID,
+		Status:        "sent",
+		MessageID:     e.MessageID,
+		Locale:        t.Locale,
+	})
+}
+
+// POST /templates/bulk-replace
+// Sustituye find por replace en el cuerpo de todas las plantillas, en una
+// transacción y guardando antes una versión de cada plantilla modificada.
+// Con dry_run solo informa de las coincidencias. Si algún cuerpo resultante
+// no compila no se aplica nada. Requiere ADMIN_API_KEY.
+func (h *EmailHandler) BulkReplaceTemplatesHandler(w http.ResponseWriter, r *http.Request) {
+	setHeaders(w)
+	if r.Method != http.MethodPost {
+		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	var req models.TemplateReplaceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Find == "" {
+		http.Error(w, "Campo requerido: find", http.StatusBadRequest)
+		return
+	}
+	replace := func(s string) (string, int) {
+		return strings.ReplaceAll(s, req.Find, req.Replace), strings.Count(s, req.Find)
+	}
+	if req.Regex {
+		re, err := regexp.Compile(req.Find)
+		if err != nil {
+			http.Error(w, "find no es una expresión regular válida: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		replace = func(s string) (string, int) {
+			return re.ReplaceAllString(s, req.Replace), len(re.FindAllStringIndex(s, -1))
+		}
+	}
+
+	list, err := h.Store.ListTemplates(r.Context())
+	if err != nil {
+		http.Error(w, "Error en base de datos: "+err.Error(), 500)
+		return
+	}
+
+	var changes []storage.BodyChange
+	matched := []map[string]any{}
+	failures := []map[string]any{}
+	for _, t := range list {
+		body, n := replace(t.Body)
+		if n == 0 || body == t.Body {
+			continue
+		}
+		changes = append(changes, storage.BodyChange{ID: t.ID, OldBody: t.Body, NewBody: body})
+		matched = append(matched, map[string]any{"id": t.ID, "name": t.Name, "matches": n})
+
+		t.Body = body
+		err := checkTemplateSize(t)
+		if err == nil {
+			err = h.Renderer.Check(r.Context(), t)
+		}
+		if err != nil {
+			failures = append(failures, map[string]any{"id": t.ID, "name": t.Name, "error": err.Error()})
+		}
+	}
+	if len(failures) > 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]any{
+			"success": false,
+			"error":   "El reemplazo deja plantillas inválidas",
+			"errors":  failures,
+		})
+		return
+	}
+
+	if !req.DryRun && len(changes) > 0 {
+		err := h.Store.ReplaceTemplateBodies(r.Context(), changes, "bulk-replace")
+		if errors.Is(err, storage.ErrTemplateChanged) {
+			http.Error(w, "Una plantilla cambió durante el reemplazo; reintente", http.StatusConflict)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Error en base de datos: "+err.Error(), 500)
+			return
+		}
+	}
+	json.NewEncoder(w).Encode(map[string]any{
+		"success":   true,
+		"dry_run":   req.DryRun,
+		"changed":   len(changes),
+		"templates": matched,
+	})
+}
+
+// GET /templates/{id}/versions
+// Lista las versiones guardadas de la plantilla, de la más reciente a la
+// más antigua.
+func (h *EmailHandler) ListTemplateVersionsHandler(w http.ResponseWriter, r *http.Request) {
+	setHeaders(w)
+	if r.Method != http.MethodGet {
+		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || id <= 0 {
+		http.Error(w, "ID inválido", 400)
+		return
+	}
+
+	if _, err := h.Store.GetTemplate(r.Context(), id); errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Plantilla no encontrada", http.StatusNotFound)
+		return
+	}
+	list, err := h.Store.ListTemplateVersions(r.Context(), id)
+	if err != nil {
+		http.Error(w, "Error en base de datos: "+err.Error(), 500)
+		return
+	}
+	if list == nil {
+		list = []storage.TemplateVersion{}
 	}
+	writeData(w, r, list)
 }
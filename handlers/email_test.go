@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"mailer-service/models"
+	"mailer-service/storage"
+)
+
+// newTestHandler devuelve un EmailHandler en modo async sobre MemStore,
+// de modo que /send encola sin contactar con ningún relay.
+func newTestHandler(t *testing.T) (*EmailHandler, *storage.MemStore) {
+	t.Helper()
+	t.Setenv("SEND_MODE", "async")
+	s := storage.NewMemStore()
+	return NewEmailHandler(s), s
+}
+
+func postSend(h *EmailHandler, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.SendEmailHandler(w, httptest.NewRequest(http.MethodPost, "/send", strings.NewReader(body)))
+	return w
+}
+
+func TestSendEmailHandlerQueues(t *testing.T) {
+	h, s := newTestHandler(t)
+
+	w := postSend(h, `{"to":"Ana <ana@example.com>","subject":"Hola","body":"<p>Hola</p>","priority":3}`)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+	var resp models.EmailResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if !resp.Success || resp.Delivery != models.DeliveryQueued || resp.Status != "queued" || resp.ID == 0 {
+		t.Fatalf("respuesta inesperada: %+v", resp)
+	}
+
+	e, err := s.GetEmail(context.Background(), resp.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Status != "queued" || e.To != "Ana <ana@example.com>" || e.Subject != "Hola" || e.Priority != 3 {
+		t.Errorf("correo guardado inesperado: status %q, to %q, subject %q, priority %d", e.Status, e.To, e.Subject, e.Priority)
+	}
+}
+
+// Con content_type text/plain el cuerpo de la plantilla no se escapa como HTML.
+func TestSendEmailHandlerPlainTemplate(t *testing.T) {
+	h, s := newTestHandler(t)
+	ctx := context.Background()
+	tid, err := s.InsertTemplate(ctx, storage.Template{Name: "aviso", Subject: "Aviso", Body: "Hola {{.Nombre}}"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct{ contentType, want string }{
+		{"text/plain", "Hola O'Brien & Co"},
+		{"", "Hola O&#39;Brien &amp; Co"},
+	} {
+		w := postSend(h, fmt.Sprintf(`{"to":"a@example.com","template_id":%d,"variables":{"Nombre":"O'Brien & Co"},"content_type":%q,"skip_layout":true}`, tid, tt.contentType))
+		var resp models.EmailResponse
+		json.NewDecoder(w.Body).Decode(&resp)
+		if w.Code != http.StatusAccepted {
+			t.Fatalf("content_type %q: status %d %+v", tt.contentType, w.Code, resp)
+		}
+		e, err := s.GetEmail(ctx, resp.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e.Body != tt.want {
+			t.Errorf("content_type %q: cuerpo %q, se esperaba %q", tt.contentType, e.Body, tt.want)
+		}
+	}
+}
+
+func TestSendEmailHandlerRejects(t *testing.T) {
+	h, s := newTestHandler(t)
+	if _, err := s.RecordBounce(context.Background(), "rebota@example.com", "550 no existe", 1); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name, body, want string
+	}{
+		{"sin asunto", `{"to":"a@example.com","body":"b"}`, "Campos requeridos"},
+		{"JSON inválido", `{"to":`, ""},
+		{"dirección inválida", `{"to":"no-es-correo","subject":"s","body":"b"}`, ""},
+		{"suprimido", `{"to":"a@example.com","cc":["Rebota@example.com"],"subject":"s","body":"b"}`, "Destinatario suprimido"},
+		{"prioridad", `{"to":"a@example.com","subject":"s","body":"b","priority":11}`, "priority debe estar entre 0 y 10"},
+		{"timeout negativo", `{"to":"a@example.com","subject":"s","body":"b","timeout_seconds":-1}`, "timeout_seconds debe estar entre 0 y 60"},
+		{"timeout excesivo", `{"to":"a@example.com","subject":"s","body":"b","timeout_seconds":61}`, "timeout_seconds debe estar entre 0 y 60"},
+		// Convertidos a Duration desbordan int64: math.MaxInt64 queda en -1s y
+		// 18446744074 en unos 0,29s, que pasarían por debajo del máximo.
+		{"timeout que desborda", fmt.Sprintf(`{"to":"a@example.com","subject":"s","body":"b","timeout_seconds":%d}`, math.MaxInt64), "timeout_seconds debe estar entre 0 y 60"},
+		{"timeout que desborda a positivo", `{"to":"a@example.com","subject":"s","body":"b","timeout_seconds":18446744074}`, "timeout_seconds debe estar entre 0 y 60"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := postSend(h, tt.body)
+			if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), tt.want) {
+				t.Errorf("status %d %q, se esperaba 400 con %q", w.Code, w.Body, tt.want)
+			}
+		})
+	}
+
+	if counts, _ := s.CountByStatus(context.Background()); len(counts) > 0 {
+		t.Errorf("se guardaron correos rechazados: %v", counts)
+	}
+}
+
+func TestSendEmailHandlerMethod(t *testing.T) {
+	h, _ := newTestHandler(t)
+	w := httptest.NewRecorder()
+	h.SendEmailHandler(w, httptest.NewRequest(http.MethodGet, "/send", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET /send: status %d, se esperaba 405", w.Code)
+	}
+}
@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// ==========================================================
+// ENDPOINTS HABILITADOS / DESHABILITADOS
+// ==========================================================
+
+// endpointRule es una entrada de ENABLED_ENDPOINTS o DISABLED_ENDPOINTS:
+// "[MÉTODO] /ruta", donde una ruta terminada en "*" cubre todo lo que
+// empieza por ella. Sin método se aplica a todos.
+type endpointRule struct {
+	method string
+	path   string
+	prefix bool
+}
+
+func (e endpointRule) match(r *http.Request) bool {
+	if e.method != "" && e.method != r.Method {
+		return false
+	}
+	if e.prefix {
+		return strings.HasPrefix(r.URL.Path, e.path)
+	}
+	return r.URL.Path == e.path
+}
+
+// alwaysEnabled son las sondas del orquestador, que no se pueden
+// deshabilitar por error.
+var alwaysEnabled = map[string]bool{"/healthz": true, "/readyz": true}
+
+// parseEndpointRules interpreta una lista separada por comas, p. ej.
+// "DELETE /templates/*, /admin/*".
+func parseEndpointRules(s string) ([]endpointRule, error) {
+	var rules []endpointRule
+	for _, item := range strings.Split(s, ",") {
+		fields := strings.Fields(item)
+		var rule endpointRule
+		switch len(fields) {
+		case 0:
+			continue
+		case 1:
+			rule.path = fields[0]
+		case 2:
+			rule.method, rule.path = strings.ToUpper(fields[0]), fields[1]
+		default:
+			return nil, fmt.Errorf("endpoint inválido %q: se espera \"[MÉTODO] /ruta\"", strings.TrimSpace(item))
+		}
+		if !strings.HasPrefix(rule.path, "/") {
+			return nil, fmt.Errorf("endpoint inválido %q: la ruta debe empezar por /", strings.TrimSpace(item))
+		}
+		if p, ok := strings.CutSuffix(rule.path, "*"); ok {
+			rule.path, rule.prefix = p, true
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// Endpoints deshabilita rutas según la configuración: con ENABLED_ENDPOINTS
+// solo se sirven las que coinciden con alguna entrada, y DISABLED_ENDPOINTS
+// quita rutas (también de las habilitadas). Una ruta deshabilitada responde
+// DISABLED_ENDPOINT_STATUS (404 por defecto, o 405) y el intento se registra.
+// /healthz y /readyz siempre se sirven.
+func Endpoints(next http.Handler) (http.Handler, error) {
+	enabled, err := parseEndpointRules(getEnv("ENABLED_ENDPOINTS", ""))
+	if err != nil {
+		return nil, fmt.Errorf("ENABLED_ENDPOINTS: %w", err)
+	}
+	disabled, err := parseEndpointRules(getEnv("DISABLED_ENDPOINTS", ""))
+	if err != nil {
+		return nil, fmt.Errorf("DISABLED_ENDPOINTS: %w", err)
+	}
+	status := http.StatusNotFound
+	switch v := getEnv("DISABLED_ENDPOINT_STATUS", "404"); v {
+	case "404":
+	case "405":
+		status = http.StatusMethodNotAllowed
+	default:
+		return nil, fmt.Errorf("DISABLED_ENDPOINT_STATUS inválido: %q (use 404 o 405)", v)
+	}
+	if len(enabled) == 0 && len(disabled) == 0 {
+		return next, nil
+	}
+
+	matchAny := func(rules []endpointRule, r *http.Request) bool {
+		for _, rule := range rules {
+			if rule.match(r) {
+				return true
+			}
+		}
+		return false
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		off := (len(enabled) > 0 && !matchAny(enabled, r)) || matchAny(disabled, r)
+		if off && !alwaysEnabled[r.URL.Path] {
+			log.Printf("Petición a endpoint deshabilitado: %s %s (request_id=%s, origen=%s)",
+				r.Method, r.URL.Path, requestID(r.Context()), r.RemoteAddr)
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}), nil
+}
@@ -0,0 +1,202 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"mailer-service/storage"
+)
+
+// ==========================================================
+// NEGOCIACIÓN DE CONTENIDO
+// ==========================================================
+
+const (
+	mimeJSON = "application/json"
+	mimeCSV  = "text/csv"
+)
+
+// negotiate elige el primer tipo de offers aceptado por la cabecera Accept,
+// respetando los pesos q. Sin cabecera Accept se devuelve offers[0];
+// si ninguno es aceptable devuelve "".
+func negotiate(accept string, offers ...string) string {
+	if strings.TrimSpace(accept) == "" {
+		return offers[0]
+	}
+
+	best, bestQ := "", 0.0
+	for _, part := range strings.Split(accept, ",") {
+		mt, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		if q <= bestQ {
+			continue
+		}
+		for _, o := range offers {
+			if mediaMatches(mt, o) {
+				best, bestQ = o, q
+				break
+			}
+		}
+	}
+	return best
+}
+
+func mediaMatches(pattern, offer string) bool {
+	if pattern == "*/*" || pattern == offer {
+		return true
+	}
+	if strings.HasSuffix(pattern, "/*") {
+		return strings.HasPrefix(offer, strings.TrimSuffix(pattern, "*"))
+	}
+	return false
+}
+
+// ==========================================================
+// EXPORTACIÓN CSV
+// ==========================================================
+
+var emailCSVHeader = []string{
+	"id", "to", "cc", "bcc", "subject", "body", "status", "error", "template_id", "created_at", "sent_at",
+}
+
+// writeEmailsCSV escribe los correos como CSV fila a fila.
+func writeEmailsCSV(w http.ResponseWriter, items []storage.Email) error {
+	w.Header().Set("Content-Type", mimeCSV+"; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="emails.csv"`)
+
+	cw := csv.NewWriter(w)
+	if err := cw.Write(emailCSVHeader); err != nil {
+		return err
+	}
+	for _, e := range items {
+		var templateID, sentAt string
+		if e.TemplateID.Valid {
+			templateID = strconv.FormatInt(e.TemplateID.Int64, 10)
+		}
+		if e.SentAt.Valid {
+			sentAt = e.SentAt.Time.Format(time.RFC3339)
+		}
+		if err := cw.Write([]string{
+			strconv.FormatInt(e.ID, 10),
+			e.To,
+			strings.Join(e.Cc, ","),
+			strings.Join(e.Bcc, ","),
+			e.Subject,
+			e.Body,
+			e.Status,
+			e.Error.String,
+			templateID,
+			e.CreatedAt.Format(time.RFC3339),
+			sentAt,
+		}); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// writeFieldsCSV escribe como CSV los correos devueltos por ListEmailFields,
+// con una columna por campo en el orden pedido.
+func writeFieldsCSV(w http.ResponseWriter, fields []string, items []map[string]any) error {
+	w.Header().Set("Content-Type", mimeCSV+"; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="emails.csv"`)
+
+	cw := csv.NewWriter(w)
+	if err := cw.Write(fields); err != nil {
+		return err
+	}
+	row := make([]string, len(fields))
+	for _, item := range items {
+		for i, name := range fields {
+			switch v := item[name].(type) {
+			case nil:
+				row[i] = ""
+			case time.Time:
+				row[i] = v.Format(time.RFC3339)
+			case []string:
+				row[i] = strings.Join(v, ",")
+			default:
+				row[i] = fmt.Sprint(v)
+			}
+		}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// ==========================================================
+// EXPORTACIÓN NDJSON (STREAMING)
+// ==========================================================
+
+const (
+	mimeNDJSON = "application/x-ndjson"
+
+	// streamFlushEvery es cada cuántos correos se vacía la respuesta.
+	streamFlushEvery = 100
+)
+
+// GET /emails/stream
+// Escribe los correos como JSON Lines (un objeto por línea) a medida que se
+// leen de la base de datos, con los mismos filtros que /emails. Pensado para
+// exportaciones grandes: el servidor no acumula el resultado en memoria.
+func (h *EmailHandler) StreamEmailsHandler(w http.ResponseWriter, r *http.Request) {
+	setHeaders(w)
+	if r.Method != http.MethodGet {
+		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	f, err := parseEmailFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if f.Before > 0 {
+		http.Error(w, "before no se admite en /emails/stream", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", mimeNDJSON)
+	rc := http.NewResponseController(w)
+	enc := json.NewEncoder(w)
+	n := 0
+	err = h.Store.StreamEmails(r.Context(), f, func(e storage.Email) error {
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+		if n++; n%streamFlushEvery == 0 {
+			return rc.Flush()
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error en /emails/stream tras %d correos: %v", n, err)
+		if n == 0 {
+			http.Error(w, "Error en base de datos: "+err.Error(), 500)
+			return
+		}
+		// Con la respuesta ya empezada no se puede cambiar el código: se
+		// corta la conexión para que el cliente no la tome por completa.
+		panic(http.ErrAbortHandler)
+	}
+	rc.Flush()
+}
@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"mailer-service/mailer"
+	"mailer-service/models"
+	"mailer-service/render"
+)
+
+// ==========================================================
+// /send/preview-recipients — DESTINATARIOS FINALES
+// ==========================================================
+
+// previewRecipient es una dirección del sobre que se usaría al enviar.
+type previewRecipient struct {
+	Address string `json:"address"`
+	Field   string `json:"field"`
+}
+
+// POST /send/preview-recipients
+// Valida To/Cc/Bcc (y los fijos de la plantilla) igual que /send y devuelve
+// los destinatarios que recibirían el correo, ya aplicados REDIRECT_ALL_TO y
+// GLOBAL_BCC. Si /send rechazaría la petición, responde would_reject con el
+// mismo error. No se envía nada.
+func (h *EmailHandler) PreviewRecipientsHandler(w http.ResponseWriter, r *http.Request) {
+	setHeaders(w)
+	if r.Method != http.MethodPost {
+		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req models.RecipientPreviewRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if strings.TrimSpace(req.To) == "" {
+		http.Error(w, "Campo requerido: to", http.StatusBadRequest)
+		return
+	}
+
+	if req.TemplateID > 0 {
+		t, err := h.Store.GetTemplate(r.Context(), req.TemplateID)
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Plantilla no encontrada", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Error en base de datos: "+err.Error(), 500)
+			return
+		}
+		req.Cc = mergeAddrs(req.Cc, t.Cc)
+		req.Bcc = mergeAddrs(req.Bcc, t.Bcc)
+	}
+
+	disposable, role, status, err := h.checkSendRecipients(r.Context(), append(append([]string{req.To}, req.Cc...), req.Bcc...))
+	if err != nil && status != http.StatusBadRequest {
+		http.Error(w, err.Error(), status)
+		return
+	}
+	var m mailer.Message
+	if err == nil {
+		m, err = mailer.ApplyRecipientPolicy(mailer.Message{To: req.To, Cc: req.Cc, Bcc: req.Bcc})
+	}
+	if err != nil {
+		writeData(w, r, map[string]any{
+			"would_reject": true,
+			"error":        err.Error(),
+		})
+		return
+	}
+
+	recipients := []previewRecipient{{Address: m.To, Field: "to"}}
+	for _, a := range m.Cc {
+		recipients = append(recipients, previewRecipient{Address: a, Field: "cc"})
+	}
+	for _, a := range m.Bcc {
+		recipients = append(recipients, previewRecipient{Address: a, Field: "bcc"})
+	}
+	if a := mailer.GlobalBcc(); a != "" {
+		recipients = append(recipients, previewRecipient{Address: a, Field: "audit_bcc"})
+	}
+	writeData(w, r, map[string]any{
+		"would_reject": false,
+		"recipients":   recipients,
+		"redirected":   m.Headers["X-Original-To"] != "",
+		"disposable":   disposable,
+		"role":         role,
+	})
+}
+
+// ==========================================================
+// /templates/{id}/preview — VISTA PREVIA RENDERIZADA
+// ==========================================================
+
+// renderedPreview es la plantilla renderizada. HTMLRaw es exactamente el HTML
+// que se envía; HTMLSanitized, una copia segura para mostrarla en el panel
+// que nunca se envía.
+type renderedPreview struct {
+	Subject       string `json:"subject"`
+	HTMLRaw       string `json:"html_raw"`
+	HTMLSanitized string `json:"html_sanitized"`
+	Text          string `json:"text"`
+}
+
+// POST /templates/{id}/preview
+// Renderiza la plantilla con las variables dadas (y valores de ejemplo para
+// las que falten) y el layout, como lo haría /send, sin enviar nada.
+func (h *EmailHandler) PreviewTemplateHandler(w http.ResponseWriter, r *http.Request) {
+	setHeaders(w)
+	if r.Method != http.MethodPost {
+		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || id <= 0 {
+		http.Error(w, "ID inválido", 400)
+		return
+	}
+
+	var req models.TemplatePreviewRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	t, err := h.Store.GetTemplate(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Plantilla no encontrada", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error en base de datos: "+err.Error(), 500)
+		return
+	}
+
+	out, err := h.Renderer.Render(r.Context(), t, render.PlaceholderVariables(t.VariablesSchema, req.Variables))
+	var verr *render.VariablesError
+	if errors.As(err, &verr) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]any{
+			"success": false,
+			"error":   "Variables inválidas para la plantilla",
+			"fields":  verr.Fields,
+		})
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	limit := t.SubjectMaxLen
+	if limit == 0 {
+		limit = subjectMaxLen()
+	}
+	subject, _ := render.TruncateSubject(mailer.NormalizeSubject(out.Subject), limit)
+	body, err := h.Renderer.Layout(r.Context(), subject, out.Body)
+	if err != nil {
+		http.Error(w, "Error aplicando el layout: "+err.Error(), 500)
+		return
+	}
+
+	raw, text := mailer.BodyParts(mailer.Message{Body: body})
+	writeData(w, r, renderedPreview{
+		Subject:       subject,
+		HTMLRaw:       raw,
+		HTMLSanitized: mailer.SanitizeHTML(raw),
+		Text:          text,
+	})
+}
@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type recipientPreview struct {
+	WouldReject bool               `json:"would_reject"`
+	Error       string             `json:"error"`
+	Recipients  []previewRecipient `json:"recipients"`
+	Redirected  bool               `json:"redirected"`
+}
+
+func postPreviewRecipients(t *testing.T, h *EmailHandler, body string) recipientPreview {
+	t.Helper()
+	w := httptest.NewRecorder()
+	h.PreviewRecipientsHandler(w, httptest.NewRequest(http.MethodPost, "/send/preview-recipients", strings.NewReader(body)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+	var resp struct {
+		Data recipientPreview `json:"data"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	return resp.Data
+}
+
+// La vista previa informa del mismo rechazo que /send en lugar de excluir
+// solo la dirección afectada.
+func TestPreviewRecipientsWouldReject(t *testing.T) {
+	h, s := newTestHandler(t)
+	if _, err := s.RecordBounce(context.Background(), "rebota@example.com", "550 no existe", 1); err != nil {
+		t.Fatal(err)
+	}
+	h.Verifier.BlockRole = true
+
+	for name, addrs := range map[string]string{
+		"suprimido": `"to":"ana@example.com","cc":["rebota@example.com"]`,
+		"sistema":   `"to":"ana@example.com","bcc":["postmaster@example.com"]`,
+		"inválido":  `"to":"ana@example.com","cc":["no es una dirección"]`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			send := postSend(h, `{`+addrs+`,"subject":"Hola","body":"<p>Hola</p>"}`)
+			if send.Code != http.StatusBadRequest {
+				t.Fatalf("/send respondió %d: %s", send.Code, send.Body)
+			}
+			got := postPreviewRecipients(t, h, `{`+addrs+`}`)
+			if !got.WouldReject || got.Error != strings.TrimSpace(send.Body.String()) || len(got.Recipients) > 0 {
+				t.Errorf("vista previa %+v, se esperaba el rechazo de /send %q", got, strings.TrimSpace(send.Body.String()))
+			}
+		})
+	}
+}
+
+// Con REDIRECT_ALL_TO y GLOBAL_BCC se muestran los destinatarios reales del
+// sobre, no los de la petición.
+func TestPreviewRecipientsRedirect(t *testing.T) {
+	h, _ := newTestHandler(t)
+	t.Setenv("REDIRECT_ALL_TO", "pruebas@example.com")
+	t.Setenv("GLOBAL_BCC", "auditoria@example.com")
+
+	got := postPreviewRecipients(t, h, `{"to":"ana@example.com","cc":["luis@example.com"],"bcc":["eva@example.com"]}`)
+	want := []previewRecipient{{"pruebas@example.com", "to"}, {"auditoria@example.com", "audit_bcc"}}
+	if got.WouldReject || !got.Redirected || len(got.Recipients) != len(want) {
+		t.Fatalf("vista previa %+v, se esperaban %v", got, want)
+	}
+	for i := range want {
+		if got.Recipients[i] != want[i] {
+			t.Errorf("destinatario %d: %+v, se esperaba %+v", i, got.Recipients[i], want[i])
+		}
+	}
+}
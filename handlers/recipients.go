@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"net/mail"
+	"strings"
+
+	"mailer-service/mailer"
+	"mailer-service/storage"
+)
+
+// ==========================================================
+// /recipients — REBOTES Y SUPRESIÓN
+// ==========================================================
+
+// GET /recipients?suppressed=true
+// Direcciones con rebotes permanentes (o solo las suprimidas).
+func (h *EmailHandler) ListRecipientsHandler(w http.ResponseWriter, r *http.Request) {
+	setHeaders(w)
+	if r.Method != http.MethodGet {
+		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	list, err := h.Store.ListRecipients(r.Context(), r.URL.Query().Get("suppressed") == "true")
+	if err != nil {
+		http.Error(w, "Error en base de datos: "+err.Error(), 500)
+		return
+	}
+	writeData(w, r, list)
+}
+
+// GET /recipients/{address}
+// Rebotes y supresión de una dirección; una dirección sin rebotes devuelve
+// bounces 0.
+func (h *EmailHandler) GetRecipientHandler(w http.ResponseWriter, r *http.Request) {
+	setHeaders(w)
+	addr, ok := recipientAddr(w, r)
+	if !ok {
+		return
+	}
+
+	rc, err := h.Store.GetRecipient(r.Context(), addr)
+	if errors.Is(err, sql.ErrNoRows) {
+		rc, err = storage.Recipient{Address: strings.ToLower(addr)}, nil
+	}
+	if err != nil {
+		http.Error(w, "Error en base de datos: "+err.Error(), 500)
+		return
+	}
+	writeData(w, r, rc)
+}
+
+// DELETE /recipients/{address}
+// Borra el historial de rebotes y levanta la supresión. Requiere ADMIN_API_KEY.
+func (h *EmailHandler) ResetRecipientHandler(w http.ResponseWriter, r *http.Request) {
+	setHeaders(w)
+	if !requireAdmin(w, r) {
+		return
+	}
+	addr, ok := recipientAddr(w, r)
+	if !ok {
+		return
+	}
+
+	err := h.Store.ResetRecipient(r.Context(), addr)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "La dirección no tiene rebotes", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error en base de datos: "+err.Error(), 500)
+		return
+	}
+	writeData(w, r, map[string]any{"address": strings.ToLower(addr), "reset": true})
+}
+
+func recipientAddr(w http.ResponseWriter, r *http.Request) (string, bool) {
+	a, err := mail.ParseAddress(r.PathValue("address"))
+	if err != nil {
+		http.Error(w, "Dirección inválida", http.StatusBadRequest)
+		return "", false
+	}
+	return a.Address, true
+}
+
+// recordBounces suma un rebote a cada destinatario rechazado de forma
+// permanente por el relay.
+func (h *EmailHandler) recordBounces(ctx context.Context, err error) {
+	for _, b := range mailer.HardBounces(err) {
+		rc, err := h.Store.RecordBounce(ctx, b.Addr, b.Err.Error(), storage.BounceThreshold())
+		if err != nil {
+			log.Printf("Error registrando rebote de %s: %v", b.Addr, err)
+			continue
+		}
+		if rc.Bounces == storage.BounceThreshold() {
+			log.Printf("Dirección %s suprimida tras %d rebotes", rc.Address, rc.Bounces)
+		}
+	}
+}
@@ -0,0 +1,240 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"mailer-service/models"
+	"mailer-service/scheduler"
+	"mailer-service/storage"
+)
+
+// ==========================================================
+// /recurring — ENVÍOS RECURRENTES
+// ==========================================================
+
+// recurringFromRequest valida la petición y construye la recurrencia,
+// calculando la próxima ejecución si está activa.
+func (h *EmailHandler) recurringFromRequest(r *http.Request, req models.RecurringRequest) (storage.Recurring, int, error) {
+	if req.Cron == "" || req.TemplateID <= 0 || len(req.Recipients) == 0 {
+		return storage.Recurring{}, http.StatusBadRequest, errors.New("Campos requeridos: cron, template_id, recipients")
+	}
+	sched, err := scheduler.Parse(req.Cron)
+	if err != nil {
+		return storage.Recurring{}, http.StatusBadRequest, err
+	}
+	if err := validateAddrs(req.Recipients); err != nil {
+		return storage.Recurring{}, http.StatusBadRequest, err
+	}
+	// GetTemplate solo ve las plantillas del inquilino de la petición, así
+	// que la recurrencia no puede usar la de otro.
+	t, err := h.Store.GetTemplate(r.Context(), req.TemplateID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return storage.Recurring{}, http.StatusNotFound, errors.New("Plantilla no encontrada")
+	} else if err != nil {
+		return storage.Recurring{}, 500, errors.New("Error en base de datos: " + err.Error())
+	}
+
+	rc := storage.Recurring{
+		Name:       req.Name,
+		Cron:       req.Cron,
+		TemplateID: req.TemplateID,
+		Recipients: mergeAddrs(req.Recipients),
+		Variables:  req.Variables,
+		Active:     req.Active == nil || *req.Active,
+		TenantID:   t.TenantID,
+	}
+	if rc.Active {
+		rc.NextRunAt = sql.NullTime{Time: sched.Next(time.Now()), Valid: true}
+	}
+	return rc, 0, nil
+}
+
+// recurringID lee el {id} de la ruta.
+func recurringID(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || id <= 0 {
+		http.Error(w, "ID inválido", 400)
+		return 0, false
+	}
+	return id, true
+}
+
+// GET /recurring
+func (h *EmailHandler) ListRecurringHandler(w http.ResponseWriter, r *http.Request) {
+	setHeaders(w)
+	if r.Method != http.MethodGet {
+		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	list, err := h.Store.ListRecurring(r.Context())
+	if err != nil {
+		http.Error(w, "Error en base de datos: "+err.Error(), 500)
+		return
+	}
+	writeData(w, r, list)
+}
+
+// POST /recurring
+func (h *EmailHandler) CreateRecurringHandler(w http.ResponseWriter, r *http.Request) {
+	setHeaders(w)
+	if r.Method != http.MethodPost {
+		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req models.RecurringRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	rc, code, err := h.recurringFromRequest(r, req)
+	if err != nil {
+		http.Error(w, err.Error(), code)
+		return
+	}
+
+	id, err := h.Store.InsertRecurring(r.Context(), rc)
+	if err != nil {
+		http.Error(w, "Error al crear recurrente: "+err.Error(), 500)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]any{"success": true, "id": id, "next_run_at": rc.NextRunAt.Time})
+}
+
+// GET /recurring/{id}
+func (h *EmailHandler) GetRecurringHandler(w http.ResponseWriter, r *http.Request) {
+	setHeaders(w)
+	if r.Method != http.MethodGet {
+		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+	id, ok := recurringID(w, r)
+	if !ok {
+		return
+	}
+
+	rc, err := h.Store.GetRecurring(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Recurrente no encontrado", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error en base de datos: "+err.Error(), 500)
+		return
+	}
+	writeData(w, r, rc)
+}
+
+// PUT /recurring/{id}
+func (h *EmailHandler) UpdateRecurringHandler(w http.ResponseWriter, r *http.Request) {
+	setHeaders(w)
+	if r.Method != http.MethodPut {
+		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+	id, ok := recurringID(w, r)
+	if !ok {
+		return
+	}
+
+	var req models.RecurringRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	rc, code, err := h.recurringFromRequest(r, req)
+	if err != nil {
+		http.Error(w, err.Error(), code)
+		return
+	}
+	rc.ID = id
+
+	err = h.Store.UpdateRecurring(r.Context(), rc)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Recurrente no encontrado", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error al actualizar recurrente: "+err.Error(), 500)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]any{"success": true, "message": "Recurrente actualizado"})
+}
+
+// DELETE /recurring/{id}
+func (h *EmailHandler) DeleteRecurringHandler(w http.ResponseWriter, r *http.Request) {
+	setHeaders(w)
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+	id, ok := recurringID(w, r)
+	if !ok {
+		return
+	}
+
+	err := h.Store.DeleteRecurring(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Recurrente no encontrado", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error al eliminar recurrente: "+err.Error(), 500)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]any{"success": true, "message": "Recurrente eliminado"})
+}
+
+// POST /recurring/{id}/pause y POST /recurring/{id}/resume
+func (h *EmailHandler) SetRecurringActiveHandler(active bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		setHeaders(w)
+		if r.Method != http.MethodPost {
+			http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+			return
+		}
+		id, ok := recurringID(w, r)
+		if !ok {
+			return
+		}
+
+		rc, err := h.Store.GetRecurring(r.Context(), id)
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Recurrente no encontrado", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Error en base de datos: "+err.Error(), 500)
+			return
+		}
+
+		// Al reanudar se parte desde ahora: no se envían las ocurrencias
+		// que cayeron durante la pausa.
+		var next sql.NullTime
+		if active {
+			sched, err := scheduler.Parse(rc.Cron)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			next = sql.NullTime{Time: sched.Next(time.Now()), Valid: true}
+		}
+
+		if err := h.Store.SetRecurringActive(r.Context(), id, active, next); err != nil {
+			http.Error(w, "Error al actualizar recurrente: "+err.Error(), 500)
+			return
+		}
+
+		msg := "Recurrente pausado"
+		if active {
+			msg = "Recurrente reanudado"
+		}
+		json.NewEncoder(w).Encode(map[string]any{"success": true, "message": msg, "next_run_at": next.Time})
+	}
+}
@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"mailer-service/storage"
+)
+
+// Las recurrencias son del inquilino de su plantilla: otro inquilino no las
+// lista y leerlas, modificarlas, pausarlas o borrarlas responde 404.
+func TestRecurringCrossTenant(t *testing.T) {
+	h, s := newTestHandler(t)
+	a := storage.WithTenant(context.Background(), "a")
+	b := storage.WithTenant(context.Background(), "b")
+	tplA, err := s.InsertTemplate(a, storage.Template{Name: "resumen", Subject: "Resumen", Body: "<p>Hola</p>"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tplB, err := s.InsertTemplate(b, storage.Template{Name: "resumen", Subject: "Resumen", Body: "<p>Hola</p>"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	call := func(ctx context.Context, handler http.HandlerFunc, method, target, body string, id int64) *httptest.ResponseRecorder {
+		t.Helper()
+		r := httptest.NewRequest(method, target, strings.NewReader(body)).WithContext(ctx)
+		if id > 0 {
+			r.SetPathValue("id", fmt.Sprint(id))
+		}
+		w := httptest.NewRecorder()
+		handler(w, r)
+		return w
+	}
+
+	w := call(a, h.CreateRecurringHandler, http.MethodPost, "/recurring",
+		fmt.Sprintf(`{"cron":"0 8 * * *","template_id":%d,"recipients":["ana@example.com"]}`, tplA), 0)
+	var created struct {
+		ID int64 `json:"id"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&created); err != nil || created.ID == 0 {
+		t.Fatalf("creando recurrencia: status %d, %v", w.Code, err)
+	}
+	id := created.ID
+	path := fmt.Sprintf("/recurring/%d", id)
+
+	w = call(b, h.ListRecurringHandler, http.MethodGet, "/recurring", "", 0)
+	if strings.Contains(w.Body.String(), "ana@example.com") {
+		t.Errorf("el inquilino b lista la recurrencia de a: %s", w.Body)
+	}
+	for _, tc := range []struct {
+		name           string
+		handler        http.HandlerFunc
+		method, target string
+		body           string
+	}{
+		{"leer", h.GetRecurringHandler, http.MethodGet, path, ""},
+		{"modificar", h.UpdateRecurringHandler, http.MethodPut, path,
+			fmt.Sprintf(`{"cron":"0 9 * * *","template_id":%d,"recipients":["luis@example.com"]}`, tplB)},
+		{"pausar", h.SetRecurringActiveHandler(false), http.MethodPost, path + "/pause", ""},
+		{"borrar", h.DeleteRecurringHandler, http.MethodDelete, path, ""},
+	} {
+		if w := call(b, tc.handler, tc.method, tc.target, tc.body, id); w.Code != http.StatusNotFound {
+			t.Errorf("%s como inquilino b: status %d %s, se esperaba 404", tc.name, w.Code, w.Body)
+		}
+	}
+
+	rc, err := s.GetRecurring(a, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rc.TenantID != "a" || rc.TemplateID != tplA || !rc.Active || rc.Cron != "0 8 * * *" {
+		t.Errorf("la recurrencia de a cambió: %+v", rc)
+	}
+}
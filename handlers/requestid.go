@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+)
+
+// ==========================================================
+// ID DE PETICIÓN / CORRELACIÓN
+// ==========================================================
+
+type requestIDKey struct{}
+
+// maxRequestIDLen evita guardar cabeceras arbitrariamente largas.
+const maxRequestIDLen = 128
+
+// RequestID toma el X-Request-ID o X-Correlation-ID entrante (o genera uno),
+// lo devuelve en X-Request-ID y lo deja en el contexto de la petición para
+// guardarlo con los correos.
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if id == "" {
+			id = r.Header.Get("X-Correlation-ID")
+		}
+		if id == "" || len(id) > maxRequestIDLen {
+			id = newRequestID()
+		}
+		w.Header().Set("X-Request-ID", id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+// requestID devuelve el id de correlación de la petición.
+func requestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// signedRequest firma una petición a target con method y la envía como
+// otherMethod a otherTarget, para comprobar qué partes cubre la firma.
+func signedRequest(secret, nonce, method, target, body, otherMethod, otherTarget string) *http.Request {
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts + "." + nonce + "." + method + "." + target + "." + body))
+
+	r := httptest.NewRequest(otherMethod, otherTarget, strings.NewReader(body))
+	r.Header.Set("X-Timestamp", ts)
+	r.Header.Set("X-Nonce", nonce)
+	r.Header.Set("X-Signature", hex.EncodeToString(mac.Sum(nil)))
+	return r
+}
+
+// La firma cubre el método y la ruta con su query: no se puede reutilizar
+// contra otra operación de administración.
+func TestVerifySignatureCoversMethodAndPath(t *testing.T) {
+	const secret = "secreto"
+	t.Setenv("ADMIN_HMAC_SECRET", secret)
+
+	for _, tc := range []struct {
+		name                string
+		method, target      string
+		sentMethod, sentURL string
+		want                int
+	}{
+		{"misma petición", "POST", "/admin/flush-queue", "POST", "/admin/flush-queue", http.StatusOK},
+		{"otra ruta", "POST", "/admin/flush-queue", "POST", "/admin/truncate-emails", http.StatusUnauthorized},
+		{"otro método", "GET", "/admin/config", "POST", "/admin/config", http.StatusUnauthorized},
+		{"otra query", "GET", "/admin/audit-log?limit=5", "GET", "/admin/audit-log?limit=500", http.StatusUnauthorized},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := signedRequest(secret, "nonce-"+tc.name, tc.method, tc.target, `{"a":1}`, tc.sentMethod, tc.sentURL)
+			w := httptest.NewRecorder()
+			ok := verifySignature(w, r)
+			if ok != (tc.want == http.StatusOK) || w.Code != tc.want {
+				t.Errorf("firma aceptada %v, status %d (%s), se esperaba %d", ok, w.Code, strings.TrimSpace(w.Body.String()), tc.want)
+			}
+		})
+	}
+}
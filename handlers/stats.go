@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"mailer-service/storage"
+	"mailer-service/warmup"
+)
+
+// ==========================================================
+// /stats — ESTADÍSTICAS
+// ==========================================================
+
+// GET /stats
+// Correos por estado y, durante el calentamiento de la IP (WARMUP_SCHEDULE),
+// el cupo del día y su uso; "warmup" es null si no hay límite.
+// "template_limits" lista las plantillas con max_per_minute y sus envíos del
+// último minuto.
+func (h *EmailHandler) StatsHandler(w http.ResponseWriter, r *http.Request) {
+	setHeaders(w)
+	if r.Method != http.MethodGet {
+		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	counts, err := h.StatusCounts(r.Context())
+	if err != nil {
+		http.Error(w, "Error en base de datos: "+err.Error(), 500)
+		return
+	}
+	// El cupo de calentamiento es de la IP, común a todos los inquilinos.
+	st, ok, err := h.Warmup.Status(storage.AllTenants(r.Context()), time.Now())
+	if err != nil {
+		http.Error(w, "Error en base de datos: "+err.Error(), 500)
+		return
+	}
+	var ramp *warmup.Status
+	if ok {
+		ramp = &st
+	}
+	limits, err := h.templateLimits(r.Context())
+	if err != nil {
+		http.Error(w, "Error en base de datos: "+err.Error(), 500)
+		return
+	}
+	writeData(w, r, map[string]any{"counts": counts, "warmup": ramp, "template_limits": limits})
+}
+
+// templateLimit es el uso del límite max_per_minute de una plantilla.
+type templateLimit struct {
+	ID           int64  `json:"id"`
+	Name         string `json:"name"`
+	Locale       string `json:"locale,omitempty"`
+	MaxPerMinute int    `json:"max_per_minute"`
+	LastMinute   int64  `json:"sent_last_minute"`
+}
+
+// templateLimits devuelve el uso de las plantillas con max_per_minute.
+func (h *EmailHandler) templateLimits(ctx context.Context) ([]templateLimit, error) {
+	list, err := h.Store.ListTemplates(ctx)
+	if err != nil {
+		return nil, err
+	}
+	out := []templateLimit{}
+	for _, t := range list {
+		if t.MaxPerMinute > 0 {
+			out = append(out, templateLimit{ID: t.ID, Name: t.Name, Locale: t.Locale, MaxPerMinute: t.MaxPerMinute})
+		}
+	}
+	if len(out) == 0 {
+		return out, nil
+	}
+	used, err := h.Store.TemplateSendCounts(ctx, time.Now().Add(-time.Minute))
+	if err != nil {
+		return nil, err
+	}
+	for i := range out {
+		out[i].LastMinute = used[out[i].ID]
+	}
+	return out, nil
+}
+
+// GET /stats/latency?from=...&to=...
+// Percentiles de latencia de envío (en segundos) de los correos enviados en
+// la ventana indicada; por defecto las últimas 24 horas.
+func (h *EmailHandler) LatencyStatsHandler(w http.ResponseWriter, r *http.Request) {
+	setHeaders(w)
+	if r.Method != http.MethodGet {
+		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := r.URL.Query()
+	to := time.Now()
+	if v := q.Get("to"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			http.Error(w, "to inválido: se espera RFC3339", http.StatusBadRequest)
+			return
+		}
+		to = t
+	}
+	from := to.Add(-24 * time.Hour)
+	if v := q.Get("from"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			http.Error(w, "from inválido: se espera RFC3339", http.StatusBadRequest)
+			return
+		}
+		from = t
+	}
+	if from.After(to) {
+		http.Error(w, "rango inválido: from debe ser anterior o igual a to", http.StatusBadRequest)
+		return
+	}
+
+	st, err := h.Store.SendLatency(r.Context(), from, to)
+	if err != nil {
+		http.Error(w, "Error en base de datos: "+err.Error(), 500)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]any{
+		"success": true,
+		"from":    from,
+		"to":      to,
+		"count":   st.Count,
+		"p50":     st.P50,
+		"p90":     st.P90,
+		"p95":     st.P95,
+		"p99":     st.P99,
+	})
+}
+
+// maxDailyRange limita los días de /stats/daily.
+const maxDailyRange = 366
+
+// GET /stats/daily?from=2024-01-01&to=2024-01-31&status=sent
+// Correos creados por día (UTC), con 0 en los días sin correos; por
+// defecto los últimos 30 días.
+func (h *EmailHandler) DailyStatsHandler(w http.ResponseWriter, r *http.Request) {
+	setHeaders(w)
+	if r.Method != http.MethodGet {
+		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := r.URL.Query()
+	to := time.Now().UTC()
+	if v := q.Get("to"); v != "" {
+		t, err := time.Parse(time.DateOnly, v)
+		if err != nil {
+			http.Error(w, "to inválido: se espera AAAA-MM-DD", http.StatusBadRequest)
+			return
+		}
+		to = t
+	}
+	from := to.AddDate(0, 0, -29)
+	if v := q.Get("from"); v != "" {
+		t, err := time.Parse(time.DateOnly, v)
+		if err != nil {
+			http.Error(w, "from inválido: se espera AAAA-MM-DD", http.StatusBadRequest)
+			return
+		}
+		from = t
+	}
+	if from.After(to) {
+		http.Error(w, "rango inválido: from debe ser anterior o igual a to", http.StatusBadRequest)
+		return
+	}
+	if to.Sub(from) >= maxDailyRange*24*time.Hour {
+		http.Error(w, fmt.Sprintf("rango inválido: como máximo %d días", maxDailyRange), http.StatusBadRequest)
+		return
+	}
+
+	days, err := h.Store.DailyCounts(r.Context(), from, to, q.Get("status"))
+	if err != nil {
+		http.Error(w, "Error en base de datos: "+err.Error(), 500)
+		return
+	}
+	writeData(w, r, days)
+}
@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"mailer-service/metrics"
+	"mailer-service/storage"
+)
+
+// ==========================================================
+// CACHÉ DE CORREOS POR ESTADO (STATS_CACHE_TTL)
+// ==========================================================
+
+// statsStatuses son los estados que se exponen como métricas
+// mailer_emails_<estado>.
+var statsStatuses = []string{"queued", "scheduled", "retrying", "sending", "sent", "failed", "expired"}
+
+// statsCacheTTL devuelve STATS_CACHE_TTL (por defecto 5s).
+func statsCacheTTL() time.Duration {
+	d, err := time.ParseDuration(getEnv("STATS_CACHE_TTL", "5s"))
+	if err != nil || d <= 0 {
+		return 5 * time.Second
+	}
+	return d
+}
+
+// statsKey separa las entradas de la caché por inquilino; la global (sin
+// inquilino) es la que se refresca en segundo plano.
+type statsKey struct {
+	tenant string
+	scoped bool
+}
+
+type statsEntry struct {
+	counts    map[string]int64
+	fetchedAt time.Time
+}
+
+// statsCache guarda el resultado de CountByStatus durante STATS_CACHE_TTL
+// para que /stats, /healthz?detail=true, las métricas y el control de cola no
+// lancen un COUNT por petición. Los mapas guardados no se modifican nunca:
+// cada refresco crea uno nuevo.
+type statsCache struct {
+	mu      sync.RWMutex
+	entries map[statsKey]statsEntry
+
+	// fetch serializa las consultas: las peticiones que encuentran la
+	// entrada caducada esperan a una sola.
+	fetch sync.Mutex
+}
+
+func (c *statsCache) lookup(key statsKey, ttl time.Duration) (map[string]int64, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	e, ok := c.entries[key]
+	return e.counts, ok && time.Since(e.fetchedAt) < ttl
+}
+
+func (c *statsCache) store(key statsKey, counts map[string]int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = map[statsKey]statsEntry{}
+	}
+	c.entries[key] = statsEntry{counts: counts, fetchedAt: time.Now()}
+}
+
+// StatusCounts devuelve los correos por estado del inquilino del contexto
+// (o de todos, sin inquilino), consultando la base de datos como mucho una
+// vez cada STATS_CACHE_TTL. El mapa devuelto no debe modificarse.
+func (h *EmailHandler) StatusCounts(ctx context.Context) (map[string]int64, error) {
+	key := statsKey{}
+	key.tenant, key.scoped = storage.TenantFrom(ctx)
+	ttl := statsCacheTTL()
+	if counts, ok := h.stats.lookup(key, ttl); ok {
+		return counts, nil
+	}
+
+	h.stats.fetch.Lock()
+	defer h.stats.fetch.Unlock()
+	if counts, ok := h.stats.lookup(key, ttl); ok {
+		return counts, nil
+	}
+	counts, err := h.Store.CountByStatus(ctx)
+	if err != nil {
+		return nil, err
+	}
+	h.stats.store(key, counts)
+	return counts, nil
+}
+
+// RefreshStats refresca los conteos globales cada STATS_CACHE_TTL hasta que
+// ctx termine, de modo que las métricas y el control de cola casi nunca
+// esperan a la base de datos.
+func (h *EmailHandler) RefreshStats(ctx context.Context) {
+	ttl := statsCacheTTL()
+	t := time.NewTicker(ttl)
+	defer t.Stop()
+	for {
+		h.stats.fetch.Lock()
+		counts, err := h.Store.CountByStatus(storage.AllTenants(ctx))
+		if err == nil {
+			h.stats.store(statsKey{}, counts)
+		}
+		h.stats.fetch.Unlock()
+		if err != nil && ctx.Err() == nil {
+			log.Println("Error refrescando correos por estado:", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// registerStatsMetrics expone los conteos globales de la caché como
+// mailer_emails_<estado>, sin consultar la base de datos al leerlos.
+func (h *EmailHandler) registerStatsMetrics() {
+	for _, status := range statsStatuses {
+		metrics.NewGaugeFunc("mailer_emails_"+status, "Correos en estado "+status+" (ver STATS_CACHE_TTL).",
+			func() float64 {
+				// Vale el último valor aunque haya caducado.
+				counts, _ := h.stats.lookup(statsKey{}, 0)
+				return float64(counts[status])
+			})
+	}
+}
@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"slices"
+	"strings"
+
+	"mailer-service/storage"
+)
+
+// ==========================================================
+// INQUILINOS (TENANT_API_KEYS)
+// ==========================================================
+
+// tenantKey es una clave de TENANT_API_KEYS con el inquilino que se deriva
+// de su prefijo.
+type tenantKey struct {
+	key    string
+	tenant string
+}
+
+// tenantKeys lee TENANT_API_KEYS, claves separadas por comas. El inquilino
+// de cada clave es su prefijo hasta el primer TENANT_KEY_SEPARATOR (por
+// defecto "_"): "acme_s3cr3t" pertenece a acme. Las claves sin prefijo se
+// ignoran.
+func tenantKeys() []tenantKey {
+	sep := getEnv("TENANT_KEY_SEPARATOR", "_")
+	var out []tenantKey
+	for _, k := range strings.Split(getEnv("TENANT_API_KEYS", ""), ",") {
+		k = strings.TrimSpace(k)
+		tenant, _, ok := strings.Cut(k, sep)
+		if !ok || tenant == "" {
+			continue
+		}
+		out = append(out, tenantKey{key: k, tenant: tenant})
+	}
+	return out
+}
+
+// tenantNames devuelve los inquilinos de TENANT_API_KEYS, sin repetir y
+// ordenados (sin sus claves).
+func tenantNames() []string {
+	names := []string{}
+	for _, k := range tenantKeys() {
+		if !slices.Contains(names, k.tenant) {
+			names = append(names, k.tenant)
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+// tenantExempt son las rutas que no llevan clave de inquilino: las sondas,
+// las métricas y la administración global (que usa ADMIN_API_KEY).
+func tenantExempt(path string) bool {
+	return alwaysEnabled[path] || path == "/metrics" || strings.HasPrefix(path, "/admin/")
+}
+
+// Tenant, con TENANT_API_KEYS configurado, exige una clave de inquilino en
+// X-API-Key y deja su inquilino en el contexto, de modo que el almacén solo
+// ve sus correos y plantillas (storage.WithTenant). Sin TENANT_API_KEYS el
+// servicio es de un solo inquilino y no se exige nada.
+func Tenant(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keys := tenantKeys()
+		if len(keys) == 0 || tenantExempt(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		got := []byte(r.Header.Get("X-API-Key"))
+		tenant := ""
+		for _, k := range keys {
+			if subtle.ConstantTimeCompare(got, []byte(k.key)) == 1 {
+				tenant = k.tenant
+			}
+		}
+		if tenant == "" {
+			http.Error(w, "No autorizado", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(storage.WithTenant(r.Context(), tenant)))
+	})
+}
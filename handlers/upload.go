@@ -0,0 +1,204 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"mailer-service/models"
+	"mailer-service/storage"
+)
+
+// ==========================================================
+// /send multipart/form-data — ADJUNTOS GRANDES
+// ==========================================================
+
+// metadataField es la parte del formulario con el JSON de EmailRequest.
+const metadataField = "metadata"
+
+// errTooLarge indica que un adjunto o el total supera el límite configurado.
+var errTooLarge = errors.New("adjuntos demasiado grandes")
+
+// spooledFile es un adjunto recibido por multipart y guardado en un archivo
+// temporal hasta que el correo se valida.
+type spooledFile struct {
+	Filename    string
+	ContentType string
+	Path        string
+}
+
+// uploads son los adjuntos de una petición multipart.
+type uploads []spooledFile
+
+// Cleanup borra los archivos temporales.
+func (u uploads) Cleanup() {
+	for _, f := range u {
+		os.Remove(f.Path)
+	}
+}
+
+// Attachments devuelve los adjuntos para guardarlos con el correo y
+// enviarlos: se leen de los archivos temporales al usarse, sin cargarlos en
+// memoria, así que deben usarse antes de Cleanup.
+func (u uploads) Attachments() []storage.Attachment {
+	out := make([]storage.Attachment, 0, len(u))
+	for _, f := range u {
+		path := f.Path
+		out = append(out, storage.Attachment{
+			Filename:    f.Filename,
+			ContentType: f.ContentType,
+			Open:        func() (io.ReadCloser, error) { return os.Open(path) },
+		})
+	}
+	return out
+}
+
+// attachmentLimits devuelve ATTACHMENT_MAX_BYTES (por archivo, 10 MiB por
+// defecto) y ATTACHMENTS_MAX_TOTAL_BYTES (por petición, 25 MiB por defecto).
+func attachmentLimits() (perFile, total int64) {
+	perFile, err := strconv.ParseInt(getEnv("ATTACHMENT_MAX_BYTES", "10485760"), 10, 64)
+	if err != nil || perFile <= 0 {
+		perFile = 10 << 20
+	}
+	total, err = strconv.ParseInt(getEnv("ATTACHMENTS_MAX_TOTAL_BYTES", "26214400"), 10, 64)
+	if err != nil || total <= 0 {
+		total = 25 << 20
+	}
+	return perFile, total
+}
+
+// maxAttachments devuelve MAX_ATTACHMENTS_PER_MESSAGE (20 por defecto).
+func maxAttachments() int {
+	n, err := strconv.Atoi(getEnv("MAX_ATTACHMENTS_PER_MESSAGE", "20"))
+	if err != nil || n < 0 {
+		return 20
+	}
+	return n
+}
+
+// checkAttachmentCount rechaza un correo con más de MAX_ATTACHMENTS_PER_MESSAGE
+// adjuntos, indicando cuántos tiene.
+func checkAttachmentCount(n int) error {
+	if max := maxAttachments(); n > max {
+		return fmt.Errorf("demasiados adjuntos: %d (máximo %d)", n, max)
+	}
+	return nil
+}
+
+// requestSlack es el margen sobre MAX_BODY_BYTES para el resto del JSON de
+// /send (destinatarios, variables, pdf...) y las cabeceras de las partes.
+const requestSlack = 1 << 20
+
+// maxRequestBytes es el máximo del JSON de /send (o de su parte metadata).
+func maxRequestBytes() int64 {
+	return int64(storage.MaxBodyBytes()) + requestSlack
+}
+
+// decodeSendRequest lee el cuerpo de /send: JSON o multipart/form-data. En
+// multipart la parte "metadata" lleva el JSON y cada parte con nombre de
+// archivo es un adjunto, que se copia por bloques a un archivo temporal sin
+// cargarlo entero en memoria. El JSON se limita a maxRequestBytes y la
+// petición multipart, además, a ATTACHMENTS_MAX_TOTAL_BYTES: al superarlos
+// se devuelve un *http.MaxBytesError sin seguir leyendo. El llamador debe
+// invocar Cleanup.
+func decodeSendRequest(w http.ResponseWriter, r *http.Request, req *models.EmailRequest) (uploads, error) {
+	mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mt != "multipart/form-data" {
+		return nil, json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBytes())).Decode(req)
+	}
+
+	perFile, total := attachmentLimits()
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBytes()+total)
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+	var files uploads
+	var used int64
+	// Los archivos que superan MAX_ATTACHMENTS_PER_MESSAGE solo se cuentan.
+	extra := 0
+	gotMetadata := false
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			files.Cleanup()
+			return nil, err
+		}
+
+		if part.FileName() == "" {
+			if part.FormName() == metadataField {
+				if err := json.NewDecoder(http.MaxBytesReader(w, part, maxRequestBytes())).Decode(req); err != nil {
+					files.Cleanup()
+					return nil, fmt.Errorf("metadata inválido: %w", err)
+				}
+				gotMetadata = true
+			}
+			continue
+		}
+
+		if len(files) >= maxAttachments() {
+			extra++
+			continue
+		}
+		limit := min(perFile, total-used)
+		f, n, err := spool(part, limit)
+		if err != nil {
+			files.Cleanup()
+			return nil, err
+		}
+		used += n
+		files = append(files, f)
+	}
+	if !gotMetadata {
+		files.Cleanup()
+		return nil, fmt.Errorf("falta la parte %q con el JSON del correo", metadataField)
+	}
+	if extra > 0 {
+		files.Cleanup()
+		return nil, checkAttachmentCount(len(files) + extra)
+	}
+	return files, nil
+}
+
+// spool copia una parte a un archivo temporal, con un máximo de limit bytes.
+func spool(part *multipart.Part, limit int64) (spooledFile, int64, error) {
+	tmp, err := os.CreateTemp("", "mailer-upload-*")
+	if err != nil {
+		return spooledFile{}, 0, err
+	}
+	defer tmp.Close()
+
+	// Se lee un byte más del límite para distinguir "justo en el límite"
+	// de "excedido".
+	n, err := io.Copy(tmp, io.LimitReader(part, limit+1))
+	if err == nil && n > limit {
+		err = errTooLarge
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return spooledFile{}, 0, err
+	}
+
+	name := filepath.Base(part.FileName())
+	ct := part.Header.Get("Content-Type")
+	if ct == "" || ct == "application/octet-stream" {
+		if byExt := mime.TypeByExtension(strings.ToLower(filepath.Ext(name))); byExt != "" {
+			ct = byExt
+		}
+	}
+	if ct == "" {
+		ct = "application/octet-stream"
+	}
+	return spooledFile{Filename: name, ContentType: ct, Path: tmp.Name()}, n, nil
+}
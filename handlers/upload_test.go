@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"mailer-service/models"
+)
+
+func TestCheckAttachmentCount(t *testing.T) {
+	t.Setenv("MAX_ATTACHMENTS_PER_MESSAGE", "3")
+	for _, n := range []int{0, 3} {
+		if err := checkAttachmentCount(n); err != nil {
+			t.Errorf("%d adjuntos: %v", n, err)
+		}
+	}
+	err := checkAttachmentCount(4)
+	if err == nil {
+		t.Fatal("4 adjuntos con máximo 3: se esperaba un error")
+	}
+	if want := "demasiados adjuntos: 4 (máximo 3)"; err.Error() != want {
+		t.Errorf("error %q, se esperaba %q", err, want)
+	}
+}
+
+// decodeMultipart pasa por decodeSendRequest un /send multipart con
+// metadata y files adjuntos de un byte.
+func decodeMultipart(t *testing.T, files int) error {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	mw.WriteField(metadataField, `{"to":"a@example.com","subject":"s","body":"b"}`)
+	for i := 0; i < files; i++ {
+		fw, err := mw.CreateFormFile("file", "f.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		fw.Write([]byte("x"))
+	}
+	mw.Close()
+
+	r := httptest.NewRequest("POST", "/send", &body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	var req models.EmailRequest
+	up, err := decodeSendRequest(httptest.NewRecorder(), r, &req)
+	up.Cleanup()
+	return err
+}
+
+// Un multipart con más archivos que MAX_ATTACHMENTS_PER_MESSAGE se rechaza
+// indicando el total recibido y sin dejar archivos temporales.
+func TestDecodeSendRequestTooManyAttachments(t *testing.T) {
+	t.Setenv("MAX_ATTACHMENTS_PER_MESSAGE", "2")
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+
+	if err := decodeMultipart(t, 2); err != nil {
+		t.Fatalf("2 adjuntos: %v", err)
+	}
+	err := decodeMultipart(t, 5)
+	if err == nil || err.Error() != "demasiados adjuntos: 5 (máximo 2)" {
+		t.Errorf("5 adjuntos: error %v", err)
+	}
+	if left, _ := filepath.Glob(filepath.Join(tmp, "mailer-upload-*")); len(left) > 0 {
+		t.Errorf("quedaron archivos temporales: %v", left)
+	}
+}
+
+// Los adjuntos subidos se guardan con el correo leyéndolos del archivo
+// temporal, que se borra al terminar la petición.
+func TestSendMultipartStoresAttachments(t *testing.T) {
+	h, s := newTestHandler(t)
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+	content := bytes.Repeat([]byte("adjunto\x00"), 300000)
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	mw.WriteField(metadataField, `{"to":"a@example.com","subject":"s","body":"b"}`)
+	fw, _ := mw.CreateFormFile("file", "datos.bin")
+	fw.Write(content)
+	mw.Close()
+	r := httptest.NewRequest(http.MethodPost, "/send", &body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	w := httptest.NewRecorder()
+	h.SendEmailHandler(w, r)
+
+	var resp models.EmailResponse
+	json.NewDecoder(w.Body).Decode(&resp)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("status %d: %+v", w.Code, resp)
+	}
+	atts, err := s.Attachments(context.Background(), resp.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(atts) != 1 || atts[0].Filename != "datos.bin" || !bytes.Equal(atts[0].Content, content) {
+		t.Fatalf("adjuntos guardados inesperados: %d", len(atts))
+	}
+	if left, _ := filepath.Glob(filepath.Join(tmp, "mailer-upload-*")); len(left) > 0 {
+		t.Errorf("quedaron archivos temporales: %v", left)
+	}
+}
+
+// Un JSON o una parte metadata mayor que MAX_BODY_BYTES más el margen se
+// rechaza con 413 sin leerlo entero.
+func TestSendRequestTooLarge(t *testing.T) {
+	h, _ := newTestHandler(t)
+	t.Setenv("MAX_BODY_BYTES", "1000")
+	big := `{"to":"a@example.com","subject":"s","body":"` + strings.Repeat("x", requestSlack+2000) + `"}`
+
+	w := postSend(h, big)
+	if w.Code != http.StatusRequestEntityTooLarge || !strings.Contains(w.Body.String(), "Petición demasiado grande") {
+		t.Errorf("JSON: status %d %q, se esperaba 413", w.Code, w.Body)
+	}
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	mw.WriteField(metadataField, big)
+	mw.Close()
+	r := httptest.NewRequest(http.MethodPost, "/send", &body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	w = httptest.NewRecorder()
+	h.SendEmailHandler(w, r)
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("metadata: status %d %q, se esperaba 413", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,34 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"mailer-service/models"
+)
+
+// ==========================================================
+// /validate — VALIDACIÓN DE DIRECCIONES
+// ==========================================================
+
+// POST /validate
+func (h *EmailHandler) ValidateHandler(w http.ResponseWriter, r *http.Request) {
+	setHeaders(w)
+	if r.Method != http.MethodPost {
+		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req models.ValidateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Email == "" {
+		http.Error(w, "Campo requerido: email", http.StatusBadRequest)
+		return
+	}
+
+	res := h.Verifier.Verify(r.Context(), req.Email)
+	writeData(w, r, res)
+}
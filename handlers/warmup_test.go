@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"mailer-service/storage"
+	"mailer-service/warmup"
+)
+
+// El cupo de calentamiento es de la IP: lo que envía un inquilino lo agota
+// también para los demás, tanto en /send como en /stats.
+func TestWarmupCapSharedAcrossTenants(t *testing.T) {
+	h, s := newTestHandler(t)
+	h.Async = false
+	h.Warmup = &warmup.Ramp{Store: s, Schedule: []int{1}, Start: time.Now().UTC()}
+
+	a := storage.WithTenant(context.Background(), "a")
+	b := storage.WithTenant(context.Background(), "b")
+	sentAt := sql.NullTime{Time: time.Now(), Valid: true}
+	if _, err := s.InsertEmail(a, storage.Email{To: "ana@example.com", Subject: "s", Body: "b", Status: "sent", SentAt: sentAt}); err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/send", strings.NewReader(`{"to":"luis@example.com","subject":"Hola","body":"<p>Hola</p>"}`))
+	h.SendEmailHandler(w, req.WithContext(b))
+	if w.Code != http.StatusAccepted || !strings.Contains(w.Body.String(), "calentamiento") {
+		t.Fatalf("/send del inquilino b: status %d %s, se esperaba encolado por el cupo de calentamiento", w.Code, w.Body)
+	}
+
+	w = httptest.NewRecorder()
+	h.StatsHandler(w, httptest.NewRequest(http.MethodGet, "/stats", nil).WithContext(b))
+	var resp struct {
+		Data struct {
+			Warmup *warmup.Status `json:"warmup"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if st := resp.Data.Warmup; st == nil || st.Sent != 1 || st.Remaining != 0 {
+		t.Errorf("/stats del inquilino b: warmup %+v, se esperaba sent 1 y remaining 0", st)
+	}
+}
@@ -0,0 +1,51 @@
+package mailer
+
+import (
+	"bytes"
+	"net/mail"
+	"testing"
+	"time"
+)
+
+// La cabecera Date que emite Build es RFC 5322: net/mail la interpreta y
+// representa el mismo instante, en la zona de MAIL_TIMEZONE.
+func TestBuildDateHeaderParses(t *testing.T) {
+	date := time.Date(2024, time.March, 9, 17, 4, 5, 0, time.UTC)
+	for _, tz := range []string{"", "UTC", "America/Guatemala", "Asia/Kolkata", "Zona/Inválida"} {
+		t.Run(tz, func(t *testing.T) {
+			t.Setenv("MAIL_TIMEZONE", tz)
+			raw := Build(Message{From: "a@example.com", To: "b@example.com", Subject: "s", Body: "<p>b</p>", Date: date})
+
+			msg, err := mail.ReadMessage(bytes.NewReader(raw))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := msg.Header.Date()
+			if err != nil {
+				t.Fatalf("Date %q no se interpreta: %v", msg.Header.Get("Date"), err)
+			}
+			if !got.Equal(date) {
+				t.Errorf("Date %q es %s, se esperaba %s", msg.Header.Get("Date"), got, date)
+			}
+		})
+	}
+}
+
+// Sin Date en el mensaje se usa la hora actual.
+func TestBuildDateHeaderDefaultsToNow(t *testing.T) {
+	before := time.Now().Truncate(time.Second)
+	raw := Build(Message{From: "a@example.com", To: "b@example.com", Subject: "s", Body: "b"})
+	after := time.Now()
+
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := msg.Header.Date()
+	if err != nil {
+		t.Fatalf("Date %q no se interpreta: %v", msg.Header.Get("Date"), err)
+	}
+	if got.Before(before) || got.After(after) {
+		t.Errorf("Date %s fuera de [%s, %s]", got, before, after)
+	}
+}
@@ -0,0 +1,31 @@
+package mailer
+
+import (
+	"fmt"
+	"strings"
+)
+
+// maxListIDLen limita List-Id, que debe caber en una línea de cabecera.
+const maxListIDLen = 255
+
+// CheckListID valida un List-Id (RFC 2919) como "boletin.example.com":
+// etiquetas de letras, dígitos, "-" o "_" separadas por puntos, al menos
+// dos. Sin espacios ni saltos de línea, de modo que no puede inyectar
+// cabeceras.
+func CheckListID(id string) error {
+	if len(id) > maxListIDLen {
+		return fmt.Errorf("list_id supera %d caracteres", maxListIDLen)
+	}
+	labels := strings.Split(id, ".")
+	if len(labels) < 2 {
+		return fmt.Errorf("list_id inválido %q: se espera un identificador con dominio, p. ej. boletin.example.com", id)
+	}
+	for _, l := range labels {
+		if l == "" || strings.IndexFunc(l, func(r rune) bool {
+			return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_')
+		}) >= 0 {
+			return fmt.Errorf("list_id inválido %q: solo letras, dígitos, '-', '_' y puntos", id)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package mailer
+
+import (
+	"errors"
+	"fmt"
+	"net/textproto"
+)
+
+// Clases de fallo de envío.
+const (
+	ClassTransient = "transient"
+	ClassPermanent = "permanent"
+)
+
+// Classify clasifica un error de Send según el código de respuesta SMTP:
+// 5xx es permanente y no debe reintentarse; 4xx, los errores de red y los
+// de configuración son transitorios. Si el envío se repartió entre varios
+// relays, el fallo solo es permanente cuando todos lo son.
+func Classify(err error) string {
+	if err == nil {
+		return ""
+	}
+	if j, ok := err.(interface{ Unwrap() []error }); ok {
+		for _, e := range j.Unwrap() {
+			if Classify(e) == ClassTransient {
+				return ClassTransient
+			}
+		}
+		return ClassPermanent
+	}
+
+	var te *textproto.Error
+	if errors.As(err, &te) && te.Code >= 500 && te.Code < 600 {
+		return ClassPermanent
+	}
+	return ClassTransient
+}
+
+// RecipientError es el rechazo de un destinatario concreto en RCPT TO.
+type RecipientError struct {
+	Addr string
+	Err  error
+}
+
+func (e *RecipientError) Error() string {
+	return fmt.Sprintf("destinatario %s rechazado: %v", e.Addr, e.Err)
+}
+
+func (e *RecipientError) Unwrap() error { return e.Err }
+
+// HardBounces devuelve los destinatarios rechazados de forma permanente
+// (5xx en RCPT TO) dentro de un error de Send.
+func HardBounces(err error) []*RecipientError {
+	if j, ok := err.(interface{ Unwrap() []error }); ok {
+		var out []*RecipientError
+		for _, e := range j.Unwrap() {
+			out = append(out, HardBounces(e)...)
+		}
+		return out
+	}
+	var re *RecipientError
+	if errors.As(err, &re) && Classify(re.Err) == ClassPermanent {
+		return []*RecipientError{re}
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package mailer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/textproto"
+	"testing"
+)
+
+func reply(code int, msg string) error {
+	return &textproto.Error{Code: code, Msg: msg}
+}
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"sin error", nil, ""},
+		{"421 servicio no disponible", reply(421, "4.3.2 Service not available, closing channel"), ClassTransient},
+		{"450 buzón ocupado", reply(450, "4.2.1 Mailbox busy"), ClassTransient},
+		{"451 greylisting", reply(451, "4.7.1 Greylisted, try again later"), ClassTransient},
+		{"452 sin espacio", reply(452, "4.3.1 Insufficient system storage"), ClassTransient},
+		{"535 credenciales", reply(535, "5.7.8 Authentication credentials invalid"), ClassPermanent},
+		{"550 buzón inexistente", reply(550, "5.1.1 User unknown"), ClassPermanent},
+		{"552 demasiado grande", reply(552, "5.3.4 Message size exceeds fixed limit"), ClassPermanent},
+		{"554 rechazado", reply(554, "5.7.1 Message rejected as spam"), ClassPermanent},
+		{"envuelto con %w", fmt.Errorf("smtp.example.com: %w", reply(550, "5.1.1 User unknown")), ClassPermanent},
+		{"rechazo en RCPT", &RecipientError{Addr: "a@example.com", Err: reply(550, "5.1.1 User unknown")}, ClassPermanent},
+		{"RCPT temporal", &RecipientError{Addr: "a@example.com", Err: reply(450, "4.2.0 Try later")}, ClassTransient},
+		{"conexión rechazada", &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}, ClassTransient},
+		{"timeout", context.DeadlineExceeded, ClassTransient},
+		{"configuración", errors.New("SMTP no configurado"), ClassTransient},
+		{"relays: permanente y transitorio", errors.Join(reply(550, "no"), reply(451, "luego")), ClassTransient},
+		{"relays: todos permanentes", errors.Join(reply(550, "no"), reply(554, "no")), ClassPermanent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Classify(tt.err); got != tt.want {
+				t.Errorf("Classify(%v) = %q, se esperaba %q", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHardBounces(t *testing.T) {
+	err := errors.Join(
+		fmt.Errorf("mx1: %w", &RecipientError{Addr: "no@example.com", Err: reply(550, "5.1.1 User unknown")}),
+		fmt.Errorf("mx2: %w", &RecipientError{Addr: "luego@example.com", Err: reply(450, "4.2.0 Try later")}),
+	)
+	got := HardBounces(err)
+	if len(got) != 1 || got[0].Addr != "no@example.com" {
+		t.Errorf("HardBounces = %v, se esperaba solo no@example.com", got)
+	}
+}
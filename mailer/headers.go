@@ -0,0 +1,86 @@
+package mailer
+
+import (
+	"fmt"
+	"net/textproto"
+	"slices"
+	"strings"
+)
+
+// Cabeceras personalizadas de /send ("headers"): solo se admiten cabeceras
+// X-* de la lista blanca (defaultCustomHeaders más CUSTOM_HEADERS_ALLOWED)
+// y nunca las reservadas, que añade el propio servicio o en las que confían
+// los filtros de los destinatarios. El valor se emite tal cual.
+
+// maxCustomHeaders limita las cabeceras personalizadas de un correo.
+const maxCustomHeaders = 20
+
+// maxHeaderLine es el largo máximo de una línea de cabecera (RFC 5322).
+const maxHeaderLine = 998
+
+var defaultCustomHeaders = []string{
+	"X-Entity-Ref-ID", // Gmail: evita que agrupe correos con el mismo asunto
+	"X-Campaign",
+	"X-Campaign-ID",
+	"X-Tag",
+	"X-PM-Tag",                // Postmark
+	"X-MC-Tags",               // Mandrill
+	"X-Mailgun-Tag",           // Mailgun
+	"X-SES-Configuration-Set", // Amazon SES
+}
+
+var reservedHeaders = []string{
+	"X-Original-To",
+	"X-Original-Cc",
+	"X-Originating-IP",
+	"X-Mailer",
+	"X-Spam-Flag",
+	"X-Spam-Status",
+	"X-Spam-Score",
+	"X-Forwarded-For",
+}
+
+// allowedCustomHeaders devuelve la lista blanca en forma canónica.
+func allowedCustomHeaders() []string {
+	out := make([]string, 0, len(defaultCustomHeaders))
+	for _, h := range defaultCustomHeaders {
+		out = append(out, textproto.CanonicalMIMEHeaderKey(h))
+	}
+	for _, h := range strings.Split(getEnv("CUSTOM_HEADERS_ALLOWED", ""), ",") {
+		if h = strings.TrimSpace(h); h != "" {
+			out = append(out, textproto.CanonicalMIMEHeaderKey(h))
+		}
+	}
+	return out
+}
+
+// CheckHeaders valida las cabeceras personalizadas de un correo: nombres
+// X-* de la lista blanca, ninguno reservado ni repetido (sin distinguir
+// mayúsculas) y valores ASCII imprimibles de una sola línea.
+func CheckHeaders(h map[string]string) error {
+	if len(h) > maxCustomHeaders {
+		return fmt.Errorf("demasiadas cabeceras: %d (máximo %d)", len(h), maxCustomHeaders)
+	}
+	allowed := allowedCustomHeaders()
+	seen := map[string]bool{}
+	for k, v := range h {
+		key := textproto.CanonicalMIMEHeaderKey(k)
+		if slices.ContainsFunc(reservedHeaders, func(r string) bool { return strings.EqualFold(r, k) }) {
+			return fmt.Errorf("cabecera reservada: %s", k)
+		}
+		if !strings.HasPrefix(key, "X-") || !slices.Contains(allowed, key) {
+			return fmt.Errorf("cabecera no permitida: %s", k)
+		}
+		if seen[key] {
+			return fmt.Errorf("cabecera repetida: %s", k)
+		}
+		seen[key] = true
+		if len(key)+2+len(v) > maxHeaderLine {
+			return fmt.Errorf("cabecera %s demasiado larga", k)
+		}
+		if strings.IndexFunc(v, func(r rune) bool { return r < ' ' || r > '~' }) >= 0 {
+			return fmt.Errorf("cabecera %s: el valor solo admite ASCII imprimible en una línea", k)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,190 @@
+package mailer
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// HTMLToText convierte un cuerpo HTML en texto plano legible para la parte
+// text/plain del mensaje: los enlaces se muestran como "texto (url)", <br> y
+// los bloques (<p>, <div>, títulos...) generan saltos de línea y las listas
+// se renderizan con viñetas o numeración.
+func HTMLToText(src string) string {
+	c := &textConverter{}
+	z := html.NewTokenizer(strings.NewReader(src))
+
+	for {
+		tt := z.Next()
+		switch tt {
+		case html.ErrorToken:
+			return c.result()
+		case html.TextToken:
+			if c.skip == 0 {
+				c.text(string(z.Text()))
+			}
+		case html.StartTagToken, html.SelfClosingTagToken:
+			tok := z.Token()
+			c.start(tok, tt == html.SelfClosingTagToken)
+		case html.EndTagToken:
+			c.end(z.Token())
+		}
+	}
+}
+
+type listState struct {
+	ordered bool
+	n       int
+}
+
+type linkState struct {
+	href  string
+	start int
+}
+
+type textConverter struct {
+	b     strings.Builder
+	skip  int // profundidad dentro de <head>, <style>, <script>...
+	pre   int
+	lists []listState
+	links []linkState
+}
+
+func (c *textConverter) start(t html.Token, selfClosing bool) {
+	switch t.Data {
+	case "head", "style", "script", "title":
+		if !selfClosing {
+			c.skip++
+		}
+	case "br":
+		c.b.WriteString("\n")
+	case "p", "h1", "h2", "h3", "h4", "h5", "h6", "table", "blockquote":
+		c.paragraph()
+	case "div", "tr", "section", "article", "header", "footer":
+		c.newline()
+	case "hr":
+		c.newline()
+		c.b.WriteString(strings.Repeat("-", 40))
+		c.newline()
+	case "pre":
+		c.paragraph()
+		c.pre++
+	case "ul", "ol":
+		c.newline()
+		c.lists = append(c.lists, listState{ordered: t.Data == "ol"})
+	case "li":
+		c.newline()
+		indent := ""
+		if len(c.lists) > 1 {
+			indent = strings.Repeat("  ", len(c.lists)-1)
+		}
+		if n := len(c.lists); n > 0 && c.lists[n-1].ordered {
+			c.lists[n-1].n++
+			c.b.WriteString(indent + strconv.Itoa(c.lists[n-1].n) + ". ")
+		} else {
+			c.b.WriteString(indent + "- ")
+		}
+	case "td", "th":
+		if out := c.b.String(); out != "" && !strings.HasSuffix(out, "\n") {
+			c.b.WriteString(" ")
+		}
+	case "a":
+		c.links = append(c.links, linkState{href: attr(t, "href"), start: c.b.Len()})
+	case "img":
+		if alt := strings.TrimSpace(attr(t, "alt")); alt != "" {
+			c.b.WriteString("[" + alt + "]")
+		}
+	}
+}
+
+func (c *textConverter) end(t html.Token) {
+	switch t.Data {
+	case "head", "style", "script", "title":
+		if c.skip > 0 {
+			c.skip--
+		}
+	case "p", "h1", "h2", "h3", "h4", "h5", "h6", "table", "blockquote":
+		c.paragraph()
+	case "div", "tr", "li", "section", "article", "header", "footer":
+		c.newline()
+	case "pre":
+		if c.pre > 0 {
+			c.pre--
+		}
+		c.paragraph()
+	case "ul", "ol":
+		if len(c.lists) > 0 {
+			c.lists = c.lists[:len(c.lists)-1]
+		}
+		c.newline()
+	case "a":
+		if len(c.links) == 0 {
+			return
+		}
+		l := c.links[len(c.links)-1]
+		c.links = c.links[:len(c.links)-1]
+
+		href := strings.TrimSpace(l.href)
+		label := strings.TrimSpace(c.b.String()[l.start:])
+		if href == "" || strings.HasPrefix(href, "#") || href == label ||
+			strings.TrimPrefix(href, "mailto:") == label {
+			return
+		}
+		c.b.WriteString(" (" + href + ")")
+	}
+}
+
+func (c *textConverter) text(s string) {
+	if c.pre > 0 {
+		c.b.WriteString(s)
+		return
+	}
+	s = spaces.ReplaceAllString(s, " ")
+	if out := c.b.String(); out == "" || strings.HasSuffix(out, "\n") || strings.HasSuffix(out, " ") {
+		s = strings.TrimLeft(s, " ")
+	}
+	c.b.WriteString(s)
+}
+
+func (c *textConverter) newline() {
+	if out := c.b.String(); out != "" && !strings.HasSuffix(out, "\n") {
+		c.b.WriteString("\n")
+	}
+}
+
+func (c *textConverter) paragraph() {
+	out := c.b.String()
+	switch {
+	case out == "" || strings.HasSuffix(out, "\n\n"):
+	case strings.HasSuffix(out, "\n"):
+		c.b.WriteString("\n")
+	default:
+		c.b.WriteString("\n\n")
+	}
+}
+
+var (
+	spaces     = regexp.MustCompile(`\s+`)
+	blankLines = regexp.MustCompile(`\n{3,}`)
+)
+
+func (c *textConverter) result() string {
+	lines := strings.Split(c.b.String(), "\n")
+	for i, l := range lines {
+		lines[i] = strings.TrimRight(l, " \t")
+	}
+	out := strings.Join(lines, "\n")
+	out = blankLines.ReplaceAllString(out, "\n\n")
+	return strings.TrimSpace(out)
+}
+
+func attr(t html.Token, name string) string {
+	for _, a := range t.Attr {
+		if a.Key == name {
+			return a.Val
+		}
+	}
+	return ""
+}
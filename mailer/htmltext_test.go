@@ -0,0 +1,69 @@
+package mailer
+
+import "testing"
+
+func TestHTMLToText(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{
+			name: "enlace con texto",
+			in:   `<p>Visite <a href="https://example.com/x">nuestra web</a>.</p>`,
+			want: "Visite nuestra web (https://example.com/x).",
+		},
+		{
+			name: "enlace cuyo texto es la url",
+			in:   `<a href="https://example.com">https://example.com</a>`,
+			want: "https://example.com",
+		},
+		{
+			name: "mailto y anclas sin url",
+			in:   `<a href="mailto:ana@example.com">ana@example.com</a> <a href="#arriba">Arriba</a>`,
+			want: "ana@example.com Arriba",
+		},
+		{
+			name: "lista sin orden",
+			in:   `<ul><li>uno</li><li>dos</li></ul>`,
+			want: "- uno\n- dos",
+		},
+		{
+			name: "lista numerada con anidada",
+			in:   `<ol><li>uno<ul><li>a</li></ul></li><li>dos</li></ol>`,
+			want: "1. uno\n  - a\n2. dos",
+		},
+		{
+			name: "entidades",
+			in:   `<p>O&#39;Brien &amp; Co &lt;ventas&gt; &eacute;xito&nbsp;total</p>`,
+			want: "O'Brien & Co <ventas> éxito total",
+		},
+		{
+			name: "sin script, style ni head",
+			in:   `<html><head><title>T</title><style>p{color:red}</style></head><body><script>alert(1)</script><p>Hola</p></body></html>`,
+			want: "Hola",
+		},
+		{
+			name: "párrafos, saltos y espacios",
+			in:   "<h1>Título</h1>\n<p>línea   uno<br>línea dos</p><p>fin</p>",
+			want: "Título\n\nlínea uno\nlínea dos\n\nfin",
+		},
+		{
+			name: "pre conserva los espacios",
+			in:   "<pre>a   b\n  c</pre>",
+			want: "a   b\n  c",
+		},
+		{
+			name: "imagen con alt",
+			in:   `<img src="logo.png" alt="Logo"> Hola`,
+			want: "[Logo] Hola",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := HTMLToText(tt.in); got != tt.want {
+				t.Errorf("HTMLToText(%q)\n = %q\nse esperaba %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,827 @@
+package mailer
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"mailer-service/metrics"
+	"mailer-service/tlsconf"
+)
+
+// Message es un correo listo para enviarse por SMTP.
+// Si From está vacío se usa el remitente configurado.
+type Message struct {
+	From string
+	// ReplyTo, si no está vacío, se emite como cabecera Reply-To.
+	ReplyTo string
+	// ReturnPath es el remitente del sobre (MAIL FROM), donde llegan los
+	// rebotes; si está vacío se usa la dirección de From.
+	ReturnPath string
+	To         string
+	Cc         []string
+	Bcc        []string
+	Subject    string
+	Body       string
+	// TextBody es la alternativa en texto plano; si está vacía se genera
+	// a partir de Body con HTMLToText.
+	TextBody string
+	// Date es la fecha de la cabecera Date; si es cero se usa la hora actual.
+	Date time.Time
+	// RequestDSN pide acuses de entrega (DSN) si el relay los soporta.
+	RequestDSN bool
+	// Attachments se adjuntan tras el cuerpo en un multipart/mixed.
+	Attachments []Attachment
+	// Headers son cabeceras adicionales, emitidas en orden alfabético.
+	Headers map[string]string
+	// AuditBcc recibe una copia silenciosa: solo se añade al sobre.
+	AuditBcc string
+	// MessageID, InReplyTo y References son las cabeceras de hilo; se
+	// omiten si están vacías (el relay añade entonces su Message-ID).
+	MessageID  string
+	InReplyTo  string
+	References []string
+	// Bulk marca un envío masivo con Precedence: bulk y Auto-Submitted para
+	// que los autorespondedores no contesten; ListID (ver CheckListID) se
+	// emite como List-Id.
+	Bulk   bool
+	ListID string
+	// ContentType ContentTypePlain envía Body tal cual como texto plano en
+	// una sola parte, sin alternativa HTML; vacío o ContentTypeHTML genera
+	// el multipart/alternative con TextBody y Body.
+	ContentType string
+	// Delivered son los destinatarios del sobre que ya aceptó un intento
+	// anterior (ver Attempt.Delivered); se omiten para no duplicarles el
+	// correo al reintentar.
+	Delivered []string
+}
+
+// Tipos de contenido admitidos para el cuerpo (ver NormalizeContentType).
+const (
+	ContentTypeHTML  = "text/html"
+	ContentTypePlain = "text/plain"
+)
+
+// NormalizeContentType valida el content_type de un envío y lo devuelve sin
+// parámetros (el juego de caracteres es siempre UTF-8). Vacío equivale a
+// text/html.
+func NormalizeContentType(ct string) (string, error) {
+	if ct == "" {
+		return ContentTypeHTML, nil
+	}
+	mt, _, err := mime.ParseMediaType(ct)
+	if err != nil || (mt != ContentTypeHTML && mt != ContentTypePlain) {
+		return "", fmt.Errorf("content_type inválido %q: use %s o %s", ct, ContentTypeHTML, ContentTypePlain)
+	}
+	return mt, nil
+}
+
+// Attachment es un fichero adjunto al mensaje.
+type Attachment struct {
+	Filename    string
+	ContentType string
+	Content     []byte
+	// Open, si no es nil, sustituye a Content: el adjunto se codifica
+	// leyéndolo de ahí, sin cargarlo entero, cada vez que se escribe el
+	// mensaje.
+	Open func() (io.ReadCloser, error)
+}
+
+// Send envía el mensaje usando la configuración SMTP del entorno. Los
+// destinatarios se reparten entre los relays de SMTP_ROUTES según su dominio
+// y el mismo mensaje se entrega a cada relay con su parte del sobre.
+func Send(m Message) error {
+	_, err := Deliver(m, 0)
+	return err
+}
+
+// SendTimeout es como Send, pero la entrega completa debe terminar antes de
+// timeout; si no, se aborta con un error de timeout (transitorio). Con
+// timeout 0 equivale a Send.
+func SendTimeout(m Message, timeout time.Duration) error {
+	_, err := Deliver(m, timeout)
+	return err
+}
+
+// Attempt describe un intento de entrega para el historial de un correo.
+type Attempt struct {
+	// Relays son los hosts SMTP a los que se intentó entregar, en orden.
+	Relays []string
+	// Code es el código SMTP de la respuesta: 250 si se aceptó, el del
+	// primer rechazo si falló y 0 si no hubo respuesta (red, timeout...).
+	Code     int
+	Duration time.Duration
+	// Transcript es la conversación SMTP, solo si el envío falló.
+	Transcript []TranscriptLine
+	// Delivered son los destinatarios del sobre que aceptaron en este
+	// intento los relays que no fallaron. Con un fallo parcial hay que
+	// pasarlos en Message.Delivered al reintentar.
+	Delivered []string
+}
+
+// Deliver es como SendTimeout y además devuelve el detalle del intento.
+func Deliver(m Message, timeout time.Duration) (Attempt, error) {
+	start := time.Now()
+	var a Attempt
+	err := send(m, timeout, &a)
+	a.Duration = time.Since(start)
+	a.Code = ReplyCode(err)
+	return a, err
+}
+
+// ReplyCode devuelve el código SMTP de un error de Send (250 si err es nil y
+// 0 si el error no trae respuesta del servidor).
+func ReplyCode(err error) int {
+	if err == nil {
+		return 250
+	}
+	var te *textproto.Error
+	if errors.As(err, &te) {
+		return te.Code
+	}
+	return 0
+}
+
+func send(m Message, timeout time.Duration, a *Attempt) error {
+	routes, err := Routes()
+	if err != nil {
+		return err
+	}
+	if m, err = ApplyRecipientPolicy(m); err != nil {
+		return err
+	}
+
+	if m.From == "" {
+		m.From = DefaultFrom()
+	}
+
+	// Bcc solo va en el sobre, nunca en las cabeceras.
+	rcpts := append(append([]string{m.To}, m.Cc...), m.Bcc...)
+	if m.AuditBcc != "" {
+		rcpts = append(rcpts, m.AuditBcc)
+	}
+	rcpts = slices.DeleteFunc(rcpts, func(rc string) bool {
+		return slices.ContainsFunc(m.Delivered, func(d string) bool { return strings.EqualFold(d, rc) })
+	})
+	if len(rcpts) == 0 {
+		return nil
+	}
+
+	order, groups := splitByRoute(routes, DefaultRelay(), rcpts)
+	start := time.Now()
+	t := &transcript{}
+	var errs []error
+	for _, r := range order {
+		a.Relays = append(a.Relays, r.Host)
+		t.relay = r.Host
+		deadline := time.Now().Add(smtpTimeout)
+		if timeout > 0 {
+			deadline = start.Add(timeout)
+		}
+		if err := sendVia(r, t, envelopeFrom(m), groups[r], m, deadline); err != nil {
+			t.event("error: %v", err)
+			errs = append(errs, fmt.Errorf("%s: %w", r.Host, err))
+			continue
+		}
+		a.Delivered = append(a.Delivered, groups[r]...)
+	}
+	if len(errs) > 0 {
+		a.Transcript = t.lines
+	}
+	return errors.Join(errs...)
+}
+
+// CheckRelay comprueba que el relay por defecto acepte conexiones: abre la
+// conexión, espera el saludo SMTP y cierra con QUIT sin autenticarse.
+func CheckRelay(timeout time.Duration) error {
+	r := DefaultRelay()
+	dialer := net.Dialer{Timeout: timeout}
+	if ip := localAddr(); ip != nil {
+		dialer.LocalAddr = &net.TCPAddr{IP: ip}
+	}
+	conn, err := dialer.Dial("tcp", net.JoinHostPort(r.Host, r.Port))
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+	c, err := smtp.NewClient(conn, r.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	return c.Quit()
+}
+
+// localAddr devuelve la IP de origen de SMTP_LOCAL_ADDR, o nil para que la
+// elija el sistema.
+func localAddr() net.IP {
+	return net.ParseIP(getEnv("SMTP_LOCAL_ADDR", ""))
+}
+
+// CheckLocalAddr valida al arrancar que SMTP_LOCAL_ADDR sea una IP asignada
+// a alguna interfaz de este host.
+func CheckLocalAddr() error {
+	v := getEnv("SMTP_LOCAL_ADDR", "")
+	if v == "" {
+		return nil
+	}
+	ip := net.ParseIP(v)
+	if ip == nil {
+		return fmt.Errorf("SMTP_LOCAL_ADDR inválido: %q no es una IP", v)
+	}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return err
+	}
+	for _, a := range addrs {
+		if n, ok := a.(*net.IPNet); ok && n.IP.Equal(ip) {
+			return nil
+		}
+	}
+	return fmt.Errorf("SMTP_LOCAL_ADDR: %s no está asignada a ninguna interfaz", ip)
+}
+
+// hasMechanism indica si mech aparece en la lista de mecanismos anunciada
+// en la extensión AUTH.
+func hasMechanism(mechs, mech string) bool {
+	for _, m := range strings.Fields(mechs) {
+		if strings.EqualFold(m, mech) {
+			return true
+		}
+	}
+	return false
+}
+
+// sendVia entrega m a rcpts a través del relay r en una sesión SMTP
+// manual: STARTTLS si el servidor lo anuncia, AUTH y, si se pide y el relay
+// anuncia DSN, MAIL FROM con RET=HDRS y RCPT TO con NOTIFY=SUCCESS,FAILURE.
+// El mensaje se escribe con WriteMessage directamente en DATA.
+//
+// La conversación se anota en t.
+func sendVia(r Relay, t *transcript, from string, rcpts []string, m Message, deadline time.Time) error {
+	dsn := m.RequestDSN
+	// Auth "none" omite la autenticación (solo para relays locales
+	// como MailHog/Mailpit). Por defecto se exige PLAIN con credenciales;
+	// "cram-md5" es para relays antiguos que no aceptan PLAIN.
+	var auth smtp.Auth
+	var mech string
+	switch r.Auth {
+	case "none":
+	case "plain", "cram-md5":
+		if r.Username == "" || r.Password == "" {
+			return fmt.Errorf("SMTP no configurado")
+		}
+		if r.Auth == "plain" {
+			mech, auth = "PLAIN", smtp.PlainAuth("", r.Username, r.Password, r.Host)
+		} else {
+			mech, auth = "CRAM-MD5", smtp.CRAMMD5Auth(r.Username, r.Password)
+		}
+	default:
+		return fmt.Errorf("SMTP_AUTH no soportado: %s", r.Auth)
+	}
+
+	c, err := dial(r, t, auth, mech, deadline)
+	if err != nil {
+		return err
+	}
+	defer releaseConn()
+	defer c.Close()
+
+	if dsn {
+		if ok, _ := c.Extension("DSN"); !ok {
+			log.Printf("%s no anuncia DSN: se envía sin acuse de entrega", r.Host)
+			dsn = false
+		}
+	}
+
+	if dsn {
+		err = cmd(c, 250, "MAIL FROM:<%s> RET=HDRS", from)
+	} else {
+		err = c.Mail(from)
+	}
+	if err != nil {
+		return err
+	}
+	for _, rc := range rcpts {
+		if a, err := mail.ParseAddress(rc); err == nil {
+			rc = a.Address
+		}
+		if dsn {
+			err = cmd(c, 25, "RCPT TO:<%s> NOTIFY=SUCCESS,FAILURE", rc)
+		} else {
+			err = c.Rcpt(rc)
+		}
+		if err != nil {
+			return &RecipientError{Addr: rc, Err: err}
+		}
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	t.mute = true
+	cw := &countWriter{w: w}
+	err = WriteMessage(cw, m)
+	t.mute = false
+	t.event("mensaje de %d bytes", cw.n)
+	if err != nil {
+		return err
+	}
+	t.mute = true
+	err = w.Close()
+	t.mute = false
+	if err != nil {
+		return err
+	}
+	messagesSent.Inc()
+	return c.Quit()
+}
+
+// dialBackoff es la espera antes del primer reintento de conexión; se
+// duplica en cada uno.
+const dialBackoff = 100 * time.Millisecond
+
+// dialRetries devuelve SMTP_DIAL_RETRIES (por defecto 2).
+func dialRetries() int {
+	n, err := strconv.Atoi(getEnv("SMTP_DIAL_RETRIES", "2"))
+	if err != nil || n < 0 {
+		return 2
+	}
+	return n
+}
+
+// dial abre la sesión SMTP con r hasta la autenticación incluida. Los fallos
+// de conexión (red, DNS, cierre inesperado o respuesta 4xx) se reintentan
+// hasta SMTP_DIAL_RETRIES veces dentro del mismo envío, independientemente
+// de los reintentos de la cola; un rechazo 5xx o un error de configuración
+// no se reintenta. Tras MAIL FROM nunca se reintenta.
+func dial(r Relay, t *transcript, auth smtp.Auth, mech string, deadline time.Time) (*smtp.Client, error) {
+	retries := dialRetries()
+	backoff := dialBackoff
+	for i := 0; ; i++ {
+		c, err := dialOnce(r, t, auth, mech, deadline)
+		if err == nil || i >= retries || !retryableDial(err) || time.Now().Add(backoff).After(deadline) {
+			return c, err
+		}
+		log.Printf("Error conectando a %s (intento %d), reintento en %s: %v", r.Host, i+1, backoff, err)
+		t.event("error: %v; reintento en %s", err, backoff)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// retryableDial indica si el error de conexión puede resolverse
+// reintentando al momento.
+func retryableDial(err error) bool {
+	var te *textproto.Error
+	if errors.As(err, &te) {
+		return te.Code >= 400 && te.Code < 500
+	}
+	var ne net.Error
+	return errors.As(err, &ne) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// dialOnce conecta, espera el saludo, negocia STARTTLS y se autentica. Si
+// falla cierra la conexión; si no, el llamador debe cerrar el cliente y
+// llamar a releaseConn.
+func dialOnce(r Relay, t *transcript, auth smtp.Auth, mech string, deadline time.Time) (c *smtp.Client, err error) {
+	dialer := net.Dialer{Deadline: deadline}
+	if ip := localAddr(); ip != nil {
+		dialer.LocalAddr = &net.TCPAddr{IP: ip}
+	}
+	addr := net.JoinHostPort(r.Host, r.Port)
+	t.connect(addr)
+	conn, err := dialer.Dial("tcp", addr)
+	if err != nil {
+		connFailed.Inc()
+		return nil, err
+	}
+	connOpened.Inc()
+	connInUse.Inc()
+	defer func() {
+		if err != nil {
+			conn.Close()
+			releaseConn()
+		}
+	}()
+	// Plazo para toda la conversación, como el timeout del envío anterior.
+	conn.SetDeadline(deadline)
+
+	c, err = smtp.NewClient(recordConn{Conn: conn, t: t}, r.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(tlsconf.Config(r.Host)); err != nil {
+			return nil, err
+		}
+		state, _ := c.TLSConnectionState()
+		c.Text = t.recordTLS(c.Text, state)
+	}
+	if auth != nil {
+		ok, mechs := c.Extension("AUTH")
+		if !ok {
+			return nil, fmt.Errorf("el servidor SMTP no soporta AUTH")
+		}
+		if !hasMechanism(mechs, mech) {
+			return nil, fmt.Errorf("el servidor SMTP no anuncia AUTH %s (anuncia: %s)", mech, mechs)
+		}
+		if err := c.Auth(auth); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// releaseConn actualiza las métricas al cerrar una conexión SMTP.
+func releaseConn() {
+	connInUse.Dec()
+	connClosed.Inc()
+}
+
+// Métricas de conexiones SMTP. Cada envío abre su propia conexión, así que
+// mailer_smtp_messages_total / mailer_smtp_connections_opened_total es el
+// número medio de mensajes por conexión.
+var (
+	connOpened   = metrics.NewCounter("mailer_smtp_connections_opened_total", "Conexiones SMTP abiertas.")
+	connClosed   = metrics.NewCounter("mailer_smtp_connections_closed_total", "Conexiones SMTP cerradas.")
+	connFailed   = metrics.NewCounter("mailer_smtp_connections_failed_total", "Intentos de conexión SMTP fallidos.")
+	connInUse    = metrics.NewGauge("mailer_smtp_connections_in_use", "Conexiones SMTP abiertas en este momento.")
+	messagesSent = metrics.NewCounter("mailer_smtp_messages_total", "Mensajes aceptados por el relay (DATA completado).")
+)
+
+// smtpTimeout limita la duración de cada sesión SMTP.
+const smtpTimeout = 30 * time.Second
+
+// cmd envía un comando SMTP con parámetros que smtp.Client no expone
+// (extensiones como DSN) y espera el código de respuesta indicado.
+func cmd(c *smtp.Client, code int, format string, args ...any) error {
+	id, err := c.Text.Cmd(format, args...)
+	if err != nil {
+		return err
+	}
+	c.Text.StartResponse(id)
+	defer c.Text.EndResponse(id)
+	_, _, err = c.Text.ReadResponse(code)
+	return err
+}
+
+// envelopeFrom devuelve la dirección para MAIL FROM: ReturnPath si está
+// definido o la dirección de From sin el nombre visible.
+func envelopeFrom(m Message) string {
+	from := m.ReturnPath
+	if from == "" {
+		from = m.From
+	}
+	if a, err := mail.ParseAddress(from); err == nil {
+		return a.Address
+	}
+	return from
+}
+
+// GlobalBcc devuelve GLOBAL_BCC, la dirección que recibe una copia de
+// auditoría de cada correo salvo que el envío la omita.
+func GlobalBcc() string {
+	return getEnv("GLOBAL_BCC", "")
+}
+
+// DefaultFrom devuelve el remitente configurado (FROM_EMAIL o SMTP_USERNAME).
+func DefaultFrom() string {
+	return getEnv("FROM_EMAIL", getEnv("SMTP_USERNAME", ""))
+}
+
+// DefaultReplyTo devuelve DEFAULT_REPLY_TO, el Reply-To de los mensajes que
+// no indican uno. Un valor inválido se ignora (ver CheckDefaultReplyTo).
+func DefaultReplyTo() string {
+	v := getEnv("DEFAULT_REPLY_TO", "")
+	if v == "" || CheckDefaultReplyTo() != nil {
+		return ""
+	}
+	return v
+}
+
+// CheckDefaultReplyTo valida DEFAULT_REPLY_TO al arrancar.
+func CheckDefaultReplyTo() error {
+	v := getEnv("DEFAULT_REPLY_TO", "")
+	if v == "" {
+		return nil
+	}
+	if _, err := mail.ParseAddressList(v); err != nil {
+		return fmt.Errorf("DEFAULT_REPLY_TO inválido: %w", err)
+	}
+	return nil
+}
+
+// BodyParts devuelve el cuerpo tal como lo escribe Build: el HTML (vacío en
+// los correos de texto plano), ya minificado si MINIFY_HTML=true, y la
+// alternativa en texto plano.
+func BodyParts(m Message) (htmlBody, text string) {
+	if m.ContentType == ContentTypePlain {
+		return "", m.Body
+	}
+	text = m.TextBody
+	if text == "" {
+		text = HTMLToText(m.Body)
+	}
+	return minifyHTML(m.Body), text
+}
+
+// Build compone el mensaje MIME completo (cabeceras y cuerpo) tal como se
+// entrega al servidor SMTP. Es WriteMessage sobre un buffer; un adjunto con
+// Open que no puede leerse queda truncado, ya que el error solo lo devuelve
+// WriteMessage.
+func Build(m Message) []byte {
+	var buf bytes.Buffer
+	WriteMessage(&buf, m)
+	return buf.Bytes()
+}
+
+// WriteMessage escribe en w el mensaje MIME completo. Los adjuntos con Open
+// se codifican en base64 a medida que se leen.
+func WriteMessage(w io.Writer, m Message) error {
+	if m.From == "" {
+		m.From = DefaultFrom()
+	}
+	if m.ReplyTo == "" {
+		m.ReplyTo = DefaultReplyTo()
+	}
+
+	date := m.Date
+	if date.IsZero() {
+		date = time.Now()
+	}
+
+	msg := &errWriter{w: w}
+	msg.WriteString(fmt.Sprintf("Date: %s\r\n", date.In(location()).Format(time.RFC1123Z)))
+	msg.WriteString(fmt.Sprintf("From: %s\r\nTo: %s\r\n", m.From, m.To))
+	if m.ReplyTo != "" {
+		msg.WriteString(fmt.Sprintf("Reply-To: %s\r\n", m.ReplyTo))
+	}
+	if len(m.Cc) > 0 {
+		msg.WriteString(fmt.Sprintf("Cc: %s\r\n", strings.Join(m.Cc, ", ")))
+	}
+	msg.WriteString(fmt.Sprintf("Subject: %s\r\n", mime.QEncoding.Encode("UTF-8", m.Subject)))
+	if m.MessageID != "" {
+		msg.WriteString(fmt.Sprintf("Message-ID: %s\r\n", m.MessageID))
+	}
+	if m.InReplyTo != "" {
+		msg.WriteString(fmt.Sprintf("In-Reply-To: %s\r\n", m.InReplyTo))
+	}
+	if len(m.References) > 0 {
+		msg.WriteString(fmt.Sprintf("References: %s\r\n", strings.Join(m.References, "\r\n ")))
+	}
+	if m.Bulk {
+		msg.WriteString("Precedence: bulk\r\nAuto-Submitted: auto-generated\r\n")
+		if m.ListID != "" {
+			msg.WriteString(fmt.Sprintf("List-Id: <%s>\r\n", m.ListID))
+		}
+	}
+	keys := make([]string, 0, len(m.Headers))
+	for k := range m.Headers {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		msg.WriteString(fmt.Sprintf("%s: %s\r\n", textproto.CanonicalMIMEHeaderKey(k), mime.QEncoding.Encode("UTF-8", m.Headers[k])))
+	}
+
+	plain := m.ContentType == ContentTypePlain
+	body, text := BodyParts(m)
+
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	if len(m.Attachments) == 0 {
+		if plain {
+			writeSingle(msg, "text/plain; charset=UTF-8", text)
+		} else {
+			writeAlternative(msg, text, body)
+		}
+		return msg.err
+	}
+
+	// Con adjuntos: multipart/mixed con el cuerpo (alternativo o texto
+	// plano) como primera parte y un adjunto por parte.
+	mixed := multipart.NewWriter(msg)
+	msg.WriteString(fmt.Sprintf("Content-Type: multipart/mixed; boundary=%q\r\n\r\n", mixed.Boundary()))
+
+	if plain {
+		writePart(mixed, "text/plain; charset=UTF-8", text)
+	} else {
+		alt := multipart.NewWriter(io.Discard)
+		h := textproto.MIMEHeader{}
+		h.Set("Content-Type", fmt.Sprintf("multipart/alternative; boundary=%q", alt.Boundary()))
+		pw, _ := mixed.CreatePart(h)
+		writeAlternativeBody(pw, alt.Boundary(), text, body)
+	}
+
+	for _, a := range m.Attachments {
+		ct := a.ContentType
+		if ct == "" {
+			ct = "application/octet-stream"
+		}
+		h := textproto.MIMEHeader{}
+		h.Set("Content-Type", mime.FormatMediaType(ct, map[string]string{"name": a.Filename}))
+		h.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": a.Filename}))
+		h.Set("Content-Transfer-Encoding", "base64")
+		pw, _ := mixed.CreatePart(h)
+		if a.Open == nil {
+			writeBase64(pw, a.Content)
+			continue
+		}
+		if err := streamBase64(pw, a.Open); err != nil {
+			return fmt.Errorf("adjunto %q: %w", a.Filename, err)
+		}
+	}
+	mixed.Close()
+	return msg.err
+}
+
+// errWriter guarda el primer error de escritura y descarta lo que se
+// escriba después.
+type errWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (e *errWriter) Write(p []byte) (int, error) {
+	if e.err != nil {
+		return 0, e.err
+	}
+	n, err := e.w.Write(p)
+	e.err = err
+	return n, err
+}
+
+func (e *errWriter) WriteString(s string) (int, error) {
+	return e.Write([]byte(s))
+}
+
+// countWriter cuenta los bytes escritos en w.
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// writeAlternative escribe la cabecera Content-Type y el cuerpo
+// multipart/alternative con las versiones de texto y HTML.
+func writeAlternative(w io.Writer, text, html string) {
+	mw := multipart.NewWriter(io.Discard)
+	fmt.Fprintf(w, "Content-Type: multipart/alternative; boundary=%q\r\n\r\n", mw.Boundary())
+	writeAlternativeBody(w, mw.Boundary(), text, html)
+}
+
+// writeAlternativeBody escribe las partes del multipart/alternative usando
+// el boundary dado.
+func writeAlternativeBody(w io.Writer, boundary, text, html string) {
+	mw := multipart.NewWriter(w)
+	mw.SetBoundary(boundary)
+
+	// El orden importa: los clientes muestran la última alternativa que soportan.
+	writePart(mw, "text/plain; charset=UTF-8", text)
+	writePart(mw, "text/html; charset=UTF-8", html)
+	mw.Close()
+}
+
+// writeSingle escribe las cabeceras y el cuerpo de un mensaje de una sola
+// parte, con la misma codificación que writePart.
+func writeSingle(w io.Writer, contentType, content string) {
+	if isText(content) {
+		fmt.Fprintf(w, "Content-Type: %s\r\nContent-Transfer-Encoding: quoted-printable\r\n\r\n", contentType)
+		qw := quotedprintable.NewWriter(w)
+		qw.Write([]byte(content))
+		qw.Close()
+		return
+	}
+	fmt.Fprintf(w, "Content-Type: %s\r\nContent-Transfer-Encoding: base64\r\n\r\n", contentType)
+	writeBase64(w, []byte(content))
+}
+
+// writePart escribe una parte de texto codificada en quoted-printable, que
+// limita las líneas a 76 caracteres y protege el contenido de 8 bits. Si el
+// contenido no es texto UTF-8 válido se usa base64.
+func writePart(mw *multipart.Writer, contentType, content string) {
+	h := textproto.MIMEHeader{}
+	h.Set("Content-Type", contentType)
+
+	if isText(content) {
+		h.Set("Content-Transfer-Encoding", "quoted-printable")
+		pw, _ := mw.CreatePart(h)
+		qw := quotedprintable.NewWriter(pw)
+		qw.Write([]byte(content))
+		qw.Close()
+		return
+	}
+
+	h.Set("Content-Transfer-Encoding", "base64")
+	pw, _ := mw.CreatePart(h)
+	writeBase64(pw, []byte(content))
+}
+
+// isText indica si el contenido es UTF-8 válido sin caracteres de control
+// binarios (se permiten tabuladores y saltos de línea).
+func isText(s string) bool {
+	if !utf8.ValidString(s) {
+		return false
+	}
+	for _, r := range s {
+		if r < 0x20 && r != '\t' && r != '\n' && r != '\r' {
+			return false
+		}
+	}
+	return true
+}
+
+// writeBase64 codifica en base64 con líneas de 76 caracteres (RFC 2045).
+func writeBase64(w io.Writer, data []byte) {
+	enc := base64.StdEncoding.EncodeToString(data)
+	for len(enc) > 76 {
+		io.WriteString(w, enc[:76]+"\r\n")
+		enc = enc[76:]
+	}
+	io.WriteString(w, enc+"\r\n")
+}
+
+// streamBase64 escribe en w, en base64 con líneas de 76 caracteres, el
+// contenido que devuelve open, leyéndolo por bloques.
+func streamBase64(w io.Writer, open func() (io.ReadCloser, error)) error {
+	r, err := open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	// 57 bytes de entrada son exactamente una línea de 76 caracteres.
+	buf := make([]byte, 57*1024)
+	line := make([]byte, 76, 78)
+	for {
+		n, err := io.ReadFull(r, buf)
+		for chunk := buf[:n]; len(chunk) > 0; {
+			k := min(len(chunk), 57)
+			base64.StdEncoding.Encode(line, chunk[:k])
+			if _, err := w.Write(append(line[:base64.StdEncoding.EncodedLen(k)], '\r', '\n')); err != nil {
+				return err
+			}
+			chunk = chunk[k:]
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// location devuelve la zona horaria de MAIL_TIMEZONE (nombre IANA) para la
+// cabecera Date, o la zona local del servidor si no está configurada o es inválida.
+func location() *time.Location {
+	if tz := getEnv("MAIL_TIMEZONE", ""); tz != "" {
+		if loc, err := time.LoadLocation(tz); err == nil {
+			return loc
+		}
+	}
+	return time.Local
+}
+
+// NormalizeSubject reemplaza caracteres de control por espacios y colapsa
+// los espacios consecutivos, evitando además la inyección de cabeceras.
+func NormalizeSubject(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return ' '
+		}
+		return r
+	}, s)
+	return strings.Join(strings.Fields(s), " ")
+}
+
+func getEnv(k, d string) string {
+	if v := os.Getenv(k); v != "" {
+		return v
+	}
+	return d
+}
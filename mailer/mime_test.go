@@ -0,0 +1,155 @@
+package mailer
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rawPart escribe content con writePart y devuelve la parte tal como va en
+// el mensaje, sin decodificar.
+func rawPart(t *testing.T, content string) (*multipart.Part, []byte) {
+	t.Helper()
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	writePart(mw, "text/plain; charset=UTF-8", content)
+	mw.Close()
+
+	p, err := multipart.NewReader(&buf, mw.Boundary()).NextRawPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := io.ReadAll(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p, raw
+}
+
+func checkLineLength(t *testing.T, raw []byte) {
+	t.Helper()
+	for _, l := range strings.Split(strings.ReplaceAll(string(raw), "\r\n", "\n"), "\n") {
+		if len(l) > 76 {
+			t.Errorf("línea de %d caracteres (máximo 76): %q", len(l), l)
+		}
+	}
+}
+
+func TestWritePartQuotedPrintable(t *testing.T) {
+	tests := map[string]string{
+		"línea larga sin espacios": strings.Repeat("abcdefghij", 30),
+		"línea larga con espacios": strings.Repeat("palabra ", 40),
+		"no ASCII":                 "Señor Muñoz: su pedido de 20 € está en camino. ¡Gracias! 日本語 " + strings.Repeat("ñ", 100),
+		"signo igual y punto":      "a=b\r\n.\r\nfin con espacio final ",
+	}
+	for name, content := range tests {
+		t.Run(name, func(t *testing.T) {
+			p, raw := rawPart(t, content)
+			if cte := p.Header.Get("Content-Transfer-Encoding"); cte != "quoted-printable" {
+				t.Fatalf("Content-Transfer-Encoding %q, se esperaba quoted-printable", cte)
+			}
+			for _, b := range raw {
+				if b >= 0x80 {
+					t.Fatalf("byte de 8 bits %#x en la salida quoted-printable", b)
+				}
+			}
+			checkLineLength(t, raw)
+			got, err := io.ReadAll(quotedprintable.NewReader(bytes.NewReader(raw)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != content {
+				t.Errorf("decodificado %q, se esperaba %q", got, content)
+			}
+		})
+	}
+}
+
+func TestWritePartBinaryUsesBase64(t *testing.T) {
+	content := string([]byte{0xff, 0xfe, 0x00, 'a'}) + strings.Repeat("x", 200)
+	p, raw := rawPart(t, content)
+	if cte := p.Header.Get("Content-Transfer-Encoding"); cte != "base64" {
+		t.Fatalf("Content-Transfer-Encoding %q, se esperaba base64", cte)
+	}
+	checkLineLength(t, raw)
+	got, err := base64.StdEncoding.DecodeString(strings.ReplaceAll(string(raw), "\r\n", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != content {
+		t.Errorf("decodificado %q, se esperaba %q", got, content)
+	}
+}
+
+// Un adjunto con Open se codifica igual que con Content.
+func TestAttachmentOpenMatchesContent(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789abcdef\x00\xff"), 10000)
+	m := Message{From: "a@example.com", To: "b@example.com", Subject: "s", Body: "b", MessageID: "<x@example.com>"}
+	m.Date = time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	inMemory, streamed := m, m
+	inMemory.Attachments = []Attachment{{Filename: "a.bin", Content: content}}
+	streamed.Attachments = []Attachment{{Filename: "a.bin", Open: func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(content)), nil
+	}}}
+
+	// Las fronteras multipart son aleatorias: se comparan los adjuntos decodificados.
+	for name, msg := range map[string]Message{"Content": inMemory, "Open": streamed} {
+		var buf bytes.Buffer
+		if err := WriteMessage(&buf, msg); err != nil {
+			t.Fatal(err)
+		}
+		got := attachmentOf(t, buf.Bytes())
+		if !bytes.Equal(got, content) {
+			t.Errorf("%s: adjunto de %d bytes, se esperaban %d", name, len(got), len(content))
+		}
+	}
+
+	var buf bytes.Buffer
+	failing := m
+	failing.Attachments = []Attachment{{Filename: "a.bin", Open: func() (io.ReadCloser, error) {
+		return nil, errors.New("archivo borrado")
+	}}}
+	if err := WriteMessage(&buf, failing); err == nil {
+		t.Error("WriteMessage no devolvió el error de Open")
+	}
+}
+
+// attachmentOf devuelve el contenido del primer adjunto de un mensaje
+// multipart/mixed, comprobando el largo de las líneas base64.
+func attachmentOf(t *testing.T, raw []byte) []byte {
+	t.Helper()
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mr := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		p, err := mr.NextRawPart()
+		if err != nil {
+			t.Fatalf("sin adjunto: %v", err)
+		}
+		if p.FileName() == "" {
+			continue
+		}
+		enc, _ := io.ReadAll(p)
+		checkLineLength(t, enc)
+		content, err := base64.StdEncoding.DecodeString(strings.ReplaceAll(string(enc), "\r\n", ""))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return content
+	}
+}
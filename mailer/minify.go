@@ -0,0 +1,37 @@
+package mailer
+
+import (
+	"log"
+	"strings"
+
+	"github.com/tdewolff/minify/v2"
+	"github.com/tdewolff/minify/v2/html"
+)
+
+// htmlMinifier quita comentarios y colapsa espacios, pero conserva todo lo
+// que algunos clientes de correo necesitan: etiquetas de documento y de
+// cierre, comillas, atributos por defecto y los comentarios condicionales
+// de Outlook (<!--[if mso]>). El contenido de <pre> no se toca.
+var htmlMinifier = &html.Minifier{
+	KeepSpecialComments: true,
+	KeepDefaultAttrVals: true,
+	KeepDocumentTags:    true,
+	KeepEndTags:         true,
+	KeepQuotes:          true,
+}
+
+// minifyHTML reduce el cuerpo HTML antes de enviarlo si MINIFY_HTML=true.
+// Si el minificador falla se envía el cuerpo original.
+func minifyHTML(body string) string {
+	if getEnv("MINIFY_HTML", "false") != "true" || body == "" {
+		return body
+	}
+	m := minify.New()
+	m.Add("text/html", htmlMinifier)
+	out, err := m.String("text/html", body)
+	if err != nil {
+		log.Printf("Error minificando HTML, se envía sin minificar: %v", err)
+		return body
+	}
+	return strings.TrimSpace(out)
+}
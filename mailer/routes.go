@@ -0,0 +1,97 @@
+package mailer
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/mail"
+	"strings"
+)
+
+// Relay es un perfil de servidor SMTP. Auth admite "plain" (por defecto),
+// "cram-md5" o "none".
+type Relay struct {
+	Host     string `json:"host"`
+	Port     string `json:"port,omitempty"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	Auth     string `json:"auth,omitempty"`
+}
+
+// DefaultRelay es la ruta por defecto, configurada con SMTP_HOST, SMTP_PORT,
+// SMTP_USERNAME, SMTP_PASSWORD y SMTP_AUTH.
+func DefaultRelay() Relay {
+	return Relay{
+		Host:     getEnv("SMTP_HOST", "smtp.gmail.com"),
+		Port:     getEnv("SMTP_PORT", "587"),
+		Username: getEnv("SMTP_USERNAME", ""),
+		Password: getEnv("SMTP_PASSWORD", ""),
+		Auth:     getEnv("SMTP_AUTH", "plain"),
+	}
+}
+
+// Routes lee SMTP_ROUTES, un objeto JSON de dominio de destino a perfil SMTP,
+// p. ej. {"corp.example.com": {"host": "mx.interno", "port": "25", "auth": "none"}}.
+// Un dominio también cubre sus subdominios.
+func Routes() (map[string]Relay, error) {
+	raw := getEnv("SMTP_ROUTES", "")
+	if raw == "" {
+		return map[string]Relay{}, nil
+	}
+
+	var in map[string]Relay
+	if err := json.Unmarshal([]byte(raw), &in); err != nil {
+		return nil, fmt.Errorf("SMTP_ROUTES inválido: %w", err)
+	}
+	out := make(map[string]Relay, len(in))
+	for domain, r := range in {
+		if r.Host == "" {
+			return nil, fmt.Errorf("SMTP_ROUTES: falta host para %q", domain)
+		}
+		if r.Port == "" {
+			r.Port = "25"
+		}
+		if r.Auth == "" {
+			r.Auth = "plain"
+		}
+		out[strings.ToLower(strings.TrimSpace(domain))] = r
+	}
+	return out, nil
+}
+
+// route devuelve el perfil para la dirección dada, buscando el dominio y
+// luego sus dominios padre; si ninguno coincide se usa def.
+func route(routes map[string]Relay, def Relay, addr string) Relay {
+	if a, err := mail.ParseAddress(addr); err == nil {
+		addr = a.Address
+	}
+	at := strings.LastIndex(addr, "@")
+	if at < 0 {
+		return def
+	}
+	domain := strings.ToLower(addr[at+1:])
+	for {
+		if r, ok := routes[domain]; ok {
+			return r
+		}
+		dot := strings.Index(domain, ".")
+		if dot < 0 {
+			return def
+		}
+		domain = domain[dot+1:]
+	}
+}
+
+// splitByRoute agrupa los destinatarios por perfil SMTP, conservando el orden
+// de primera aparición.
+func splitByRoute(routes map[string]Relay, def Relay, rcpts []string) ([]Relay, map[Relay][]string) {
+	var order []Relay
+	groups := map[Relay][]string{}
+	for _, rc := range rcpts {
+		r := route(routes, def, rc)
+		if _, ok := groups[r]; !ok {
+			order = append(order, r)
+		}
+		groups[r] = append(groups[r], rc)
+	}
+	return order, groups
+}
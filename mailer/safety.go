@@ -0,0 +1,84 @@
+package mailer
+
+import (
+	"fmt"
+	"net/mail"
+	"strings"
+)
+
+// Salvaguardas para entornos que no son producción:
+//
+//   - ALLOWED_RECIPIENT_DOMAINS (lista separada por comas) rechaza cualquier
+//     destinatario fuera de esos dominios o sus subdominios.
+//   - REDIRECT_ALL_TO reescribe todos los destinatarios a un único buzón de
+//     pruebas; los originales se conservan en X-Original-To y X-Original-Cc.
+
+// CheckRecipients devuelve un error si alguna dirección no está permitida
+// por ALLOWED_RECIPIENT_DOMAINS. Con REDIRECT_ALL_TO activo no se rechaza
+// nada, ya que ningún destinatario real recibirá el correo.
+func CheckRecipients(addrs []string) error {
+	if getEnv("REDIRECT_ALL_TO", "") != "" {
+		return nil
+	}
+	allowed := allowedDomains()
+	if len(allowed) == 0 {
+		return nil
+	}
+	for _, a := range addrs {
+		if !domainAllowed(allowed, a) {
+			return fmt.Errorf("destinatario no permitido en este entorno: %s", a)
+		}
+	}
+	return nil
+}
+
+// ApplyRecipientPolicy aplica REDIRECT_ALL_TO y ALLOWED_RECIPIENT_DOMAINS
+// justo antes del envío, como última barrera para cualquier origen
+// (envío síncrono, worker o recurrentes). /send/preview-recipients la usa
+// para mostrar los destinatarios reales.
+func ApplyRecipientPolicy(m Message) (Message, error) {
+	if to := getEnv("REDIRECT_ALL_TO", ""); to != "" {
+		h := make(map[string]string, len(m.Headers)+2)
+		for k, v := range m.Headers {
+			h[k] = v
+		}
+		h["X-Original-To"] = m.To
+		if len(m.Cc) > 0 {
+			h["X-Original-Cc"] = strings.Join(m.Cc, ", ")
+		}
+		m.Headers = h
+		m.To, m.Cc, m.Bcc = to, nil, nil
+		return m, nil
+	}
+	if err := CheckRecipients(append(append([]string{m.To}, m.Cc...), m.Bcc...)); err != nil {
+		return m, err
+	}
+	return m, nil
+}
+
+func allowedDomains() []string {
+	var out []string
+	for _, d := range strings.Split(getEnv("ALLOWED_RECIPIENT_DOMAINS", ""), ",") {
+		if d = strings.ToLower(strings.TrimSpace(d)); d != "" {
+			out = append(out, d)
+		}
+	}
+	return out
+}
+
+func domainAllowed(allowed []string, addr string) bool {
+	if a, err := mail.ParseAddress(addr); err == nil {
+		addr = a.Address
+	}
+	at := strings.LastIndex(addr, "@")
+	if at < 0 {
+		return false
+	}
+	domain := strings.ToLower(addr[at+1:])
+	for _, d := range allowed {
+		if domain == d || strings.HasSuffix(domain, "."+d) {
+			return true
+		}
+	}
+	return false
+}
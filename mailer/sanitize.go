@@ -0,0 +1,130 @@
+package mailer
+
+import (
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// sanitizeTags son las etiquetas que SanitizeHTML conserva (con los
+// atributos de sanitizeAttrs); del resto se quita la etiqueta y se deja su
+// contenido, salvo las de sanitizeDrop.
+var sanitizeTags = map[string]bool{
+	"a": true, "abbr": true, "b": true, "blockquote": true, "body": true, "br": true, "caption": true,
+	"center": true, "code": true, "col": true, "colgroup": true, "dd": true, "del": true, "div": true,
+	"dl": true, "dt": true, "em": true, "font": true, "h1": true, "h2": true, "h3": true, "h4": true,
+	"h5": true, "h6": true, "hr": true, "html": true, "i": true, "img": true, "ins": true, "li": true,
+	"ol": true, "p": true, "pre": true, "s": true, "small": true, "span": true, "strike": true,
+	"strong": true, "sub": true, "sup": true, "table": true, "tbody": true, "td": true,
+	"tfoot": true, "th": true, "thead": true, "tr": true, "u": true, "ul": true,
+}
+
+// sanitizeDrop son las etiquetas que se quitan junto con su contenido.
+// <style> está aquí porque el CSS no se filtra: cualquier lista de patrones
+// se esquiva con escapes (u\72l(, exp\ression(...).
+var sanitizeDrop = map[string]bool{
+	"script": true, "noscript": true, "iframe": true, "frame": true, "frameset": true, "object": true,
+	"embed": true, "applet": true, "template": true, "svg": true, "math": true, "title": true,
+	"textarea": true, "select": true, "style": true,
+}
+
+// sanitizeVoid son las etiquetas de sanitizeDrop que no tienen contenido ni
+// etiqueta de cierre: se quitan sin esperar un cierre que nunca llega.
+var sanitizeVoid = map[string]bool{"embed": true, "frame": true}
+
+// sanitizeAttrs son los atributos que se conservan en cualquier etiqueta
+// permitida. Los eventos (on*) y style nunca lo están.
+var sanitizeAttrs = map[string]bool{
+	"align": true, "alt": true, "bgcolor": true, "border": true, "cellpadding": true, "cellspacing": true,
+	"class": true, "color": true, "colspan": true, "dir": true, "face": true, "height": true, "href": true,
+	"lang": true, "rowspan": true, "size": true, "src": true, "title": true, "valign": true,
+	"width": true,
+}
+
+// SanitizeHTML devuelve una versión de src segura para mostrarse en el
+// navegador (p. ej. en un iframe del panel): sin scripts, marcos,
+// formularios, eventos, enlaces javascript: ni CSS (se quitan <style> y los
+// atributos style, así que el diseño puede verse distinto). Las imágenes
+// solo se conservan si son https, cid: o data:image/. Es para previsualizar:
+// el correo se envía siempre con el HTML original.
+func SanitizeHTML(src string) string {
+	var b strings.Builder
+	z := html.NewTokenizer(strings.NewReader(src))
+	drop := 0 // profundidad dentro de una etiqueta de sanitizeDrop
+
+	for {
+		tt := z.Next()
+		switch tt {
+		case html.ErrorToken:
+			return b.String()
+		case html.TextToken:
+			if drop > 0 {
+				continue
+			}
+			b.WriteString(html.EscapeString(string(z.Text())))
+		case html.StartTagToken, html.SelfClosingTagToken:
+			tok := z.Token()
+			if sanitizeDrop[tok.Data] {
+				if tt == html.StartTagToken && !sanitizeVoid[tok.Data] {
+					drop++
+				}
+				continue
+			}
+			if drop > 0 || !sanitizeTags[tok.Data] {
+				continue
+			}
+			tok.Attr = sanitizeAttrList(tok)
+			b.WriteString(tok.String())
+		case html.EndTagToken:
+			tok := z.Token()
+			if sanitizeDrop[tok.Data] {
+				if !sanitizeVoid[tok.Data] {
+					drop = max(drop-1, 0)
+				}
+				continue
+			}
+			if drop > 0 || !sanitizeTags[tok.Data] {
+				continue
+			}
+			b.WriteString(tok.String())
+		}
+	}
+}
+
+// sanitizeAttrList filtra los atributos de la etiqueta t.
+func sanitizeAttrList(t html.Token) []html.Attribute {
+	var out []html.Attribute
+	for _, a := range t.Attr {
+		key := strings.ToLower(a.Key)
+		if a.Namespace != "" || !sanitizeAttrs[key] {
+			continue
+		}
+		switch key {
+		case "href":
+			if !safeURL(a.Val, "http:", "https:", "mailto:", "tel:") && !strings.HasPrefix(a.Val, "#") {
+				continue
+			}
+		case "src":
+			if t.Data != "img" || !safeURL(a.Val, "https:", "cid:", "data:image/") {
+				continue
+			}
+		}
+		out = append(out, html.Attribute{Key: key, Val: a.Val})
+	}
+	if t.Data == "a" {
+		out = append(out, html.Attribute{Key: "rel", Val: "noopener noreferrer"}, html.Attribute{Key: "target", Val: "_blank"})
+	}
+	return out
+}
+
+// safeURL indica si u empieza por alguno de los esquemas dados, sin
+// distinguir mayúsculas ni contar los espacios iniciales.
+func safeURL(u string, schemes ...string) bool {
+	u = strings.ToLower(strings.TrimSpace(u))
+	for _, s := range schemes {
+		if strings.HasPrefix(u, s) {
+			return true
+		}
+	}
+	return false
+}
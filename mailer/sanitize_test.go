@@ -0,0 +1,101 @@
+package mailer
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSanitizeHTML(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{
+			name: "formato conservado",
+			in:   `<p align="center">Hola <b>Ana</b><br/></p>`,
+			want: `<p align="center">Hola <b>Ana</b><br/></p>`,
+		},
+		{
+			name: "script con su contenido",
+			in:   `<p>a</p><script>alert(1)</script><p>b</p>`,
+			want: `<p>a</p><p>b</p>`,
+		},
+		{
+			name: "eventos",
+			in:   `<img src="https://example.com/a.png" onerror="alert(1)" alt="x">`,
+			want: `<img src="https://example.com/a.png" alt="x">`,
+		},
+		{
+			name: "enlace javascript",
+			in:   `<a href=" JaVaScRiPt:alert(1)">x</a>`,
+			want: `<a rel="noopener noreferrer" target="_blank">x</a>`,
+		},
+		{
+			name: "embed sin cierre",
+			in:   `<p>a</p><embed src=x><p>keep me</p>`,
+			want: `<p>a</p><p>keep me</p>`,
+		},
+		{
+			name: "frame sin cierre y cierre suelto",
+			in:   `<frame src=x><p>a</p></embed><script>x</script><p>b</p>`,
+			want: `<p>a</p><p>b</p>`,
+		},
+		{
+			name: "bloque style con su contenido",
+			in:   `<style>p{color:red}</style><p>x</p>`,
+			want: `<p>x</p>`,
+		},
+		{
+			name: "atributo style",
+			in:   `<p style="color:red">x</p>`,
+			want: `<p>x</p>`,
+		},
+		{
+			name: "etiqueta desconocida conserva el texto",
+			in:   `<form action="https://evil.example"><p>x</p><input name="y"></form>`,
+			want: `<p>x</p>`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := SanitizeHTML(tt.in); got != tt.want {
+				t.Errorf("SanitizeHTML(%q)\n got  %q\n want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+// Ninguna variante de XSS o de CSS que cargue recursos sobrevive, tampoco
+// las que esquivan los filtros por patrones con escapes CSS o comentarios.
+func TestSanitizeHTMLPayloads(t *testing.T) {
+	payloads := []string{
+		`<script>alert(1)</script>`,
+		`<SCRIPT SRC=https://evil.example/x.js></SCRIPT>`,
+		`<img src=x onerror=alert(1)>`,
+		`<svg onload=alert(1)><script>alert(1)</script></svg>`,
+		`<iframe src="javascript:alert(1)"></iframe>`,
+		`<a href="jav&#x09;ascript:alert(1)">x</a>`,
+		`<a href="data:text/html,<script>alert(1)</script>">x</a>`,
+		`<img src="javascript:alert(1)">`,
+		`<math><mtext><style><img src=x onerror=alert(1)></style></mtext></math>`,
+		`<p style="background:url(https://evil.example/t.png)">x</p>`,
+		`<p style="background:u\72l(https://evil.example/t.png)">x</p>`,
+		`<p style="width:exp\ression(alert(1))">x</p>`,
+		`<p style="width:expr/**/ession(alert(1))">x</p>`,
+		`<p style="behavior:url(x.htc)">x</p>`,
+		`<style>@import 'https://evil.example/x.css';</style>`,
+		`<style>@\69mport 'https://evil.example/x.css';</style>`,
+		`<style>p{background:u\rl(https://evil.example/t.png)}</style>`,
+		`<style></style><img src=x onerror=alert(1)></style>`,
+		`<div><style>*{x:expression(alert(1))}</style></div>`,
+	}
+	for _, p := range payloads {
+		got := strings.ToLower(SanitizeHTML(p))
+		for _, bad := range []string{"<script", "<style", "<iframe", "<svg", "style=", "onerror", "onload", "javascript:", "data:text", "evil.example", "expression", `\72`, "@import", "src=\"x\""} {
+			if strings.Contains(got, bad) {
+				t.Errorf("SanitizeHTML(%q) = %q contiene %q", p, got, bad)
+			}
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package mailer
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/mail"
+)
+
+// Sender es una identidad de remitente configurada bajo un alias.
+type Sender struct {
+	From       string `json:"from"`
+	ReplyTo    string `json:"reply_to,omitempty"`
+	ReturnPath string `json:"return_path,omitempty"`
+}
+
+// Senders lee las identidades de SENDERS, un objeto JSON de alias a
+// remitente, p. ej. {"billing": {"from": "Facturación <billing@example.com>"}}.
+func Senders() (map[string]Sender, error) {
+	raw := getEnv("SENDERS", "")
+	if raw == "" {
+		return map[string]Sender{}, nil
+	}
+
+	var out map[string]Sender
+	if err := json.Unmarshal([]byte(raw), &out); err != nil {
+		return nil, fmt.Errorf("SENDERS inválido: %w", err)
+	}
+	for alias, s := range out {
+		if _, err := mail.ParseAddress(s.From); err != nil {
+			return nil, fmt.Errorf("SENDERS: from inválido en %q", alias)
+		}
+		if s.ReplyTo != "" {
+			if _, err := mail.ParseAddressList(s.ReplyTo); err != nil {
+				return nil, fmt.Errorf("SENDERS: reply_to inválido en %q", alias)
+			}
+		}
+		if s.ReturnPath != "" {
+			if _, err := mail.ParseAddress(s.ReturnPath); err != nil {
+				return nil, fmt.Errorf("SENDERS: return_path inválido en %q", alias)
+			}
+		}
+	}
+	return out, nil
+}
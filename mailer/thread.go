@@ -0,0 +1,39 @@
+package mailer
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/mail"
+	"strings"
+)
+
+// maxReferences limita la cabecera References en hilos largos: se conservan
+// el primer mensaje y los más recientes, como recomienda RFC 5322.
+const maxReferences = 20
+
+// NewMessageID genera un Message-ID único ("<aleatorio@dominio>") con el
+// dominio del remitente from, o el de DefaultFrom si from está vacío.
+func NewMessageID(from string) string {
+	if from == "" {
+		from = DefaultFrom()
+	}
+	domain := "localhost"
+	if a, err := mail.ParseAddress(from); err == nil {
+		if i := strings.LastIndex(a.Address, "@"); i >= 0 && i < len(a.Address)-1 {
+			domain = a.Address[i+1:]
+		}
+	}
+	b := make([]byte, 16)
+	rand.Read(b)
+	return "<" + hex.EncodeToString(b) + "@" + domain + ">"
+}
+
+// ThreadReferences devuelve la cabecera References de una respuesta a un
+// mensaje con Message-ID parentID y References parentRefs.
+func ThreadReferences(parentRefs []string, parentID string) []string {
+	refs := append(append([]string(nil), parentRefs...), parentID)
+	if len(refs) > maxReferences {
+		refs = append(refs[:1], refs[len(refs)-maxReferences+1:]...)
+	}
+	return refs
+}
@@ -0,0 +1,153 @@
+package mailer
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/textproto"
+	"strings"
+)
+
+// TranscriptLine es una línea de la conversación SMTP con un relay. Dir es
+// "C" para lo que envía el cliente, "S" para las respuestas del servidor y
+// "*" para los sucesos locales (conexión, TLS, errores).
+type TranscriptLine struct {
+	Relay string `json:"relay"`
+	Dir   string `json:"dir"`
+	Line  string `json:"line"`
+}
+
+// Límites de la transcripción, para que un relay que responde sin fin no
+// llene el historial.
+const (
+	maxTranscriptLines = 200
+	maxTranscriptLine  = 512
+)
+
+// transcript registra la conversación SMTP de un envío. Las credenciales de
+// AUTH se sustituyen por "****" y el contenido de DATA no se guarda. Tras
+// STARTTLS la conexión va cifrada, así que recordConn deja de anotarla y
+// recordTLS retoma la transcripción por encima de TLS.
+type transcript struct {
+	relay string
+	lines []TranscriptLine
+
+	in, out  []byte
+	off      bool // la conexión va cifrada tras el 220 de STARTTLS
+	mute     bool // cuerpo del mensaje en DATA
+	auth     bool // dentro del intercambio de AUTH
+	startTLS bool // STARTTLS enviado, a la espera de la respuesta
+}
+
+func (t *transcript) add(dir, line string) {
+	if len(t.lines) >= maxTranscriptLines {
+		return
+	}
+	if len(line) > maxTranscriptLine {
+		line = line[:maxTranscriptLine] + "…"
+	}
+	t.lines = append(t.lines, TranscriptLine{Relay: t.relay, Dir: dir, Line: line})
+}
+
+func (t *transcript) event(format string, args ...any) {
+	t.add("*", fmt.Sprintf(format, args...))
+}
+
+// connect anota una conexión nueva y olvida el estado de la anterior.
+func (t *transcript) connect(addr string) {
+	t.in, t.out = nil, nil
+	t.off, t.mute, t.auth, t.startTLS = false, false, false, false
+	t.event("conectando a %s", addr)
+}
+
+func (t *transcript) read(p []byte)  { t.feed(&t.in, "S", p) }
+func (t *transcript) write(p []byte) { t.feed(&t.out, "C", p) }
+
+// feed parte en líneas lo leído o escrito y las anota.
+func (t *transcript) feed(buf *[]byte, dir string, p []byte) {
+	if dir == "C" && t.mute {
+		return
+	}
+	*buf = append(*buf, p...)
+	for {
+		i := bytes.IndexByte(*buf, '\n')
+		if i < 0 {
+			return
+		}
+		line := strings.TrimRight(string((*buf)[:i]), "\r")
+		*buf = (*buf)[i+1:]
+		t.line(dir, line)
+	}
+}
+
+func (t *transcript) line(dir, line string) {
+	switch {
+	case dir == "C" && t.auth:
+		// Respuesta a un reto 334 (CRAM-MD5).
+		line = "****"
+	case dir == "C" && len(line) > 5 && strings.EqualFold(line[:5], "AUTH "):
+		// Solo se conserva el mecanismo.
+		if f := strings.Fields(line); len(f) > 2 {
+			line = f[0] + " " + f[1] + " ****"
+		}
+		t.auth = true
+	case dir == "C" && strings.EqualFold(line, "STARTTLS"):
+		t.startTLS = true
+	case dir == "S" && t.auth:
+		t.auth = strings.HasPrefix(line, "334")
+	case dir == "S" && t.startTLS:
+		t.startTLS = false
+		t.off = strings.HasPrefix(line, "220")
+	}
+	t.add(dir, line)
+}
+
+// recordConn anota en t lo que pasa por la conexión hasta STARTTLS.
+type recordConn struct {
+	net.Conn
+	t *transcript
+}
+
+func (c recordConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if !c.t.off {
+		c.t.read(p[:n])
+	}
+	return n, err
+}
+
+func (c recordConn) Write(p []byte) (int, error) {
+	if !c.t.off {
+		c.t.write(p)
+	}
+	return c.Conn.Write(p)
+}
+
+// recordText envuelve la conexión textproto de un cliente SMTP, que tras
+// STARTTLS ya va sobre TLS, para seguir anotando la conversación en claro.
+type recordText struct {
+	*textproto.Conn
+	t *transcript
+}
+
+func (c recordText) Read(p []byte) (int, error) {
+	n, err := c.R.Read(p)
+	c.t.read(p[:n])
+	return n, err
+}
+
+func (c recordText) Write(p []byte) (int, error) {
+	c.t.write(p)
+	n, err := c.W.Write(p)
+	if err == nil {
+		err = c.W.Flush()
+	}
+	return n, err
+}
+
+// recordTLS retoma la transcripción después de STARTTLS.
+func (t *transcript) recordTLS(text *textproto.Conn, state tls.ConnectionState) *textproto.Conn {
+	t.event("TLS establecido (%s)", tls.VersionName(state.Version))
+	return textproto.NewConn(recordText{Conn: text, t: t})
+}
@@ -0,0 +1,79 @@
+package metrics
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// Registro mínimo de métricas expuesto en formato de texto de Prometheus.
+
+type metric struct {
+	name  string
+	help  string
+	kind  string // counter | gauge
+	value func() float64
+}
+
+var (
+	mu       sync.Mutex
+	registry = map[string]metric{}
+)
+
+// Counter es un contador monótono.
+type Counter struct{ v atomic.Int64 }
+
+func (c *Counter) Inc()         { c.v.Add(1) }
+func (c *Counter) Add(n int64)  { c.v.Add(n) }
+func (c *Counter) Value() int64 { return c.v.Load() }
+
+// Gauge es un valor que puede subir y bajar.
+type Gauge struct{ v atomic.Int64 }
+
+func (g *Gauge) Inc()         { g.v.Add(1) }
+func (g *Gauge) Dec()         { g.v.Add(-1) }
+func (g *Gauge) Set(n int64)  { g.v.Store(n) }
+func (g *Gauge) Value() int64 { return g.v.Load() }
+
+// NewCounter registra y devuelve un contador.
+func NewCounter(name, help string) *Counter {
+	c := &Counter{}
+	register(metric{name, help, "counter", func() float64 { return float64(c.Value()) }})
+	return c
+}
+
+// NewGauge registra y devuelve un gauge.
+func NewGauge(name, help string) *Gauge {
+	g := &Gauge{}
+	register(metric{name, help, "gauge", func() float64 { return float64(g.Value()) }})
+	return g
+}
+
+// NewGaugeFunc registra un gauge cuyo valor se calcula al exponerlo.
+func NewGaugeFunc(name, help string, f func() float64) {
+	register(metric{name, help, "gauge", f})
+}
+
+func register(m metric) {
+	mu.Lock()
+	defer mu.Unlock()
+	registry[m.name] = m
+}
+
+// Handler expone todas las métricas registradas en GET /metrics.
+func Handler(w http.ResponseWriter, r *http.Request) {
+	mu.Lock()
+	list := make([]metric, 0, len(registry))
+	for _, m := range registry {
+		list = append(list, m)
+	}
+	mu.Unlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].name < list[j].name })
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	for _, m := range list {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %g\n", m.name, m.help, m.name, m.kind, m.name, m.value())
+	}
+}
@@ -1,10 +1,84 @@
 package models
 
-// EmailRequest represents the JSON structure for sending emails
+import (
+	"time"
+
+	"mailer-service/storage"
+)
+
+// EmailRequest represents the JSON structure for sending emails.
+// When TextBody is empty a plain-text alternative is generated from Body.
 type EmailRequest struct {
-	To      string `json:"to"`
-	Subject string `json:"subject"`
-	Body    string `json:"body"`
+	To         string   `json:"to"`
+	Cc         []string `json:"cc,omitempty"`
+	Bcc        []string `json:"bcc,omitempty"`
+	Subject    string   `json:"subject"`
+	Body       string   `json:"body"`
+	TextBody   string   `json:"text_body,omitempty"`
+	TemplateID int64    `json:"template_id,omitempty"`
+	// TemplateName selects a template by name instead of by id. Locale
+	// picks the closest variant of that name (or of the template_id's
+	// name), falling back through LOCALE_FALLBACKS and DEFAULT_LOCALE.
+	TemplateName string `json:"template_name,omitempty"`
+	Locale       string `json:"locale,omitempty"`
+	// Variables are applied to the template's subject and body.
+	Variables map[string]any `json:"variables,omitempty"`
+	// CallbackURL receives a POST with the final status of the email.
+	CallbackURL string `json:"callback_url,omitempty"`
+	// Priority ranges from 0 (default) to 10; higher is dispatched first.
+	Priority int `json:"priority,omitempty"`
+	// SendAt schedules the email for a future time (RFC3339).
+	SendAt *time.Time `json:"send_at,omitempty"`
+	// SendAtLocal ("09:00") with Timezone (IANA name, e.g. "Europe/Madrid")
+	// schedules the email for the next time it is that time of day in the
+	// recipient's zone; the result is stored as SendAt.
+	SendAtLocal string `json:"send_at_local,omitempty"`
+	Timezone    string `json:"timezone,omitempty"`
+	// ExpiresAt drops the email (status "expired") if it has not been
+	// sent by then.
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	// Date overrides the Date header (RFC3339); intended for testing.
+	Date *time.Time `json:"date,omitempty"`
+	// FromAlias selects a sender identity configured in SENDERS.
+	FromAlias string `json:"from_alias,omitempty"`
+	// ReplyTo overrides the sender's Reply-To and DEFAULT_REPLY_TO.
+	ReplyTo string `json:"reply_to,omitempty"`
+	// RequestDSN asks the relay for delivery status notifications.
+	RequestDSN bool `json:"request_dsn,omitempty"`
+	// PDF renders an HTML snippet to PDF and attaches it (requires
+	// PDF_ATTACHMENTS=true).
+	PDF *PDFAttachment `json:"pdf,omitempty"`
+	// SkipAuditCopy omits the GLOBAL_BCC archival copy for this email.
+	SkipAuditCopy bool `json:"skip_audit_copy,omitempty"`
+	// SkipLayout sends the body as is, without the "__layout" template.
+	SkipLayout bool `json:"skip_layout,omitempty"`
+	// ConversationID threads the email after the previous one sent with the
+	// same id (In-Reply-To/References headers).
+	ConversationID string `json:"conversation_id,omitempty"`
+	// Bulk adds Precedence: bulk and Auto-Submitted: auto-generated, and
+	// ListID (e.g. "newsletter.example.com") a List-Id header. Either one
+	// also applies when set on the template.
+	Bulk   bool   `json:"bulk,omitempty"`
+	ListID string `json:"list_id,omitempty"`
+	// Headers are extra X-* headers passed through as is, e.g.
+	// {"X-Entity-Ref-ID": "order-123"}. Only allowlisted names are accepted
+	// (see CUSTOM_HEADERS_ALLOWED).
+	Headers map[string]string `json:"headers,omitempty"`
+	// TimeoutSeconds overrides the SMTP timeout for this send, up to
+	// MAX_SEND_TIMEOUT; 0 keeps the default.
+	TimeoutSeconds int `json:"timeout_seconds,omitempty"`
+	// Campaign tags the email so it can be filtered and paused along with
+	// the rest of its campaign (see /campaigns).
+	Campaign string `json:"campaign,omitempty"`
+	// ContentType is "text/html" (default) or "text/plain". Plain text is
+	// sent as a single text/plain part, without layout or HTML alternative.
+	ContentType string `json:"content_type,omitempty"`
+}
+
+// PDFAttachment is an HTML document attached to the email as a PDF.
+type PDFAttachment struct {
+	HTML     string `json:"html"`
+	Filename string `json:"filename,omitempty"`
 }
 
 // EmailResponse represents the server response
@@ -12,10 +86,137 @@ type EmailResponse struct {
 	Success bool   `json:"success"`
 	Message string `json:"message"`
 	Error   string `json:"error,omitempty"`
+	// Delivery reports what happened to a successful send: DeliverySent,
+	// DeliveryQueued or DeliveryDeferred.
+	Delivery string `json:"delivery,omitempty"`
+	// Disposable flags that a recipient uses a disposable email domain.
+	Disposable bool `json:"disposable,omitempty"`
+	// Role flags that a recipient is a role address such as postmaster@
+	// or noreply@ (see ROLE_ADDRESSES).
+	Role bool `json:"role_address,omitempty"`
+	// SubjectTruncated flags that the rendered subject was shortened.
+	SubjectTruncated bool `json:"subject_truncated,omitempty"`
+	// Warning reports a non-fatal problem, e.g. a PDF that could not be
+	// generated and was left out.
+	Warning string `json:"warning,omitempty"`
+	// CorrelationID is the request id stored with the email.
+	CorrelationID string `json:"correlation_id,omitempty"`
+	// ID, Status and MessageID identify the created email so callers can
+	// track it (e.g. GET /emails/{id}/position) without listing emails.
+	ID        int64  `json:"id,omitempty"`
+	Status    string `json:"status,omitempty"`
+	MessageID string `json:"message_id,omitempty"`
+	// Locale is the locale of the template variant actually used.
+	Locale string `json:"locale,omitempty"`
 }
 
+// Delivery values of EmailResponse.
+const (
+	// DeliverySent: delivered synchronously to the relay.
+	DeliverySent = "sent"
+	// DeliveryQueued: queued or scheduled for the worker without a send attempt.
+	DeliveryQueued = "queued"
+	// DeliveryDeferred: the hybrid-mode send attempt timed out or failed
+	// transiently and the email was left in the queue for a retry.
+	DeliveryDeferred = "deferred"
+)
+
+// TemplateRequest represents the JSON structure for creating/updating templates.
+// Cc and Bcc are always copied on sends that use the template.
+// Delims overrides the "{{ }}" action delimiters, e.g. "[[ ]]".
+// SubjectMaxLen truncates the rendered subject (0 falls back to SUBJECT_MAX_LEN).
+// VariablesSchema declares the variables the template accepts; sends with
+// missing required or mistyped variables are rejected.
+// Bulk and ListID mark every send of the template as bulk mail.
+// Locale (e.g. "es-MX") makes it one locale variant of Name.
+// MaxPerMinute caps the template's sends per minute (0 means no cap); the
+// worker defers the sends over the cap.
 type TemplateRequest struct {
-	Name    string `json:"name"`
-	Subject string `json:"subject"`
-	Body    string `json:"body"`
+	Name    string   `json:"name"`
+	Subject string   `json:"subject"`
+	Body    string   `json:"body"`
+	Cc      []string `json:"cc,omitempty"`
+	Bcc     []string `json:"bcc,omitempty"`
+	Delims  string   `json:"delims,omitempty"`
+	Locale  string   `json:"locale,omitempty"`
+
+	SubjectMaxLen   int                    `json:"subject_max_len,omitempty"`
+	VariablesSchema []storage.VariableSpec `json:"variables_schema,omitempty"`
+	Bulk            bool                   `json:"bulk,omitempty"`
+	ListID          string                 `json:"list_id,omitempty"`
+	MaxPerMinute    int                    `json:"max_per_minute,omitempty"`
+}
+
+// TemplateReplaceRequest is the body of POST /templates/bulk-replace.
+// Find is a literal string, or a regular expression when Regex is set (then
+// Replace may use $1...). DryRun reports the matches without writing.
+type TemplateReplaceRequest struct {
+	Find    string `json:"find"`
+	Replace string `json:"replace"`
+	Regex   bool   `json:"regex,omitempty"`
+	DryRun  bool   `json:"dry_run,omitempty"`
+}
+
+// TemplateTestRequest is the optional body of POST /templates/{id}/test.
+// To defaults to TEST_RECIPIENT; missing variables get placeholder values.
+type TemplateTestRequest struct {
+	To        string         `json:"to,omitempty"`
+	Variables map[string]any `json:"variables,omitempty"`
+}
+
+// TemplatePreviewRequest is the optional body of POST /templates/{id}/preview;
+// missing variables get placeholder values.
+type TemplatePreviewRequest struct {
+	Variables map[string]any `json:"variables,omitempty"`
+}
+
+// StatusQueryRequest is the body of POST /emails/status.
+type StatusQueryRequest struct {
+	IDs []int64 `json:"ids"`
+}
+
+// StatusRequest is the body of PATCH /emails/{id}/status. Reason is stored
+// in the audit log and, when moving to "failed", as the email's error.
+type StatusRequest struct {
+	Status string `json:"status"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// BulkDeleteRequest deletes emails either by id or by filter.
+// Filter-based deletion requires admin auth and Confirm=true.
+type BulkDeleteRequest struct {
+	IDs     []int64           `json:"ids,omitempty"`
+	Filter  *BulkDeleteFilter `json:"filter,omitempty"`
+	Confirm bool              `json:"confirm"`
+}
+
+type BulkDeleteFilter struct {
+	Status string `json:"status,omitempty"`
+	Before string `json:"before,omitempty"`
+}
+
+// RecipientPreviewRequest is the body of POST /send/preview-recipients.
+// TemplateID adds the template's fixed Cc/Bcc recipients.
+type RecipientPreviewRequest struct {
+	To         string   `json:"to"`
+	Cc         []string `json:"cc,omitempty"`
+	Bcc        []string `json:"bcc,omitempty"`
+	TemplateID int64    `json:"template_id,omitempty"`
+}
+
+// ValidateRequest is the body of POST /validate.
+type ValidateRequest struct {
+	Email string `json:"email"`
+}
+
+// RecurringRequest creates or replaces a recurring send: on every match of
+// Cron (standard five-field syntax) the template is rendered with Variables
+// and one email is queued per recipient. Active defaults to true.
+type RecurringRequest struct {
+	Name       string         `json:"name,omitempty"`
+	Cron       string         `json:"cron"`
+	TemplateID int64          `json:"template_id"`
+	Recipients []string       `json:"recipients"`
+	Variables  map[string]any `json:"variables,omitempty"`
+	Active     *bool          `json:"active,omitempty"`
 }
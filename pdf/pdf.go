@@ -0,0 +1,83 @@
+package pdf
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"mailer-service/tlsconf"
+)
+
+// client se crea en el primer uso, cuando la configuración ya está cargada.
+var client = sync.OnceValue(func() *http.Client {
+	return &http.Client{Transport: tlsconf.Transport()}
+})
+
+// Políticas ante un fallo del renderizador (PDF_FAILURE_POLICY).
+const (
+	PolicySkip = "skip" // enviar sin el adjunto y registrar un aviso
+	PolicyFail = "fail" // rechazar el envío
+)
+
+// Enabled indica si la generación de PDF está activada (PDF_ATTACHMENTS=true
+// y PDF_RENDERER_URL configurado).
+func Enabled() bool {
+	return getEnv("PDF_ATTACHMENTS", "false") == "true" && getEnv("PDF_RENDERER_URL", "") != ""
+}
+
+// FailurePolicy devuelve PDF_FAILURE_POLICY (por defecto skip).
+func FailurePolicy() string {
+	if getEnv("PDF_FAILURE_POLICY", PolicySkip) == PolicyFail {
+		return PolicyFail
+	}
+	return PolicySkip
+}
+
+// Render convierte html en PDF con el renderizador externo de
+// PDF_RENDERER_URL: se le envía el HTML por POST y debe responder con el
+// PDF. PDF_RENDER_TIMEOUT limita la espera (por defecto 30s).
+func Render(ctx context.Context, html string) ([]byte, error) {
+	timeout, err := time.ParseDuration(getEnv("PDF_RENDER_TIMEOUT", "30s"))
+	if err != nil || timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, getEnv("PDF_RENDERER_URL", ""), bytes.NewBufferString(html))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "text/html; charset=utf-8")
+	req.Header.Set("Accept", "application/pdf")
+
+	resp, err := client().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("el renderizador respondió %d", resp.StatusCode)
+	}
+	out, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(out, []byte("%PDF-")) {
+		return nil, fmt.Errorf("el renderizador no devolvió un PDF")
+	}
+	return out, nil
+}
+
+func getEnv(k, d string) string {
+	if v := os.Getenv(k); v != "" {
+		return v
+	}
+	return d
+}
@@ -0,0 +1,79 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"mailer-service/storage"
+)
+
+// Queue es la cola de despacho del worker. El registro del correo siempre
+// vive en el Repository; la cola solo decide qué correos se reclaman.
+type Queue interface {
+	// Enqueue avisa de que el correo id podrá enviarse a partir de at.
+	Enqueue(ctx context.Context, id int64, at time.Time) error
+	// Claim reclama (marca como sending) hasta n correos listos.
+	Claim(ctx context.Context, n int) ([]storage.Email, error)
+	// Ack confirma que un correo reclamado ya se procesó.
+	Ack(ctx context.Context, id int64) error
+	// Nack devuelve a la cola correos reclamados que no se enviaron.
+	Nack(ctx context.Context, ids []int64) (int64, error)
+	// Init prepara la cola una vez que la base de datos está disponible,
+	// antes de que el worker empiece a reclamar.
+	Init(ctx context.Context) error
+}
+
+// New crea la cola indicada por QUEUE_BACKEND: "postgres" (por defecto),
+// que sondea la tabla emails, o "redis" (con REDIS_URL).
+func New(ctx context.Context, s storage.Repository) (Queue, error) {
+	switch b := getEnv("QUEUE_BACKEND", "postgres"); b {
+	case "postgres":
+		return NewDB(s), nil
+	case "redis":
+		return NewRedis(ctx, s, getEnv("REDIS_URL", "redis://localhost:6379/0"))
+	default:
+		return nil, fmt.Errorf("QUEUE_BACKEND no soportado: %s", b)
+	}
+}
+
+// DBQueue usa la propia tabla emails como cola: los correos pendientes ya
+// están en ella, así que Enqueue y Ack no hacen nada.
+type DBQueue struct {
+	Store storage.Repository
+}
+
+func NewDB(s storage.Repository) *DBQueue {
+	return &DBQueue{Store: s}
+}
+
+// Init no hace nada: la cola es la propia tabla.
+func (q *DBQueue) Init(ctx context.Context) error {
+	return nil
+}
+
+func (q *DBQueue) Enqueue(ctx context.Context, id int64, at time.Time) error {
+	return nil
+}
+
+// Claim aplica las reglas de ClaimDue: prioridad, momento de envío y un
+// solo correo en curso por destinatario.
+func (q *DBQueue) Claim(ctx context.Context, n int) ([]storage.Email, error) {
+	return q.Store.ClaimDue(ctx, n)
+}
+
+func (q *DBQueue) Ack(ctx context.Context, id int64) error {
+	return nil
+}
+
+func (q *DBQueue) Nack(ctx context.Context, ids []int64) (int64, error) {
+	return q.Store.RequeueClaimed(ctx, ids)
+}
+
+func getEnv(k, d string) string {
+	if v := os.Getenv(k); v != "" {
+		return v
+	}
+	return d
+}
@@ -0,0 +1,264 @@
+package queue
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"mailer-service/storage"
+)
+
+// Claves de la cola en Redis:
+//
+//   - ranked: sorted set de ids listos para enviar, con score rank(priority,
+//     momento de envío): se reclaman primero los de mayor prioridad y, a
+//     igual prioridad, los que antes pudieron enviarse, como en DBQueue.
+//   - processing: lista de ids reclamados; el script claim los mueve desde
+//     ranked de forma atómica, así que un id nunca se pierde si el worker cae.
+//   - delayed: sorted set de ids programados o en reintento, con el momento
+//     de envío (ms) como score; se pasan a ranked cuando llega su hora.
+//   - priority: hash id -> priority, para ordenar los que pasan de delayed
+//     a ranked.
+//
+// Los sorted sets no admiten duplicados: encolar dos veces un id solo
+// actualiza su posición.
+const (
+	keyReady      = "mailer:queue:ranked"
+	keyProcessing = "mailer:queue:processing"
+	keyDelayed    = "mailer:queue:delayed"
+	keyPriority   = "mailer:queue:priority"
+)
+
+// priorityWeight son los ms que vale un punto de prioridad en el score de
+// ranked: más que cualquier diferencia real entre momentos de envío.
+const priorityWeight = 1e13
+
+// rank es el score de ranked de un correo con prioridad priority que pudo
+// enviarse en at.
+func rank(priority int, at time.Time) float64 {
+	return float64(at.UnixMilli()) - float64(priority)*priorityWeight
+}
+
+// promoteBatch limita los ids que se pasan de delayed a ranked por reclamo.
+const promoteBatch = 1000
+
+var promote = redis.NewScript(`
+local ids = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'WITHSCORES', 'LIMIT', 0, ARGV[2])
+for i = 1, #ids, 2 do
+	local id, at = ids[i], tonumber(ids[i + 1])
+	local prio = tonumber(redis.call('HGET', KEYS[3], id) or '0')
+	redis.call('ZREM', KEYS[1], id)
+	redis.call('ZADD', KEYS[2], at - prio * tonumber(ARGV[3]), id)
+end
+return #ids / 2
+`)
+
+// claim mueve a processing los ARGV[1] primeros ids de ranked.
+var claim = redis.NewScript(`
+local ids = redis.call('ZRANGE', KEYS[1], 0, tonumber(ARGV[1]) - 1)
+for _, id in ipairs(ids) do
+	redis.call('ZREM', KEYS[1], id)
+	redis.call('LPUSH', KEYS[2], id)
+end
+return ids
+`)
+
+// requeue devuelve a ranked, como listos en ARGV[1] (ms), los ids de ARGV[3..]
+// que estén en processing.
+var requeue = redis.NewScript(`
+for i = 3, #ARGV do
+	local id = ARGV[i]
+	redis.call('LREM', KEYS[1], 1, id)
+	local prio = tonumber(redis.call('HGET', KEYS[3], id) or '0')
+	redis.call('ZADD', KEYS[2], tonumber(ARGV[1]) - prio * tonumber(ARGV[2]), id)
+end
+return #ARGV - 2
+`)
+
+// RedisQueue despacha con sorted sets y una lista de Redis (patrón de cola
+// fiable) en lugar de sondear Postgres. El estado del correo sigue en el
+// Repository, que es la fuente de verdad: ClaimByIDs descarta los ids que ya
+// no están pendientes, así que un id repetido en Redis nunca se envía dos
+// veces. El orden es el de DBQueue: ranked aplica la prioridad y
+// ClaimByIDs, el límite de un correo en curso por destinatario en orden de
+// creación.
+type RedisQueue struct {
+	Store  storage.Repository
+	Client *redis.Client
+}
+
+// NewRedis conecta con url. La cola se reconstruye con Init cuando la base
+// de datos está disponible.
+func NewRedis(ctx context.Context, s storage.Repository, url string) (*RedisQueue, error) {
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, err
+	}
+	q := &RedisQueue{Store: s, Client: redis.NewClient(opts)}
+	if err := q.Client.Ping(ctx).Err(); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+// Init reconstruye la cola desde la base de datos, de modo que los correos
+// pendientes de un arranque anterior (o creados con QUEUE_BACKEND=postgres)
+// no se pierden: vuelve a encolar todos los pendientes. No borra nada, ya
+// que la cola es común a todas las réplicas: los ids que ya estaban solo
+// cambian de posición y processing, con los reclamos en curso de otras
+// réplicas, no se toca.
+func (q *RedisQueue) Init(ctx context.Context) error {
+	pending, err := q.Store.PendingDue(ctx)
+	if err != nil {
+		return err
+	}
+	for _, p := range pending {
+		if err := q.enqueue(ctx, p.ID, p.At, p.Priority); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Enqueue consulta la prioridad del correo para ordenarlo en la cola.
+func (q *RedisQueue) Enqueue(ctx context.Context, id int64, at time.Time) error {
+	e, err := q.Store.GetEmail(ctx, id)
+	if err != nil {
+		return err
+	}
+	return q.enqueue(ctx, id, at, e.Priority)
+}
+
+// enqueue deja id en delayed si at es futuro y en ranked si no, y lo quita
+// del otro conjunto.
+func (q *RedisQueue) enqueue(ctx context.Context, id int64, at time.Time, priority int) error {
+	_, err := q.Client.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		p.HSet(ctx, keyPriority, id, priority)
+		if at.After(time.Now()) {
+			p.ZRem(ctx, keyReady, id)
+			p.ZAdd(ctx, keyDelayed, redis.Z{Score: float64(at.UnixMilli()), Member: id})
+		} else {
+			p.ZRem(ctx, keyDelayed, id)
+			p.ZAdd(ctx, keyReady, redis.Z{Score: rank(priority, at), Member: id})
+		}
+		return nil
+	})
+	return err
+}
+
+// maxClaimRounds limita las vueltas de Claim cuando los ids reclamados de
+// Redis están retenidos por el orden por destinatario.
+const maxClaimRounds = 10
+
+// Claim toma de ranked los primeros ids y los reclama con ClaimByIDs. Los
+// que no se reclaman por tener su destinatario otro correo en curso o uno
+// anterior se retienen en processing mientras se prueban los siguientes y
+// al final vuelven a ranked en su sitio, de modo que no ocupan el lote.
+func (q *RedisQueue) Claim(ctx context.Context, n int) ([]storage.Email, error) {
+	now := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	if err := promote.Run(ctx, q.Client, []string{keyDelayed, keyReady, keyPriority}, now, promoteBatch, priorityWeight).Err(); err != nil {
+		return nil, err
+	}
+
+	var claimed []storage.Email
+	var held []storage.Email
+	defer func() {
+		for _, e := range held {
+			q.Ack(ctx, e.ID)
+			q.enqueue(ctx, e.ID, e.DueAt(), e.Priority)
+		}
+	}()
+	for round := 0; round < maxClaimRounds && len(claimed) < n; round++ {
+		vals, err := claim.Run(ctx, q.Client, []string{keyReady, keyProcessing}, n-len(claimed)).StringSlice()
+		if err != nil {
+			// Los ya reclamados están en sending: se devuelven aunque falle
+			// una vuelta posterior.
+			if len(claimed) > 0 {
+				break
+			}
+			return nil, err
+		}
+		if len(vals) == 0 {
+			break
+		}
+		var ids []int64
+		for _, v := range vals {
+			id, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				q.Client.LRem(ctx, keyProcessing, 1, v)
+				continue
+			}
+			ids = append(ids, id)
+		}
+
+		got, err := q.Store.ClaimByIDs(ctx, ids)
+		if err != nil {
+			// Se devuelven a ranked para reintentarlos en el próximo sondeo.
+			q.requeue(ctx, ids)
+			if len(claimed) > 0 {
+				break
+			}
+			return nil, err
+		}
+		claimed = append(claimed, got...)
+
+		// Los ids no reclamados ya se enviaron, se borraron, aún no es su
+		// momento (p. ej. un reintento reprogramado) o los retiene otro
+		// correo del mismo destinatario: se quitan de processing y se
+		// vuelven a encolar solo si siguen pendientes. Los de campañas
+		// pausadas se descartan; al reanudar la campaña se encolan de nuevo.
+		ok := make(map[int64]bool, len(got))
+		for _, e := range got {
+			ok[e.ID] = true
+		}
+		for _, id := range ids {
+			if ok[id] {
+				continue
+			}
+			e, err := q.Store.GetEmail(ctx, id)
+			if err == nil && e.Pending() && !q.paused(ctx, e.Campaign) {
+				held = append(held, e)
+				continue
+			}
+			q.Ack(ctx, id)
+		}
+	}
+	return claimed, nil
+}
+
+// paused indica si la campaña existe y está pausada.
+func (q *RedisQueue) paused(ctx context.Context, campaign string) bool {
+	if campaign == "" {
+		return false
+	}
+	c, err := q.Store.GetCampaign(ctx, campaign)
+	return err == nil && c.Paused
+}
+
+func (q *RedisQueue) Ack(ctx context.Context, id int64) error {
+	_, err := q.Client.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		p.LRem(ctx, keyProcessing, 1, id)
+		p.HDel(ctx, keyPriority, strconv.FormatInt(id, 10))
+		return nil
+	})
+	return err
+}
+
+func (q *RedisQueue) Nack(ctx context.Context, ids []int64) (int64, error) {
+	n, err := q.Store.RequeueClaimed(ctx, ids)
+	if err != nil {
+		return 0, err
+	}
+	return n, q.requeue(ctx, ids)
+}
+
+// requeue mueve ids de processing a ranked, como listos ahora.
+func (q *RedisQueue) requeue(ctx context.Context, ids []int64) error {
+	args := []any{time.Now().UnixMilli(), priorityWeight}
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	return requeue.Run(ctx, q.Client, []string{keyProcessing, keyReady, keyPriority}, args...).Err()
+}
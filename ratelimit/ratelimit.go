@@ -0,0 +1,131 @@
+package ratelimit
+
+import (
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"mailer-service/metrics"
+)
+
+// Bucket es un token bucket que se rellena de forma continua hasta su
+// capacidad. Un *Bucket nil no limita nada.
+type Bucket struct {
+	mu       sync.Mutex
+	capacity float64
+	tokens   float64
+	perSec   float64
+	last     time.Time
+}
+
+// NewPerMinute crea un bucket que permite perMinute operaciones por minuto.
+func NewPerMinute(perMinute int) *Bucket {
+	return &Bucket{
+		capacity: float64(perMinute),
+		tokens:   float64(perMinute),
+		perSec:   float64(perMinute) / 60,
+		last:     time.Now(),
+	}
+}
+
+var (
+	allowedTotal   = metrics.NewCounter("mailer_global_sends_allowed_total", "Envíos admitidos por el límite global.")
+	throttledTotal = metrics.NewCounter("mailer_global_sends_throttled_total", "Envíos frenados por el límite global.")
+)
+
+// Global crea el límite global de envíos a partir de GLOBAL_SEND_RATE
+// (envíos por minuto). Devuelve nil si no está configurado.
+func Global() *Bucket {
+	n, err := strconv.Atoi(os.Getenv("GLOBAL_SEND_RATE"))
+	if err != nil || n <= 0 {
+		return nil
+	}
+	b := NewPerMinute(n)
+	metrics.NewGaugeFunc("mailer_global_send_rate_limit", "Límite global de envíos por minuto.",
+		func() float64 { return float64(n) })
+	metrics.NewGaugeFunc("mailer_global_send_tokens", "Envíos disponibles ahora mismo en el límite global.",
+		func() float64 { return b.Available() })
+	return b
+}
+
+func (b *Bucket) refill(now time.Time) {
+	b.tokens += now.Sub(b.last).Seconds() * b.perSec
+	if b.tokens > b.capacity {
+		b.tokens = b.capacity
+	}
+	b.last = now
+}
+
+// Allow consume un token si hay disponible.
+func (b *Bucket) Allow() bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill(time.Now())
+	if b.tokens < 1 {
+		throttledTotal.Inc()
+		return false
+	}
+	b.tokens--
+	allowedTotal.Inc()
+	return true
+}
+
+// Available devuelve los tokens disponibles (sin consumirlos).
+func (b *Bucket) Available() float64 {
+	if b == nil {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(time.Now())
+	return b.tokens
+}
+
+// PerMinute devuelve el límite configurado (0 = sin límite).
+func (b *Bucket) PerMinute() int {
+	if b == nil {
+		return 0
+	}
+	return int(b.capacity)
+}
+
+// ResetIn estima cuánto falta para que el bucket vuelva a estar lleno.
+func (b *Bucket) ResetIn() time.Duration {
+	if b == nil {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(time.Now())
+	return time.Duration((b.capacity - b.tokens) / b.perSec * float64(time.Second))
+}
+
+// Limit devuelve cuántas operaciones de n pueden intentarse ahora.
+func (b *Bucket) Limit(n int) int {
+	if b == nil {
+		return n
+	}
+	if avail := int(b.Available()); avail < n {
+		return avail
+	}
+	return n
+}
+
+// RetryAfter estima cuánto falta para que haya un token disponible.
+func (b *Bucket) RetryAfter() time.Duration {
+	if b == nil {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(time.Now())
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / b.perSec * float64(time.Second))
+}
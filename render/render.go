@@ -0,0 +1,330 @@
+package render
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"os"
+	"strings"
+	"text/template"
+	"text/template/parse"
+	"time"
+
+	"mailer-service/storage"
+)
+
+// Result es el asunto y cuerpo ya renderizados de una plantilla.
+type Result struct {
+	Subject string
+	Body    string
+}
+
+// Renderer renderiza plantillas resolviendo los parciales referenciados con
+// {{template "nombre" .}} desde otras filas de la tabla templates.
+type Renderer struct {
+	Store storage.Repository
+}
+
+// Nombres internos del asunto y el cuerpo dentro del conjunto de plantillas,
+// elegidos para no chocar con el nombre de un parcial.
+const (
+	subjectName = "__subject"
+	bodyName    = "__body"
+)
+
+// LayoutName es el nombre de la plantilla que envuelve el cuerpo de todos
+// los correos; el cuerpo se inserta en {{.Content}}.
+const LayoutName = "__layout"
+
+// ErrCycle indica una inclusión cíclica entre plantillas.
+var ErrCycle = errors.New("inclusión cíclica de plantillas")
+
+// ErrTimeout indica que la ejecución de una plantilla superó
+// TEMPLATE_RENDER_TIMEOUT.
+var ErrTimeout = errors.New("tiempo de renderizado agotado")
+
+// renderTimeout devuelve TEMPLATE_RENDER_TIMEOUT (por defecto 5s).
+func renderTimeout() time.Duration {
+	d, err := time.ParseDuration(os.Getenv("TEMPLATE_RENDER_TIMEOUT"))
+	if err != nil || d <= 0 {
+		return 5 * time.Second
+	}
+	return d
+}
+
+// ParseDelims interpreta la configuración de delimitadores ("[[ ]]").
+// Una cadena vacía equivale a los delimitadores por defecto "{{ }}".
+func ParseDelims(s string) (left, right string, err error) {
+	if strings.TrimSpace(s) == "" {
+		return "{{", "}}", nil
+	}
+	parts := strings.Fields(s)
+	if len(parts) != 2 || parts[0] == parts[1] {
+		return "", "", fmt.Errorf(`delims inválido: se esperan dos delimitadores distintos separados por espacio, p. ej. "[[ ]]"`)
+	}
+	return parts[0], parts[1], nil
+}
+
+// TruncateSubject recorta s a como máximo max caracteres, cortando en el
+// último espacio y añadiendo "…". Devuelve true si hubo recorte; max <= 0
+// desactiva el recorte.
+func TruncateSubject(s string, max int) (string, bool) {
+	runes := []rune(s)
+	if max <= 0 || len(runes) <= max {
+		return s, false
+	}
+	if max == 1 {
+		return "…", true
+	}
+	cut := string(runes[:max-1])
+	if i := strings.LastIndexAny(cut, " \t"); i > 0 {
+		cut = cut[:i]
+	}
+	return strings.TrimRight(cut, " \t.,;:-") + "…", true
+}
+
+// Render renderiza el asunto y el cuerpo de t con las variables dadas,
+// usando los delimitadores configurados en la plantilla, tras validar las
+// variables con su VariablesSchema (*VariablesError). El cuerpo se
+// ejecuta con html/template, de modo que las variables se escapan según el
+// contexto ({{if}}, {{range}}, atributos, URLs...); el asunto es texto plano.
+func (r *Renderer) Render(ctx context.Context, t storage.Template, vars map[string]any) (Result, error) {
+	return r.render(ctx, t, vars, true)
+}
+
+// RenderText es Render para correos text/plain: el cuerpo se ejecuta con
+// text/template y las variables se insertan sin escapar.
+func (r *Renderer) RenderText(ctx context.Context, t storage.Template, vars map[string]any) (Result, error) {
+	return r.render(ctx, t, vars, false)
+}
+
+func (r *Renderer) render(ctx context.Context, t storage.Template, vars map[string]any, html bool) (Result, error) {
+	if err := ValidateVariables(t.VariablesSchema, vars); err != nil {
+		return Result{}, err
+	}
+	subject, err := r.execute(ctx, subjectName, t.Subject, t.Delims, t.Name, vars, false)
+	if err != nil {
+		return Result{}, err
+	}
+	body, err := r.execute(ctx, bodyName, t.Body, t.Delims, t.Name, vars, html)
+	if err != nil {
+		return Result{}, err
+	}
+	return Result{Subject: subject, Body: body}, nil
+}
+
+// Layout envuelve body con la plantilla LayoutName. Si no existe se
+// devuelve body sin cambios. body ya es HTML y se inserta sin escapar.
+func (r *Renderer) Layout(ctx context.Context, subject, body string) (string, error) {
+	t, err := r.Store.GetTemplateByName(ctx, LayoutName)
+	if errors.Is(err, sql.ErrNoRows) {
+		return body, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return r.execute(ctx, bodyName, t.Body, t.Delims, t.Name, map[string]any{
+		"Content": htmltemplate.HTML(body),
+		"Subject": subject,
+	}, true)
+}
+
+// Check verifica que t compile y que sus inclusiones no formen un ciclo.
+// Los parciales que aún no existen se ignoran, ya que pueden crearse después.
+func (r *Renderer) Check(ctx context.Context, t storage.Template) error {
+	for _, part := range []struct{ name, src string }{{subjectName, t.Subject}, {bodyName, t.Body}} {
+		if _, err := r.parse(ctx, part.name, part.src, t.Delims, t.Name, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Renderer) execute(ctx context.Context, name, src, delims, owner string, vars map[string]any, html bool) (string, error) {
+	srcs, err := r.parse(ctx, name, src, delims, owner, false)
+	if err != nil {
+		return "", err
+	}
+
+	// text/template no admite cancelación: la ejecución va en su propia
+	// goroutine y la petición deja de esperarla al vencer el plazo. El
+	// writer corta la ejecución en cuanto la plantilla vuelve a escribir.
+	timeout := renderTimeout()
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	buf := &ctxWriter{ctx: ctx}
+	done := make(chan error, 1)
+	go func() {
+		if html {
+			done <- executeHTML(buf, srcs, vars)
+		} else {
+			done <- executeText(buf, srcs, vars)
+		}
+	}()
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return "", fmt.Errorf("%w: la plantilla %q superó %s", ErrTimeout, owner, timeout)
+	}
+	if err != nil {
+		return "", fmt.Errorf("error renderizando plantilla (%s): %w", name, err)
+	}
+	return buf.buf.String(), nil
+}
+
+// ctxWriter acumula la salida de una plantilla y falla en cuanto vence ctx.
+type ctxWriter struct {
+	ctx context.Context
+	buf bytes.Buffer
+}
+
+func (w *ctxWriter) Write(p []byte) (int, error) {
+	if err := w.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return w.buf.Write(p)
+}
+
+// source es una plantilla del conjunto: la principal o uno de sus parciales.
+type source struct {
+	name, src, delims string
+}
+
+// executeText ejecuta el conjunto srcs (la principal primero) con text/template.
+func executeText(w io.Writer, srcs []source, vars map[string]any) error {
+	var set *template.Template
+	for _, s := range srcs {
+		left, right, _ := ParseDelims(s.delims)
+		var t *template.Template
+		if set == nil {
+			set = template.New(s.name)
+			t = set
+		} else {
+			t = set.New(s.name)
+		}
+		if _, err := t.Delims(left, right).Parse(s.src); err != nil {
+			return err
+		}
+	}
+	return set.Execute(w, vars)
+}
+
+// executeHTML ejecuta el conjunto srcs (la principal primero) con html/template.
+func executeHTML(w io.Writer, srcs []source, vars map[string]any) error {
+	var set *htmltemplate.Template
+	for _, s := range srcs {
+		left, right, _ := ParseDelims(s.delims)
+		var t *htmltemplate.Template
+		if set == nil {
+			set = htmltemplate.New(s.name)
+			t = set
+		} else {
+			t = set.New(s.name)
+		}
+		if _, err := t.Delims(left, right).Parse(s.src); err != nil {
+			return err
+		}
+	}
+	return set.Execute(w, vars)
+}
+
+// parse compila src y todos los parciales que referencia, de forma
+// recursiva, y devuelve sus fuentes con src en primer lugar.
+func (r *Renderer) parse(ctx context.Context, name, src, delims, owner string, allowMissing bool) ([]source, error) {
+	left, right, err := ParseDelims(delims)
+	if err != nil {
+		return nil, err
+	}
+	tpl, err := template.New(name).Delims(left, right).Parse(src)
+	if err != nil {
+		return nil, fmt.Errorf("error en plantilla (%s): %w", name, err)
+	}
+
+	srcs := []source{{name: name, src: src, delims: delims}}
+	stack := []string{owner}
+	if err := r.resolve(ctx, tpl, tpl, stack, allowMissing, &srcs); err != nil {
+		return nil, err
+	}
+	return srcs, nil
+}
+
+// resolve carga los parciales referenciados por cur que no estén definidos
+// en el conjunto. stack contiene la cadena de inclusiones actual y permite
+// detectar ciclos; cada parcial cargado se añade a srcs.
+func (r *Renderer) resolve(ctx context.Context, set, cur *template.Template, stack []string, allowMissing bool, srcs *[]source) error {
+	for _, ref := range references(cur) {
+		for _, s := range stack {
+			if s == ref {
+				return fmt.Errorf("%w: %s -> %s", ErrCycle, strings.Join(stack, " -> "), ref)
+			}
+		}
+		if set.Lookup(ref) != nil {
+			continue
+		}
+
+		p, err := r.Store.GetTemplateByName(ctx, ref)
+		if errors.Is(err, sql.ErrNoRows) {
+			if allowMissing {
+				continue
+			}
+			return fmt.Errorf("parcial %q no encontrado", ref)
+		}
+		if err != nil {
+			return err
+		}
+
+		left, right, err := ParseDelims(p.Delims)
+		if err != nil {
+			return fmt.Errorf("parcial %q: %w", ref, err)
+		}
+		pt, err := set.New(ref).Delims(left, right).Parse(p.Body)
+		if err != nil {
+			return fmt.Errorf("error en parcial %q: %w", ref, err)
+		}
+		*srcs = append(*srcs, source{name: ref, src: p.Body, delims: p.Delims})
+		if err := r.resolve(ctx, set, pt, append(stack, ref), allowMissing, srcs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// references devuelve los nombres usados en {{template "x"}} dentro de t.
+func references(t *template.Template) []string {
+	if t.Tree == nil {
+		return nil
+	}
+	var out []string
+	var walk func(n parse.Node)
+	walk = func(n parse.Node) {
+		switch n := n.(type) {
+		case *parse.ListNode:
+			if n == nil {
+				return
+			}
+			for _, c := range n.Nodes {
+				walk(c)
+			}
+		case *parse.TemplateNode:
+			out = append(out, n.Name)
+		case *parse.IfNode:
+			walk(n.List)
+			walk(n.ElseList)
+		case *parse.RangeNode:
+			walk(n.List)
+			walk(n.ElseList)
+		case *parse.WithNode:
+			walk(n.List)
+			walk(n.ElseList)
+		}
+	}
+	walk(t.Tree.Root)
+	return out
+}
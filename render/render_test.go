@@ -0,0 +1,101 @@
+package render
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"mailer-service/storage"
+)
+
+// Las variables del cuerpo se escapan según el contexto en que aparecen,
+// también dentro de {{if}}, {{range}} y parciales; el asunto no se escapa.
+func TestRenderContextualEscaping(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemStore()
+	if _, err := store.InsertTemplate(ctx, storage.Template{Name: "firma", Body: `<em>{{.Firma}}</em>`}); err != nil {
+		t.Fatal(err)
+	}
+	r := &Renderer{Store: store}
+	vars := map[string]any{
+		"Nombre": `<b>O'Brien & Co</b>`,
+		"Vip":    true,
+		"Items": []map[string]any{
+			{"Titulo": `<script>alert(1)</script>`, "URL": "javascript:alert(1)", "Clase": `x" onclick="alert(1)`},
+			{"Titulo": "Café", "URL": "https://example.com/a b?q=1&r=<2>", "Clase": "ok"},
+		},
+		"Dato":  `</script><script>alert(1)</script>`,
+		"Firma": `<img src=x onerror=alert(1)>`,
+	}
+
+	tests := []struct {
+		name, body, want string
+	}{
+		{"texto en if", `{{if .Vip}}Hola {{.Nombre}}{{end}}`,
+			`Hola &lt;b&gt;O&#39;Brien &amp; Co&lt;/b&gt;`},
+		{"else de if", `{{if not .Vip}}-{{else}}<p title="{{.Nombre}}">x</p>{{end}}`,
+			`<p title="&lt;b&gt;O&#39;Brien &amp; Co&lt;/b&gt;">x</p>`},
+		{"texto en range", `{{range .Items}}<li>{{.Titulo}}</li>{{end}}`,
+			`<li>&lt;script&gt;alert(1)&lt;/script&gt;</li><li>Café</li>`},
+		{"URL en range", `{{range .Items}}<a href="{{.URL}}">x</a>{{end}}`,
+			`<a href="#ZgotmplZ">x</a><a href="https://example.com/a%20b?q=1&amp;r=%3c2%3e">x</a>`},
+		{"atributo en range", `{{range .Items}}<span class="{{.Clase}}"></span>{{end}}`,
+			`<span class="x&#34; onclick=&#34;alert(1)"></span><span class="ok"></span>`},
+		{"script", `<script>var d = {{.Dato}};</script>`,
+			`<script>var d = "\u003c/script\u003e\u003cscript\u003ealert(1)\u003c/script\u003e";</script>`},
+		{"parcial", `{{if .Vip}}{{template "firma" .}}{{end}}`,
+			`<em>&lt;img src=x onerror=alert(1)&gt;</em>`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := r.Render(ctx, storage.Template{Name: "t", Subject: "Para {{.Nombre}}", Body: tt.body}, vars)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.Body != tt.want {
+				t.Errorf("cuerpo\n%s\nse esperaba\n%s", res.Body, tt.want)
+			}
+			if want := `Para <b>O'Brien & Co</b>`; res.Subject != want {
+				t.Errorf("asunto %q, se esperaba %q", res.Subject, want)
+			}
+		})
+	}
+}
+
+// RenderText no escapa las variables del cuerpo.
+func TestRenderTextDoesNotEscape(t *testing.T) {
+	r := &Renderer{Store: storage.NewMemStore()}
+	tpl := storage.Template{Name: "t", Subject: "Para {{.Nombre}}", Body: "Hola {{.Nombre}}{{range .Items}}\n- {{.}}{{end}}"}
+	vars := map[string]any{"Nombre": "O'Brien & Co", "Items": []string{"<caja>", `"envío"`}}
+
+	res, err := r.RenderText(context.Background(), tpl, vars)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "Hola O'Brien & Co\n- <caja>\n- \"envío\""; res.Body != want {
+		t.Errorf("cuerpo %q, se esperaba %q", res.Body, want)
+	}
+	if want := "Para O'Brien & Co"; res.Subject != want {
+		t.Errorf("asunto %q, se esperaba %q", res.Subject, want)
+	}
+}
+
+// Una plantilla que no termina en TEMPLATE_RENDER_TIMEOUT falla con
+// ErrTimeout sin esperar a que acabe.
+func TestRenderTimeout(t *testing.T) {
+	t.Setenv("TEMPLATE_RENDER_TIMEOUT", "50ms")
+	r := &Renderer{Store: storage.NewMemStore()}
+	// 10^15 iteraciones anidadas: no acabaría nunca.
+	body := `{{range .N}}{{range $.N}}{{range $.N}}{{range $.N}}{{range $.N}}x{{end}}{{end}}{{end}}{{end}}{{end}}`
+	vars := map[string]any{"N": make([]int, 1000)}
+
+	start := time.Now()
+	_, err := r.Render(context.Background(), storage.Template{Name: "lenta", Subject: "s", Body: body}, vars)
+	if !errors.Is(err, ErrTimeout) {
+		t.Fatalf("error %v, se esperaba ErrTimeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Render tardó %s con un plazo de 50ms", elapsed)
+	}
+}
@@ -0,0 +1,135 @@
+package render
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"mailer-service/storage"
+)
+
+// FieldError es el error de validación de una variable.
+type FieldError struct {
+	Field string `json:"field"`
+	Error string `json:"error"`
+}
+
+// VariablesError agrupa los errores de validación de las variables frente
+// al esquema de la plantilla.
+type VariablesError struct {
+	Fields []FieldError
+}
+
+func (e *VariablesError) Error() string {
+	parts := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		parts[i] = f.Field + ": " + f.Error
+	}
+	return "variables inválidas: " + strings.Join(parts, "; ")
+}
+
+var schemaTypes = map[string]bool{
+	"string": true, "number": true, "integer": true, "boolean": true, "array": true, "object": true,
+}
+
+// CheckSchema verifica que el esquema de variables esté bien formado.
+func CheckSchema(specs []storage.VariableSpec) error {
+	seen := map[string]bool{}
+	for i, s := range specs {
+		if s.Name == "" {
+			return fmt.Errorf("variables_schema[%d]: falta name", i)
+		}
+		if seen[s.Name] {
+			return fmt.Errorf("variables_schema: variable %q repetida", s.Name)
+		}
+		seen[s.Name] = true
+		if !schemaTypes[s.Type] {
+			return fmt.Errorf("variables_schema: tipo %q inválido en %q (use string, number, integer, boolean, array u object)", s.Type, s.Name)
+		}
+	}
+	return nil
+}
+
+// ValidateVariables comprueba vars frente a specs: que estén las requeridas
+// y que cada una declarada tenga el tipo indicado. Las variables no
+// declaradas se permiten. Devuelve *VariablesError si algo no cumple.
+func ValidateVariables(specs []storage.VariableSpec, vars map[string]any) error {
+	var errs []FieldError
+	for _, s := range specs {
+		v, ok := vars[s.Name]
+		if !ok || v == nil {
+			if s.Required {
+				errs = append(errs, FieldError{Field: s.Name, Error: "requerida"})
+			}
+			continue
+		}
+		if !hasType(v, s.Type) {
+			errs = append(errs, FieldError{Field: s.Name, Error: "se espera " + s.Type})
+		}
+	}
+	if len(errs) > 0 {
+		return &VariablesError{Fields: errs}
+	}
+	return nil
+}
+
+// PlaceholderVariables devuelve vars completado con un valor de ejemplo,
+// según su tipo, para cada variable de specs que falte ("[nombre]" para
+// string, 1, true, lista u objeto vacío). Sirve para renderizar una
+// plantilla sin datos reales; vars no se modifica.
+func PlaceholderVariables(specs []storage.VariableSpec, vars map[string]any) map[string]any {
+	out := make(map[string]any, len(specs)+len(vars))
+	for k, v := range vars {
+		out[k] = v
+	}
+	for _, s := range specs {
+		if v, ok := out[s.Name]; ok && v != nil {
+			continue
+		}
+		switch s.Type {
+		case "string":
+			out[s.Name] = "[" + s.Name + "]"
+		case "number", "integer":
+			out[s.Name] = 1
+		case "boolean":
+			out[s.Name] = true
+		case "array":
+			out[s.Name] = []any{}
+		case "object":
+			out[s.Name] = map[string]any{}
+		}
+	}
+	return out
+}
+
+// hasType compara con los tipos que produce encoding/json al decodificar
+// en any (los números llegan como float64).
+func hasType(v any, typ string) bool {
+	switch typ {
+	case "string":
+		_, ok := v.(string)
+		return ok
+	case "boolean":
+		_, ok := v.(bool)
+		return ok
+	case "number":
+		switch v.(type) {
+		case float64, float32, int, int64:
+			return true
+		}
+	case "integer":
+		switch n := v.(type) {
+		case int, int64:
+			return true
+		case float64:
+			return n == math.Trunc(n) && !math.IsInf(n, 0)
+		}
+	case "array":
+		_, ok := v.([]any)
+		return ok
+	case "object":
+		_, ok := v.(map[string]any)
+		return ok
+	}
+	return false
+}
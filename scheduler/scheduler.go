@@ -0,0 +1,220 @@
+package scheduler
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"mailer-service/mailer"
+	"mailer-service/queue"
+	"mailer-service/render"
+	"mailer-service/storage"
+
+	"github.com/robfig/cron/v3"
+)
+
+// Parse valida una expresión cron estándar de cinco campos. Se aceptan
+// también los descriptores (@daily, @weekly...) y el prefijo CRON_TZ=.
+func Parse(expr string) (cron.Schedule, error) {
+	sched, err := cron.ParseStandard(expr)
+	if err != nil {
+		return nil, fmt.Errorf("expresión cron inválida %q: %w", expr, err)
+	}
+	return sched, nil
+}
+
+// NextLocal devuelve, en UTC, el próximo instante posterior a now en que
+// son las clock ("15:04" o "15:04:05") en la zona IANA tz. Si esa hora no
+// existe un día por el cambio de horario, time.Date la corre hacia delante.
+func NextLocal(clock, tz string, now time.Time) (time.Time, error) {
+	loc, err := time.LoadLocation(tz)
+	if err != nil || tz == "" || tz == "Local" {
+		return time.Time{}, fmt.Errorf("timezone inválida %q: se espera un nombre IANA, p. ej. America/Bogota", tz)
+	}
+	var tod time.Time
+	for _, layout := range []string{"15:04", "15:04:05"} {
+		if tod, err = time.Parse(layout, clock); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return time.Time{}, fmt.Errorf("send_at_local inválido %q: se espera HH:MM o HH:MM:SS", clock)
+	}
+
+	local := now.In(loc)
+	for day := 0; ; day++ {
+		at := time.Date(local.Year(), local.Month(), local.Day()+day, tod.Hour(), tod.Minute(), tod.Second(), 0, loc)
+		if at.After(now) {
+			return at.UTC(), nil
+		}
+	}
+}
+
+// Scheduler materializa en la cola de emails las ocurrencias vencidas de los
+// envíos recurrentes. El envío real lo hace el worker.
+type Scheduler struct {
+	Store        storage.Repository
+	Queue        queue.Queue
+	Renderer     *render.Renderer
+	PollInterval time.Duration
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// New crea un scheduler con RECURRING_POLL_INTERVAL (por defecto 30s).
+func New(s storage.Repository) *Scheduler {
+	interval, err := time.ParseDuration(getEnv("RECURRING_POLL_INTERVAL", "30s"))
+	if err != nil || interval <= 0 {
+		interval = 30 * time.Second
+	}
+	return &Scheduler{
+		Store:        s,
+		Queue:        queue.NewDB(s),
+		Renderer:     &render.Renderer{Store: s},
+		PollInterval: interval,
+		stop:         make(chan struct{}),
+	}
+}
+
+// Start lanza el bucle de sondeo en una goroutine.
+func (sc *Scheduler) Start() {
+	sc.wg.Add(1)
+	go sc.loop()
+	log.Printf("Scheduler de recurrentes iniciado (intervalo=%s)", sc.PollInterval)
+}
+
+// Stop detiene el bucle y espera a que termine la pasada en curso o venza ctx.
+func (sc *Scheduler) Stop(ctx context.Context) error {
+	close(sc.stop)
+
+	done := make(chan struct{})
+	go func() {
+		sc.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (sc *Scheduler) loop() {
+	defer sc.wg.Done()
+	t := time.NewTicker(sc.PollInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-sc.stop:
+			return
+		case <-t.C:
+			sc.runDue()
+		}
+	}
+}
+
+// runDue encola una ocurrencia de cada recurrencia vencida. Si el servicio
+// estuvo caído, las ocurrencias perdidas se colapsan en una sola.
+func (sc *Scheduler) runDue() {
+	ctx := context.Background()
+
+	due, err := sc.Store.DueRecurring(ctx)
+	if err != nil {
+		log.Println("Error consultando recurrentes:", err)
+		return
+	}
+
+	for _, rc := range due {
+		sched, err := Parse(rc.Cron)
+		if err != nil {
+			log.Printf("Recurrente %d: %v", rc.ID, err)
+			continue
+		}
+		won, err := sc.Store.AdvanceRecurring(ctx, rc.ID, rc.NextRunAt.Time, sched.Next(time.Now()))
+		if err != nil {
+			log.Printf("Error avanzando recurrente %d: %v", rc.ID, err)
+			continue
+		}
+		if !won {
+			continue
+		}
+
+		n, err := sc.materialize(ctx, rc)
+		if err != nil {
+			log.Printf("Error materializando recurrente %d: %v", rc.ID, err)
+			continue
+		}
+		log.Printf("Recurrente %d: %d correos encolados", rc.ID, n)
+	}
+}
+
+// materialize renderiza la plantilla y encola un correo por destinatario.
+func (sc *Scheduler) materialize(ctx context.Context, rc storage.Recurring) (int, error) {
+	// La plantilla, el layout y los correos son del inquilino de la
+	// recurrencia.
+	ctx = storage.WithTenant(ctx, rc.TenantID)
+	t, err := sc.Store.GetTemplate(ctx, rc.TemplateID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, fmt.Errorf("plantilla %d no encontrada", rc.TemplateID)
+	}
+	if err != nil {
+		return 0, err
+	}
+	out, err := sc.Renderer.Render(ctx, t, rc.Variables)
+	if err != nil {
+		return 0, err
+	}
+
+	limit := t.SubjectMaxLen
+	if limit == 0 {
+		limit, _ = strconv.Atoi(getEnv("SUBJECT_MAX_LEN", "0"))
+	}
+	subject, truncated := render.TruncateSubject(mailer.NormalizeSubject(out.Subject), limit)
+	body, err := sc.Renderer.Layout(ctx, subject, out.Body)
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, to := range rc.Recipients {
+		id, err := sc.Store.InsertEmail(ctx, storage.Email{
+			To:         to,
+			Cc:         t.Cc,
+			Bcc:        t.Bcc,
+			Subject:    subject,
+			Body:       body,
+			TemplateID: sql.NullInt64{Int64: t.ID, Valid: true},
+			Status:     "queued",
+
+			SubjectTruncated: truncated,
+			AuditBcc:         mailer.GlobalBcc(),
+			Bulk:             t.Bulk,
+			ListID:           t.ListID,
+		})
+		if err != nil {
+			return n, err
+		}
+		if err := sc.Queue.Enqueue(ctx, id, time.Now()); err != nil {
+			log.Printf("Error encolando correo %d: %v", id, err)
+		}
+		n++
+	}
+	return n, nil
+}
+
+func getEnv(k, d string) string {
+	if v := os.Getenv(k); v != "" {
+		return v
+	}
+	return d
+}
@@ -0,0 +1,209 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testRepositories devuelve los almacenes contra los que se prueban las
+// reglas de la cola: siempre MemStore y, con TEST_DB_DSN, también Postgres
+// (con la tabla emails vacía).
+func testRepositories(t *testing.T) map[string]Repository {
+	t.Helper()
+	repos := map[string]Repository{"memory": NewMemStore()}
+	dsn := os.Getenv("TEST_DB_DSN")
+	if dsn == "" {
+		return repos
+	}
+	s, err := Open(dsn)
+	if err != nil {
+		t.Fatalf("abriendo %s: %v", dsn, err)
+	}
+	t.Cleanup(func() { s.DB.Close() })
+	if err := s.Init(context.Background()); err != nil {
+		t.Fatalf("conectando a %s: %v", dsn, err)
+	}
+	if err := s.TruncateEmails(context.Background()); err != nil {
+		t.Fatalf("vaciando emails: %v", err)
+	}
+	repos["postgres"] = s
+	return repos
+}
+
+func insertQueued(t *testing.T, r Repository, e Email) int64 {
+	t.Helper()
+	if e.Subject == "" {
+		e.Subject, e.Body = "s", "b"
+	}
+	if e.Status == "" {
+		e.Status = "queued"
+	}
+	id, err := r.InsertEmail(context.Background(), e)
+	if err != nil {
+		t.Fatalf("insertando correo: %v", err)
+	}
+	return id
+}
+
+// Varios workers reclaman a la vez: nunca hay dos correos en curso para el
+// mismo destinatario y cada destinatario los recibe en orden de creación.
+func TestClaimDuePerRecipientFIFO(t *testing.T) {
+	for name, r := range testRepositories(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			recipients := []string{"a@example.com", "b@example.com", "C@example.com"}
+			const perRecipient = 8
+			want := map[string][]int64{}
+			for i := 0; i < perRecipient; i++ {
+				for _, to := range recipients {
+					// Mayúsculas distintas: el orden es por dirección, sin distinguirlas.
+					if i%2 == 1 {
+						to = strings.ToUpper(to)
+					}
+					id := insertQueued(t, r, Email{To: to})
+					key := strings.ToLower(to)
+					want[key] = append(want[key], id)
+				}
+			}
+
+			var (
+				mu       sync.Mutex
+				inFlight = map[string]int64{}
+				got      = map[string][]int64{}
+				failures []string
+				sent     int
+			)
+			total := perRecipient * len(recipients)
+			var wg sync.WaitGroup
+			for w := 0; w < 8; w++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for {
+						mu.Lock()
+						done := sent == total || len(failures) > 0
+						mu.Unlock()
+						if done {
+							return
+						}
+						claimed, err := r.ClaimDue(ctx, 2)
+						if err != nil {
+							mu.Lock()
+							failures = append(failures, err.Error())
+							mu.Unlock()
+							return
+						}
+						for _, e := range claimed {
+							key := strings.ToLower(e.To)
+							mu.Lock()
+							if other, ok := inFlight[key]; ok {
+								failures = append(failures, fmt.Sprintf("%s: %d y %d en curso a la vez", key, other, e.ID))
+							}
+							inFlight[key] = e.ID
+							got[key] = append(got[key], e.ID)
+							mu.Unlock()
+						}
+						time.Sleep(time.Millisecond)
+						for _, e := range claimed {
+							mu.Lock()
+							delete(inFlight, strings.ToLower(e.To))
+							sent++
+							mu.Unlock()
+							if err := r.MarkSent(ctx, e.ID); err != nil {
+								mu.Lock()
+								failures = append(failures, err.Error())
+								mu.Unlock()
+							}
+						}
+					}
+				}()
+			}
+			wg.Wait()
+
+			for _, f := range failures {
+				t.Error(f)
+			}
+			for key, ids := range want {
+				if fmt.Sprint(got[key]) != fmt.Sprint(ids) {
+					t.Errorf("%s: reclamados %v, se esperaba el orden de creación %v", key, got[key], ids)
+				}
+			}
+		})
+	}
+}
+
+// ClaimDue despacha por priority y, a igual prioridad, por el momento en que
+// el correo pudo enviarse; los programados a futuro no se reclaman.
+func TestClaimDuePriorityOrder(t *testing.T) {
+	for name, r := range testRepositories(t) {
+		t.Run(name, func(t *testing.T) {
+			past := sql.NullTime{Time: time.Now().Add(-time.Hour), Valid: true}
+			future := sql.NullTime{Time: time.Now().Add(time.Hour), Valid: true}
+			normal := insertQueued(t, r, Email{To: "normal@example.com"})
+			scheduled := insertQueued(t, r, Email{To: "programado@example.com", Status: "scheduled", SendAt: past})
+			urgent := insertQueued(t, r, Email{To: "urgente@example.com", Priority: 9})
+			insertQueued(t, r, Email{To: "futuro@example.com", Status: "scheduled", SendAt: future, Priority: 10})
+			scheduledUrgent := insertQueued(t, r, Email{To: "programado-urgente@example.com", Status: "scheduled", SendAt: past, Priority: 9})
+
+			claimed, err := r.ClaimDue(context.Background(), 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []int64
+			for _, e := range claimed {
+				got = append(got, e.ID)
+			}
+			want := []int64{scheduledUrgent, urgent, scheduled, normal}
+			if fmt.Sprint(got) != fmt.Sprint(want) {
+				t.Errorf("orden de reclamo %v, se esperaba %v", got, want)
+			}
+		})
+	}
+}
+
+// ClaimByIDs aplica la regla FIFO por destinatario de ClaimDue aunque la
+// cola externa entregue los ids en otro orden.
+func TestClaimByIDsPerRecipientFIFO(t *testing.T) {
+	for name, r := range testRepositories(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			first := insertQueued(t, r, Email{To: "a@example.com"})
+			second := insertQueued(t, r, Email{To: "A@example.com", Priority: 9})
+			other := insertQueued(t, r, Email{To: "b@example.com"})
+
+			claimedIDs := func(ids ...int64) []int64 {
+				t.Helper()
+				claimed, err := r.ClaimByIDs(ctx, ids)
+				if err != nil {
+					t.Fatal(err)
+				}
+				var got []int64
+				for _, e := range claimed {
+					got = append(got, e.ID)
+				}
+				slices.Sort(got)
+				return got
+			}
+
+			if got, want := claimedIDs(second, other, first), []int64{first, other}; fmt.Sprint(got) != fmt.Sprint(want) {
+				t.Fatalf("reclamados %v, se esperaba %v", got, want)
+			}
+			if got := claimedIDs(second); len(got) > 0 {
+				t.Fatalf("reclamado %v con %d aún en curso", got, first)
+			}
+			if err := r.MarkSent(ctx, first); err != nil {
+				t.Fatal(err)
+			}
+			if got := claimedIDs(second); fmt.Sprint(got) != fmt.Sprint([]int64{second}) {
+				t.Errorf("reclamados %v tras enviar %d, se esperaba [%d]", got, first, second)
+			}
+		})
+	}
+}
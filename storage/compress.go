@@ -0,0 +1,100 @@
+package storage
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+)
+
+// Compresión de cuerpos: con COMPRESS_BODIES=true el cuerpo HTML de cada
+// correo nuevo se guarda comprimido con gzip en body_compressed (con
+// compressed = true) y body queda vacío. scanEmail lo descomprime al leer,
+// así que el resto del código ve siempre el cuerpo original.
+
+// CompressBodies indica si los cuerpos nuevos se guardan comprimidos.
+func CompressBodies() bool {
+	return getEnv("COMPRESS_BODIES", "false") == "true"
+}
+
+func gzipBody(body string) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := io.WriteString(zw, body); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func gunzipBody(data []byte) (string, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	defer zr.Close()
+	b, err := io.ReadAll(zr)
+	return string(b), err
+}
+
+// compressBatch limita las filas que BackfillCompressedBodies comprime por
+// transacción.
+const compressBatch = 200
+
+// BackfillCompressedBodies comprime los cuerpos de los correos guardados
+// antes de activar COMPRESS_BODIES, por lotes de compressBatch, y devuelve
+// cuántos comprimió. Se puede interrumpir y volver a lanzar: solo toma las
+// filas que aún no están comprimidas.
+func (s *Store) BackfillCompressedBodies(ctx context.Context) (int64, error) {
+	var total int64
+	for {
+		n, err := s.compressBatch(ctx)
+		total += n
+		if err != nil || n == 0 {
+			return total, err
+		}
+	}
+}
+
+func (s *Store) compressBatch(ctx context.Context) (int64, error) {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT id, body FROM emails WHERE NOT compressed AND body <> ''
+		ORDER BY id LIMIT $1 FOR UPDATE SKIP LOCKED`, compressBatch)
+	if err != nil {
+		return 0, err
+	}
+	bodies := map[int64]string{}
+	for rows.Next() {
+		var id int64
+		var body string
+		if err := rows.Scan(&id, &body); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		bodies[id] = body
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for id, body := range bodies {
+		gz, err := gzipBody(body)
+		if err != nil {
+			return 0, err
+		}
+		if _, err := tx.ExecContext(ctx,
+			`UPDATE emails SET body='', body_compressed=$1, compressed=true WHERE id=$2`, gz, id); err != nil {
+			return 0, err
+		}
+	}
+	return int64(len(bodies)), tx.Commit()
+}
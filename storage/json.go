@@ -0,0 +1,82 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+// Los tipos sql.Null* se serializan como {"String": ..., "Valid": ...}; estos
+// MarshalJSON los exponen como valores que pueden ser null.
+
+func (e Email) MarshalJSON() ([]byte, error) {
+	type alias Email
+	return json.Marshal(struct {
+		alias
+		Error         *string    `json:"error"`
+		TemplateID    *int64     `json:"template_id"`
+		SentAt        *time.Time `json:"sent_at"`
+		SendAt        *time.Time `json:"send_at"`
+		ExpiresAt     *time.Time `json:"expires_at"`
+		DateHeader    *time.Time `json:"date_header"`
+		NextRetryAt   *time.Time `json:"next_retry_at"`
+		CallbackError *string    `json:"callback_error"`
+	}{
+		alias:         alias(e),
+		Error:         nullString(e.Error),
+		TemplateID:    nullInt64(e.TemplateID),
+		SentAt:        nullTime(e.SentAt),
+		SendAt:        nullTime(e.SendAt),
+		ExpiresAt:     nullTime(e.ExpiresAt),
+		DateHeader:    nullTime(e.DateHeader),
+		NextRetryAt:   nullTime(e.NextRetryAt),
+		CallbackError: nullString(e.CallbackError),
+	})
+}
+
+func (rc Recurring) MarshalJSON() ([]byte, error) {
+	type alias Recurring
+	return json.Marshal(struct {
+		alias
+		NextRunAt *time.Time `json:"next_run_at"`
+		LastRunAt *time.Time `json:"last_run_at"`
+	}{
+		alias:     alias(rc),
+		NextRunAt: nullTime(rc.NextRunAt),
+		LastRunAt: nullTime(rc.LastRunAt),
+	})
+}
+
+func (rc Recipient) MarshalJSON() ([]byte, error) {
+	type alias Recipient
+	return json.Marshal(struct {
+		alias
+		LastBounceAt *time.Time `json:"last_bounce_at"`
+		SuppressedAt *time.Time `json:"suppressed_at"`
+	}{
+		alias:        alias(rc),
+		LastBounceAt: nullTime(rc.LastBounceAt),
+		SuppressedAt: nullTime(rc.SuppressedAt),
+	})
+}
+
+func nullString(v sql.NullString) *string {
+	if !v.Valid {
+		return nil
+	}
+	return &v.String
+}
+
+func nullInt64(v sql.NullInt64) *int64 {
+	if !v.Valid {
+		return nil
+	}
+	return &v.Int64
+}
+
+func nullTime(v sql.NullTime) *time.Time {
+	if !v.Valid {
+		return nil
+	}
+	return &v.Time
+}
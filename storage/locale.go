@@ -0,0 +1,83 @@
+package storage
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Idiomas de plantilla: una misma plantilla (nombre) puede tener una variante
+// por idioma ("es", "es-MX", ...) y una sin idioma (""). Al enviar se elige
+// la variante más cercana al idioma pedido siguiendo LocaleChain.
+
+var localeRe = regexp.MustCompile(`^[a-zA-Z]{2,3}(-[a-zA-Z0-9]{2,8})*$`)
+
+// NormalizeLocale valida locale y lo devuelve en forma canónica: idioma en
+// minúsculas, región en mayúsculas y "_" como "-" (es_mx -> es-MX).
+func NormalizeLocale(locale string) (string, error) {
+	if locale == "" {
+		return "", nil
+	}
+	l := strings.ReplaceAll(strings.TrimSpace(locale), "_", "-")
+	if !localeRe.MatchString(l) {
+		return "", fmt.Errorf("idioma inválido: %q", locale)
+	}
+	parts := strings.Split(l, "-")
+	for i, p := range parts {
+		switch {
+		case i == 0:
+			parts[i] = strings.ToLower(p)
+		case len(p) == 2:
+			parts[i] = strings.ToUpper(p)
+		case len(p) == 4:
+			parts[i] = strings.ToUpper(p[:1]) + strings.ToLower(p[1:])
+		default:
+			parts[i] = strings.ToLower(p)
+		}
+	}
+	return strings.Join(parts, "-"), nil
+}
+
+// localeFallbacks lee LOCALE_FALLBACKS ("pt=es,es-MX=es-419"): para cada
+// idioma, el siguiente a probar en lugar de su idioma base.
+func localeFallbacks() map[string]string {
+	out := map[string]string{}
+	for _, pair := range strings.Split(getEnv("LOCALE_FALLBACKS", ""), ",") {
+		from, to, ok := strings.Cut(pair, "=")
+		if !ok {
+			continue
+		}
+		from, err1 := NormalizeLocale(from)
+		to, err2 := NormalizeLocale(to)
+		if err1 == nil && err2 == nil && from != "" {
+			out[from] = to
+		}
+	}
+	return out
+}
+
+// LocaleChain devuelve, en orden, los idiomas que se prueban al buscar una
+// plantilla para locale: el propio idioma, su alternativa de LOCALE_FALLBACKS
+// o, si no tiene, su idioma base (es-MX -> es), y así sucesivamente; después
+// DEFAULT_LOCALE con la misma regla y por último "" (sin idioma).
+func LocaleChain(locale string) []string {
+	fallbacks := localeFallbacks()
+	var chain []string
+	walk := func(l string) {
+		l, _ = NormalizeLocale(l)
+		for l != "" && !slices.Contains(chain, l) {
+			chain = append(chain, l)
+			if next, ok := fallbacks[l]; ok {
+				l = next
+			} else if i := strings.LastIndex(l, "-"); i > 0 {
+				l = l[:i]
+			} else {
+				l = ""
+			}
+		}
+	}
+	walk(locale)
+	walk(getEnv("DEFAULT_LOCALE", ""))
+	return append(chain, "")
+}
@@ -0,0 +1,1275 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ==========================================================
+// ALMACÉN EN MEMORIA
+// ==========================================================
+
+// MemStore implementa Repository con mapas protegidos por un mutex. Sigue la
+// misma semántica que Store (ids autoincrementales, transiciones de estado,
+// orden de la cola) pero no persiste nada. Pensado para pruebas y demos.
+type MemStore struct {
+	mu sync.Mutex
+
+	emails      map[int64]Email
+	attachments map[int64][]Attachment
+	attempts    map[int64][]Attempt
+	templates   map[int64]Template
+	versions    []TemplateVersion
+	recurring   map[int64]Recurring
+	recipients  map[recipientKey]Recipient
+	campaigns   map[campaignKey]bool
+	audit       []AuditEntry
+
+	lastEmail, lastTemplate, lastRecurring, lastVersion, lastAttempt, lastAudit int64
+}
+
+func NewMemStore() *MemStore {
+	return &MemStore{
+		emails:      map[int64]Email{},
+		attachments: map[int64][]Attachment{},
+		attempts:    map[int64][]Attempt{},
+		templates:   map[int64]Template{},
+		recurring:   map[int64]Recurring{},
+		recipients:  map[recipientKey]Recipient{},
+		campaigns:   map[campaignKey]bool{},
+	}
+}
+
+// Init no hace nada: no hay conexión ni esquema.
+func (m *MemStore) Init(ctx context.Context) error {
+	return nil
+}
+
+// AppliedSchemaVersion siempre coincide con SchemaVersion: no hay esquema.
+func (m *MemStore) AppliedSchemaVersion(ctx context.Context) (int, error) {
+	return SchemaVersion, nil
+}
+
+// ----------------------------------------------------------
+// Correos
+// ----------------------------------------------------------
+
+func (m *MemStore) InsertEmail(ctx context.Context, e Email) (int64, error) {
+	if err := checkBodySize(e); err != nil {
+		return 0, err
+	}
+	// Los adjuntos con Open se leen antes de bloquear: aquí no hay otro
+	// sitio donde guardarlos que la memoria.
+	atts := make([]Attachment, 0, len(e.Attachments))
+	for _, a := range e.Attachments {
+		content, err := a.Bytes()
+		if err != nil {
+			return 0, err
+		}
+		atts = append(atts, Attachment{Filename: a.Filename, ContentType: a.ContentType, Content: content})
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if e.Status == "" {
+		e.Status = "queued"
+	}
+	m.lastEmail++
+	e.ID = m.lastEmail
+	e.CreatedAt = time.Now()
+	e.TenantID = tenantFor(ctx, e.TenantID)
+	if len(atts) > 0 {
+		m.attachments[e.ID] = atts
+	}
+	e.Attachments = nil
+	m.emails[e.ID] = e
+	return e.ID, nil
+}
+
+func (m *MemStore) Attachments(ctx context.Context, emailID int64) ([]Attachment, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.email(ctx, emailID); !ok {
+		return nil, nil
+	}
+	return append([]Attachment(nil), m.attachments[emailID]...), nil
+}
+
+func (m *MemStore) RecordAttempt(ctx context.Context, a Attempt) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.emails[a.EmailID]; !ok {
+		return fmt.Errorf("correo %d no existe", a.EmailID)
+	}
+	m.lastAttempt++
+	a.ID = m.lastAttempt
+	a.AttemptedAt = time.Now()
+	m.attempts[a.EmailID] = append(m.attempts[a.EmailID], a)
+	return nil
+}
+
+func (m *MemStore) ListAttempts(ctx context.Context, emailID int64) ([]Attempt, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.email(ctx, emailID); !ok {
+		return []Attempt{}, nil
+	}
+	return append([]Attempt{}, m.attempts[emailID]...), nil
+}
+
+// email devuelve el correo id si existe y es visible con ctx.
+func (m *MemStore) email(ctx context.Context, id int64) (Email, bool) {
+	e, ok := m.emails[id]
+	return e, ok && visible(ctx, e.TenantID)
+}
+
+func (m *MemStore) GetEmail(ctx context.Context, id int64) (Email, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.email(ctx, id)
+	if !ok {
+		return Email{}, sql.ErrNoRows
+	}
+	return e, nil
+}
+
+func (m *MemStore) EmailStatuses(ctx context.Context, ids []int64) (map[int64]EmailStatus, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	out := make(map[int64]EmailStatus, len(ids))
+	for _, id := range ids {
+		if e, ok := m.email(ctx, id); ok {
+			out[id] = EmailStatus{Status: e.Status, Error: nullString(e.Error), SentAt: nullTime(e.SentAt)}
+		}
+	}
+	return out, nil
+}
+
+func (m *MemStore) ListEmails(ctx context.Context) ([]Email, error) {
+	return m.ListEmailsFiltered(ctx, EmailFilter{})
+}
+
+func (m *MemStore) ListEmailsFiltered(ctx context.Context, f EmailFilter) ([]Email, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	after, hasAfter := m.emails[f.After]
+	before, hasBefore := m.emails[f.Before]
+	var out []Email
+	for _, e := range m.emails {
+		if !f.matches(ctx, e) {
+			continue
+		}
+		// Un cursor que no existe no devuelve nada, como la subconsulta SQL.
+		if f.After > 0 && (!hasAfter || !listedBefore(after, e)) {
+			continue
+		}
+		if f.Before > 0 && (!hasBefore || !listedBefore(e, before)) {
+			continue
+		}
+		out = append(out, e)
+	}
+	sort.Slice(out, func(i, j int) bool { return listedBefore(out[i], out[j]) })
+	if f.reversed() {
+		slices.Reverse(out)
+	}
+	out = out[min(f.Offset, len(out)):]
+	if f.Limit > 0 && len(out) > f.Limit {
+		out = out[:f.Limit]
+	}
+	if f.reversed() {
+		slices.Reverse(out)
+	}
+	return out, nil
+}
+
+// listedBefore indica si a aparece antes que b en el listado
+// (created_at, id descendente).
+func listedBefore(a, b Email) bool {
+	if !a.CreatedAt.Equal(b.CreatedAt) {
+		return a.CreatedAt.After(b.CreatedAt)
+	}
+	return a.ID > b.ID
+}
+
+// matches es el equivalente en memoria de where().
+func (f EmailFilter) matches(ctx context.Context, e Email) bool {
+	if !visible(ctx, e.TenantID) {
+		return false
+	}
+	if f.Status != "" && e.Status != f.Status {
+		return false
+	}
+	if f.CallbackStatus != "" && e.CallbackStatus != f.CallbackStatus {
+		return false
+	}
+	if f.CorrelationID != "" && e.CorrelationID != f.CorrelationID {
+		return false
+	}
+	if f.ConversationID != "" && e.ConversationID != f.ConversationID {
+		return false
+	}
+	if f.Recipient != "" && !strings.EqualFold(e.To, f.Recipient) {
+		return false
+	}
+	if f.Campaign != "" && e.Campaign != f.Campaign {
+		return false
+	}
+	if f.ErrorContains != "" && !strings.Contains(strings.ToLower(e.Error.String), strings.ToLower(f.ErrorContains)) {
+		return false
+	}
+	at, valid := e.CreatedAt, true
+	if f.DateField == "sent_at" {
+		at, valid = e.SentAt.Time, e.SentAt.Valid
+	}
+	if !f.From.IsZero() && (!valid || at.Before(f.From)) {
+		return false
+	}
+	if !f.To.IsZero() && (!valid || at.After(f.To)) {
+		return false
+	}
+	return true
+}
+
+func (m *MemStore) StreamEmails(ctx context.Context, f EmailFilter, fn func(Email) error) error {
+	list, err := m.ListEmailsFiltered(ctx, f)
+	if err != nil {
+		return err
+	}
+	for _, e := range list {
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *MemStore) ListEmailsByCorrelationID(ctx context.Context, id string) ([]Email, error) {
+	return m.ListEmailsFiltered(ctx, EmailFilter{CorrelationID: id})
+}
+
+func (m *MemStore) ListByConversation(ctx context.Context, id string) ([]Email, error) {
+	list, err := m.ListEmailsFiltered(ctx, EmailFilter{ConversationID: id})
+	slices.Reverse(list)
+	return list, err
+}
+
+func (m *MemStore) LastInConversation(ctx context.Context, id string) (Email, error) {
+	return lastInConversation(m.ListEmailsFiltered(ctx, EmailFilter{ConversationID: id, Limit: 1}))
+}
+
+func (m *MemStore) ListEmailFields(ctx context.Context, f EmailFilter, fields []string) ([]map[string]any, error) {
+	for _, name := range fields {
+		if _, ok := emailFieldColumns[name]; !ok {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownField, name)
+		}
+	}
+	list, err := m.ListEmailsFiltered(ctx, f)
+	if err != nil {
+		return nil, err
+	}
+
+	var out []map[string]any
+	for _, e := range list {
+		item := make(map[string]any, len(fields))
+		for _, name := range fields {
+			item[name] = e.field(name)
+		}
+		out = append(out, item)
+	}
+	return out, nil
+}
+
+// field devuelve el valor del campo con los mismos tipos que produce la
+// consulta de ListEmailFields en Postgres (nil para NULL).
+func (e Email) field(name string) any {
+	nullTime := func(t sql.NullTime) any {
+		if !t.Valid {
+			return nil
+		}
+		return t.Time
+	}
+	switch name {
+	case "id":
+		return e.ID
+	case "to":
+		return e.To
+	case "cc":
+		return e.Cc
+	case "bcc":
+		return e.Bcc
+	case "subject":
+		return e.Subject
+	case "body":
+		return e.Body
+	case "text_body":
+		return e.TextBody
+	case "status":
+		return e.Status
+	case "error":
+		if !e.Error.Valid {
+			return nil
+		}
+		return e.Error.String
+	case "error_class":
+		return e.ErrorClass
+	case "attempts":
+		return int64(e.Attempts)
+	case "template_id":
+		if !e.TemplateID.Valid {
+			return nil
+		}
+		return e.TemplateID.Int64
+	case "priority":
+		return int64(e.Priority)
+	case "created_at":
+		return e.CreatedAt
+	case "send_at":
+		return nullTime(e.SendAt)
+	case "sent_at":
+		return nullTime(e.SentAt)
+	case "expires_at":
+		return nullTime(e.ExpiresAt)
+	case "callback_url":
+		return e.CallbackURL
+	case "callback_status":
+		return e.CallbackStatus
+	case "warning":
+		return e.Warning
+	case "correlation_id":
+		return e.CorrelationID
+	case "conversation_id":
+		return e.ConversationID
+	case "message_id":
+		return e.MessageID
+	}
+	return nil
+}
+
+// before indica si a se despacha antes que b (prioridad, momento y creación).
+func before(a, b Email) bool {
+	if a.Priority != b.Priority {
+		return a.Priority > b.Priority
+	}
+	if da, db := a.DueAt(), b.DueAt(); !da.Equal(db) {
+		return da.Before(db)
+	}
+	if !a.CreatedAt.Equal(b.CreatedAt) {
+		return a.CreatedAt.Before(b.CreatedAt)
+	}
+	return a.ID < b.ID
+}
+
+func (m *MemStore) QueuePosition(ctx context.Context, id int64) (string, int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.email(ctx, id)
+	if !ok {
+		return "", 0, sql.ErrNoRows
+	}
+	var pos int64
+	for _, q := range m.emails {
+		if q.ID != e.ID && q.Pending() && before(q, e) {
+			pos++
+		}
+	}
+	return e.Status, pos, nil
+}
+
+func (m *MemStore) CountSentSince(ctx context.Context, since time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var n int64
+	for _, e := range m.emails {
+		if e.Status == "sent" && e.SentAt.Valid && !e.SentAt.Time.Before(since) && visible(ctx, e.TenantID) {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (m *MemStore) TemplateSendCounts(ctx context.Context, since time.Time) (map[int64]int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	out := map[int64]int64{}
+	for _, e := range m.emails {
+		if e.Status == "sent" && e.TemplateID.Valid && !e.Test && e.SentAt.Valid && !e.SentAt.Time.Before(since) && visible(ctx, e.TenantID) {
+			out[e.TemplateID.Int64]++
+		}
+	}
+	return out, nil
+}
+
+func (m *MemStore) CountByStatus(ctx context.Context) (map[string]int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	out := map[string]int64{}
+	for _, e := range m.emails {
+		if !e.Test && visible(ctx, e.TenantID) {
+			out[e.Status]++
+		}
+	}
+	return out, nil
+}
+
+func (m *MemStore) SendLatency(ctx context.Context, from, to time.Time) (LatencyStats, error) {
+	m.mu.Lock()
+	var lat []float64
+	for _, e := range m.emails {
+		if e.Status != "sent" || e.Test || !e.SentAt.Valid || e.SentAt.Time.Before(from) || e.SentAt.Time.After(to) || !visible(ctx, e.TenantID) {
+			continue
+		}
+		start := e.CreatedAt
+		if e.SendAt.Valid {
+			start = e.SendAt.Time
+		}
+		lat = append(lat, e.SentAt.Time.Sub(start).Seconds())
+	}
+	m.mu.Unlock()
+
+	sort.Float64s(lat)
+	return LatencyStats{
+		Count: int64(len(lat)),
+		P50:   percentile(lat, 0.50),
+		P90:   percentile(lat, 0.90),
+		P95:   percentile(lat, 0.95),
+		P99:   percentile(lat, 0.99),
+	}, nil
+}
+
+func (m *MemStore) DailyCounts(ctx context.Context, from, to time.Time, status string) ([]DayCount, error) {
+	from, end := dayRange(from, to)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	counts := map[string]int64{}
+	for _, e := range m.emails {
+		if e.Test || e.CreatedAt.Before(from) || !e.CreatedAt.Before(end) || (status != "" && e.Status != status) || !visible(ctx, e.TenantID) {
+			continue
+		}
+		counts[e.CreatedAt.UTC().Format(time.DateOnly)]++
+	}
+	return fillDays(from, end, counts), nil
+}
+
+// percentile interpola linealmente como percentile_cont de Postgres.
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	pos := p * float64(len(sorted)-1)
+	lo := int(math.Floor(pos))
+	hi := int(math.Ceil(pos))
+	return sorted[lo] + (sorted[hi]-sorted[lo])*(pos-float64(lo))
+}
+
+func (m *MemStore) DeleteEmail(ctx context.Context, id int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.email(ctx, id); !ok {
+		return sql.ErrNoRows
+	}
+	m.deleteEmail(id)
+	return nil
+}
+
+func (m *MemStore) deleteEmail(id int64) bool {
+	if _, ok := m.emails[id]; !ok {
+		return false
+	}
+	delete(m.emails, id)
+	delete(m.attachments, id)
+	delete(m.attempts, id)
+	return true
+}
+
+func (m *MemStore) DeleteEmails(ctx context.Context, ids []int64) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var n int64
+	for _, id := range ids {
+		if _, ok := m.email(ctx, id); ok && m.deleteEmail(id) {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (m *MemStore) DeleteByFilter(ctx context.Context, f DeleteFilter) (int64, error) {
+	if f.Status == "" && f.Before.IsZero() {
+		return 0, fmt.Errorf("filtro vacío")
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var n int64
+	for id, e := range m.emails {
+		if !visible(ctx, e.TenantID) || (f.Status != "" && e.Status != f.Status) {
+			continue
+		}
+		if !f.Before.IsZero() && !e.CreatedAt.Before(f.Before) {
+			continue
+		}
+		m.deleteEmail(id)
+		n++
+	}
+	return n, nil
+}
+
+func (m *MemStore) TruncateEmails(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.emails = map[int64]Email{}
+	m.attachments = map[int64][]Attachment{}
+	m.attempts = map[int64][]Attempt{}
+	m.lastEmail, m.lastAttempt = 0, 0
+	return nil
+}
+
+// BackfillCompressedBodies no hace nada: en memoria los cuerpos nunca se
+// comprimen.
+func (m *MemStore) BackfillCompressedBodies(ctx context.Context) (int64, error) {
+	return 0, nil
+}
+
+// ----------------------------------------------------------
+// Cola
+// ----------------------------------------------------------
+
+func (m *MemStore) ClaimDue(ctx context.Context, limit int) ([]Email, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+
+	// Como en Postgres, la cabeza de cola por destinatario se evalúa sobre
+	// el estado previo al reclamo.
+	var candidates []Email
+	for _, e := range m.emails {
+		if m.claimable(e, now) {
+			candidates = append(candidates, e)
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool { return before(candidates[i], candidates[j]) })
+	if len(candidates) > limit {
+		candidates = candidates[:limit]
+	}
+
+	for i := range candidates {
+		candidates[i].Status = "sending"
+		m.emails[candidates[i].ID] = candidates[i]
+	}
+	return candidates, nil
+}
+
+func (m *MemStore) ClaimByIDs(ctx context.Context, ids []int64) ([]Email, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	var out []Email
+	for _, id := range ids {
+		if e, ok := m.emails[id]; ok && m.claimable(e, now) {
+			out = append(out, e)
+		}
+	}
+	for i := range out {
+		out[i].Status = "sending"
+		m.emails[out[i].ID] = out[i]
+	}
+	return out, nil
+}
+
+// claimable indica si e puede reclamarse en now: pendiente, en su momento
+// de envío, sin caducar, fuera de campañas pausadas y sin otro envío en
+// curso ni un pendiente más antiguo para el mismo destinatario.
+func (m *MemStore) claimable(e Email, now time.Time) bool {
+	due := func(e Email) bool {
+		return e.Pending() && !e.DueAt().After(now) && !m.campaigns[campaignKey{e.TenantID, e.Campaign}]
+	}
+	if !due(e) || (e.ExpiresAt.Valid && !e.ExpiresAt.Time.After(now)) {
+		return false
+	}
+	for _, p := range m.emails {
+		if p.ID != e.ID && strings.EqualFold(p.To, e.To) &&
+			(p.Status == "sending" || (due(p) && p.ID < e.ID)) {
+			return false
+		}
+	}
+	return true
+}
+
+func (m *MemStore) PendingDue(ctx context.Context) ([]DueRef, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var out []DueRef
+	for _, e := range m.emails {
+		if e.Pending() {
+			out = append(out, DueRef{ID: e.ID, At: e.DueAt(), Priority: e.Priority})
+		}
+	}
+	return out, nil
+}
+
+func (m *MemStore) ExpireStale(ctx context.Context, now time.Time) ([]Email, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var out []Email
+	for id, e := range m.emails {
+		if !e.Pending() || !e.ExpiresAt.Valid || e.ExpiresAt.Time.After(now) {
+			continue
+		}
+		e.Status = "expired"
+		e.Error = sql.NullString{String: "caducado sin enviar", Valid: true}
+		m.emails[id] = e
+		out = append(out, e)
+	}
+	return out, nil
+}
+
+func (m *MemStore) RequeueClaimed(ctx context.Context, ids []int64) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var n int64
+	for _, id := range ids {
+		if e, ok := m.emails[id]; ok && e.Status == "sending" {
+			e.Status = "queued"
+			if e.Attempts > 0 {
+				e.Status = "retrying"
+			}
+			m.emails[id] = e
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (m *MemStore) DeferClaimed(ctx context.Context, ids []int64, at time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var n int64
+	for _, id := range ids {
+		if e, ok := m.emails[id]; ok && e.Status == "sending" {
+			e.Status = "queued"
+			if e.Attempts > 0 {
+				e.Status = "retrying"
+			}
+			e.NextRetryAt = sql.NullTime{Time: at, Valid: true}
+			m.emails[id] = e
+			n++
+		}
+	}
+	return n, nil
+}
+
+// update aplica fn al correo id si existe.
+func (m *MemStore) update(id int64, fn func(e *Email)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if e, ok := m.emails[id]; ok {
+		fn(&e)
+		m.emails[id] = e
+	}
+}
+
+func (m *MemStore) MarkSent(ctx context.Context, id int64) error {
+	m.update(id, func(e *Email) {
+		e.Status = "sent"
+		e.SentAt = sql.NullTime{Time: time.Now(), Valid: true}
+	})
+	return nil
+}
+
+func (m *MemStore) MarkFailed(ctx context.Context, id int64, msg, class string) error {
+	m.update(id, func(e *Email) {
+		e.Status = "failed"
+		e.Error = sql.NullString{String: msg, Valid: true}
+		e.ErrorClass = class
+		e.Attempts++
+	})
+	return nil
+}
+
+func (m *MemStore) AddDelivered(ctx context.Context, id int64, rcpts []string) error {
+	m.update(id, func(e *Email) {
+		e.DeliveredTo = append(slices.Clone(e.DeliveredTo), rcpts...)
+	})
+	return nil
+}
+
+func (m *MemStore) MarkRetry(ctx context.Context, id int64, msg string, at time.Time) error {
+	m.update(id, func(e *Email) {
+		e.Status = "retrying"
+		e.Error = sql.NullString{String: msg, Valid: true}
+		e.ErrorClass = "transient"
+		e.Attempts++
+		e.NextRetryAt = sql.NullTime{Time: at, Valid: true}
+	})
+	return nil
+}
+
+func (m *MemStore) TransitionStatus(ctx context.Context, id int64, from, to, reason string, a AuditEntry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.email(ctx, id)
+	if !ok || e.Status != from {
+		return sql.ErrNoRows
+	}
+	e.Status = to
+	if to == "queued" {
+		e.NextRetryAt = sql.NullTime{}
+		e.SendAt = sql.NullTime{}
+	} else {
+		e.Error = sql.NullString{String: reason, Valid: true}
+		e.ErrorClass = "manual"
+	}
+	m.emails[id] = e
+
+	m.lastAudit++
+	a.ID = m.lastAudit
+	a.CreatedAt = time.Now()
+	m.audit = append(m.audit, a)
+	return nil
+}
+
+func (m *MemStore) MarkCallback(ctx context.Context, id int64, status string, attempts int, msg string) error {
+	m.update(id, func(e *Email) {
+		e.CallbackStatus = status
+		e.CallbackAttempts = attempts
+		e.CallbackError = sql.NullString{String: msg, Valid: msg != ""}
+	})
+	return nil
+}
+
+// ----------------------------------------------------------
+// Plantillas
+// ----------------------------------------------------------
+
+func (m *MemStore) ListTemplates(ctx context.Context) ([]Template, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	list := make([]Template, 0, len(m.templates))
+	for _, t := range m.templates {
+		if visible(ctx, t.TenantID) {
+			list = append(list, t)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].CreatedAt.Equal(list[j].CreatedAt) {
+			return list[i].CreatedAt.After(list[j].CreatedAt)
+		}
+		return list[i].ID > list[j].ID
+	})
+	return list, nil
+}
+
+func (m *MemStore) GetTemplate(ctx context.Context, id int64) (Template, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t, ok := m.template(ctx, id)
+	if !ok {
+		return Template{}, sql.ErrNoRows
+	}
+	return t, nil
+}
+
+// template devuelve la plantilla id si existe y es visible con ctx.
+func (m *MemStore) template(ctx context.Context, id int64) (Template, bool) {
+	t, ok := m.templates[id]
+	return t, ok && visible(ctx, t.TenantID)
+}
+
+func (m *MemStore) GetTemplateByName(ctx context.Context, name string) (Template, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Como en Postgres: la variante sin idioma o, si no hay, la primera por
+	// orden de idioma.
+	var found Template
+	ok := false
+	for _, t := range m.templates {
+		if t.Name != name || !visible(ctx, t.TenantID) {
+			continue
+		}
+		if !ok || t.Locale < found.Locale {
+			found, ok = t, true
+		}
+	}
+	if !ok {
+		return Template{}, sql.ErrNoRows
+	}
+	return found, nil
+}
+
+func (m *MemStore) GetTemplateByNameLocale(ctx context.Context, name, locale string) (Template, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, l := range LocaleChain(locale) {
+		if t, ok := m.templateByName(ctx, name, l); ok {
+			return t, nil
+		}
+	}
+	return Template{}, sql.ErrNoRows
+}
+
+// templateByName devuelve la plantilla visible con ctx con ese nombre e
+// idioma.
+func (m *MemStore) templateByName(ctx context.Context, name, locale string) (Template, bool) {
+	var found Template
+	ok := false
+	for _, t := range m.templates {
+		if t.Name != name || t.Locale != locale || !visible(ctx, t.TenantID) {
+			continue
+		}
+		if !ok || t.UpdatedAt.After(found.UpdatedAt) || (t.UpdatedAt.Equal(found.UpdatedAt) && t.ID > found.ID) {
+			found, ok = t, true
+		}
+	}
+	return found, ok
+}
+
+func (m *MemStore) InsertTemplate(ctx context.Context, t Template) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t.TenantID = tenantFor(ctx, t.TenantID)
+	if _, ok := m.templateByName(WithTenant(ctx, t.TenantID), t.Name, t.Locale); ok {
+		return 0, ErrDuplicateTemplateName
+	}
+	return m.insertTemplate(t), nil
+}
+
+func (m *MemStore) insertTemplate(t Template) int64 {
+	m.lastTemplate++
+	t.ID = m.lastTemplate
+	t.CreatedAt = time.Now()
+	t.UpdatedAt = t.CreatedAt
+	m.templates[t.ID] = t
+	return t.ID
+}
+
+func (m *MemStore) UpdateTemplate(ctx context.Context, t Template) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	old, ok := m.template(ctx, t.ID)
+	if !ok {
+		return sql.ErrNoRows
+	}
+	t.TenantID = old.TenantID
+	if other, ok := m.templateByName(WithTenant(ctx, t.TenantID), t.Name, t.Locale); ok && other.ID != t.ID {
+		return ErrDuplicateTemplateName
+	}
+	t.CreatedAt = old.CreatedAt
+	t.UpdatedAt = time.Now()
+	m.templates[t.ID] = t
+	return nil
+}
+
+func (m *MemStore) DeleteTemplate(ctx context.Context, id int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.template(ctx, id); !ok {
+		return sql.ErrNoRows
+	}
+	delete(m.templates, id)
+	m.versions = slices.DeleteFunc(m.versions, func(v TemplateVersion) bool { return v.TemplateID == id })
+	return nil
+}
+
+func (m *MemStore) UpsertTemplates(ctx context.Context, ts []Template) (created, updated int, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, t := range ts {
+		t.TenantID = tenantFor(ctx, t.TenantID)
+		old, ok := m.templateByName(WithTenant(ctx, t.TenantID), t.Name, t.Locale)
+		if !ok {
+			m.insertTemplate(t)
+			created++
+			continue
+		}
+		t.ID, t.CreatedAt, t.UpdatedAt = old.ID, old.CreatedAt, time.Now()
+		m.templates[t.ID] = t
+		updated++
+	}
+	return created, updated, nil
+}
+
+func (m *MemStore) ReplaceTemplateBodies(ctx context.Context, changes []BodyChange, reason string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, c := range changes {
+		if t, ok := m.template(ctx, c.ID); !ok || t.Body != c.OldBody {
+			return fmt.Errorf("%w: %d", ErrTemplateChanged, c.ID)
+		}
+	}
+	now := time.Now()
+	for _, c := range changes {
+		t := m.templates[c.ID]
+		m.lastVersion++
+		m.versions = append(m.versions, TemplateVersion{
+			ID:         m.lastVersion,
+			TemplateID: t.ID,
+			Name:       t.Name,
+			Subject:    t.Subject,
+			Body:       t.Body,
+			Reason:     reason,
+			CreatedAt:  now,
+		})
+		t.Body, t.UpdatedAt = c.NewBody, now
+		m.templates[c.ID] = t
+	}
+	return nil
+}
+
+func (m *MemStore) ListTemplateVersions(ctx context.Context, templateID int64) ([]TemplateVersion, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.template(ctx, templateID); !ok {
+		return nil, nil
+	}
+	var out []TemplateVersion
+	for i := len(m.versions) - 1; i >= 0; i-- {
+		if m.versions[i].TemplateID == templateID {
+			out = append(out, m.versions[i])
+		}
+	}
+	return out, nil
+}
+
+// ----------------------------------------------------------
+// Envíos recurrentes
+// ----------------------------------------------------------
+
+func (m *MemStore) ListRecurring(ctx context.Context) ([]Recurring, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	list := make([]Recurring, 0, len(m.recurring))
+	for _, rc := range m.recurring {
+		if visible(ctx, rc.TenantID) {
+			list = append(list, rc)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list, nil
+}
+
+func (m *MemStore) GetRecurring(ctx context.Context, id int64) (Recurring, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	rc, ok := m.recurring[id]
+	if !ok || !visible(ctx, rc.TenantID) {
+		return Recurring{}, sql.ErrNoRows
+	}
+	return rc, nil
+}
+
+func (m *MemStore) InsertRecurring(ctx context.Context, rc Recurring) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.lastRecurring++
+	rc.ID = m.lastRecurring
+	rc.TenantID = tenantFor(ctx, rc.TenantID)
+	rc.CreatedAt = time.Now()
+	rc.UpdatedAt = rc.CreatedAt
+	m.recurring[rc.ID] = rc
+	return rc.ID, nil
+}
+
+func (m *MemStore) UpdateRecurring(ctx context.Context, rc Recurring) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	old, ok := m.recurring[rc.ID]
+	if !ok || !visible(ctx, old.TenantID) {
+		return sql.ErrNoRows
+	}
+	rc.CreatedAt, rc.LastRunAt, rc.UpdatedAt = old.CreatedAt, old.LastRunAt, time.Now()
+	rc.TenantID = old.TenantID
+	m.recurring[rc.ID] = rc
+	return nil
+}
+
+func (m *MemStore) SetRecurringActive(ctx context.Context, id int64, active bool, next sql.NullTime) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	rc, ok := m.recurring[id]
+	if !ok || !visible(ctx, rc.TenantID) {
+		return sql.ErrNoRows
+	}
+	rc.Active, rc.NextRunAt, rc.UpdatedAt = active, next, time.Now()
+	m.recurring[id] = rc
+	return nil
+}
+
+func (m *MemStore) DeleteRecurring(ctx context.Context, id int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if rc, ok := m.recurring[id]; !ok || !visible(ctx, rc.TenantID) {
+		return sql.ErrNoRows
+	}
+	delete(m.recurring, id)
+	return nil
+}
+
+func (m *MemStore) DueRecurring(ctx context.Context) ([]Recurring, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	var list []Recurring
+	for _, rc := range m.recurring {
+		if rc.Active && rc.NextRunAt.Valid && !rc.NextRunAt.Time.After(now) {
+			list = append(list, rc)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].NextRunAt.Time.Before(list[j].NextRunAt.Time) })
+	return list, nil
+}
+
+func (m *MemStore) AdvanceRecurring(ctx context.Context, id int64, prev, next time.Time) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	rc, ok := m.recurring[id]
+	if !ok || !rc.Active || !rc.NextRunAt.Valid || !rc.NextRunAt.Time.Equal(prev) {
+		return false, nil
+	}
+	rc.NextRunAt = sql.NullTime{Time: next, Valid: true}
+	rc.LastRunAt = sql.NullTime{Time: time.Now(), Valid: true}
+	m.recurring[id] = rc
+	return true, nil
+}
+
+// ----------------------------------------------------------
+// Campañas
+// ----------------------------------------------------------
+
+// campaignKey identifica una campaña dentro de un inquilino.
+type campaignKey struct{ tenant, name string }
+
+func (m *MemStore) ListCampaigns(ctx context.Context) ([]Campaign, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	byKey := map[campaignKey]*Campaign{}
+	get := func(k campaignKey) *Campaign {
+		c, ok := byKey[k]
+		if !ok {
+			c = &Campaign{Name: k.name, Paused: m.campaigns[k], TenantID: k.tenant}
+			byKey[k] = c
+		}
+		return c
+	}
+	for k := range m.campaigns {
+		if visible(ctx, k.tenant) {
+			get(k)
+		}
+	}
+	for _, e := range m.emails {
+		if e.Campaign == "" || !visible(ctx, e.TenantID) {
+			continue
+		}
+		c := get(campaignKey{e.TenantID, e.Campaign})
+		switch {
+		case e.Pending():
+			c.Queued++
+		case e.Status == "sending":
+			c.Sending++
+		case e.Status == "sent":
+			c.Sent++
+		case e.Status == "failed":
+			c.Failed++
+		}
+	}
+
+	out := make([]Campaign, 0, len(byKey))
+	for _, c := range byKey {
+		out = append(out, *c)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Name != out[j].Name {
+			return out[i].Name < out[j].Name
+		}
+		return out[i].TenantID < out[j].TenantID
+	})
+	return out, nil
+}
+
+func (m *MemStore) GetCampaign(ctx context.Context, name string) (Campaign, error) {
+	all, _ := m.ListCampaigns(ctx)
+	for _, c := range all {
+		if c.Name == name {
+			return c, nil
+		}
+	}
+	return Campaign{}, sql.ErrNoRows
+}
+
+func (m *MemStore) SetCampaignPaused(ctx context.Context, name string, paused bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.campaigns[campaignKey{tenantFor(ctx, ""), name}] = paused
+	return nil
+}
+
+func (m *MemStore) PendingInCampaign(ctx context.Context, name string) ([]DueRef, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var out []DueRef
+	for _, e := range m.emails {
+		if e.Campaign == name && e.Pending() && visible(ctx, e.TenantID) {
+			out = append(out, DueRef{ID: e.ID, At: e.DueAt(), Priority: e.Priority})
+		}
+	}
+	return out, nil
+}
+
+// ----------------------------------------------------------
+// Auditoría
+// ----------------------------------------------------------
+
+func (m *MemStore) ListAudit(ctx context.Context, limit int) ([]AuditEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	out := []AuditEntry{}
+	for i := len(m.audit) - 1; i >= 0 && len(out) < limit; i-- {
+		out = append(out, m.audit[i])
+	}
+	return out, nil
+}
+
+// ----------------------------------------------------------
+// Destinatarios
+// ----------------------------------------------------------
+
+// recipientKey identifica el historial de una dirección en un inquilino.
+type recipientKey struct{ tenant, addr string }
+
+func (m *MemStore) RecordBounce(ctx context.Context, addr, msg string, threshold int) (Recipient, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	k := recipientKey{tenantFor(ctx, ""), strings.ToLower(addr)}
+	rc := m.recipients[k]
+	now := time.Now()
+	rc.Address, rc.TenantID = k.addr, k.tenant
+	rc.Bounces++
+	rc.LastBounceAt = sql.NullTime{Time: now, Valid: true}
+	rc.LastError = msg
+	if !rc.SuppressedAt.Valid && threshold > 0 && rc.Bounces >= threshold {
+		rc.SuppressedAt = sql.NullTime{Time: now, Valid: true}
+	}
+	m.recipients[k] = rc
+	return rc, nil
+}
+
+func (m *MemStore) GetRecipient(ctx context.Context, addr string) (Recipient, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	addr = strings.ToLower(addr)
+	var found Recipient
+	ok := false
+	for k, rc := range m.recipients {
+		if k.addr == addr && visible(ctx, k.tenant) && (!ok || rc.LastBounceAt.Time.After(found.LastBounceAt.Time)) {
+			found, ok = rc, true
+		}
+	}
+	if !ok {
+		return Recipient{}, sql.ErrNoRows
+	}
+	return found, nil
+}
+
+func (m *MemStore) ListRecipients(ctx context.Context, suppressedOnly bool) ([]Recipient, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	out := []Recipient{}
+	for k, rc := range m.recipients {
+		if !visible(ctx, k.tenant) || suppressedOnly && !rc.SuppressedAt.Valid {
+			continue
+		}
+		out = append(out, rc)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if a, b := out[i].LastBounceAt.Time, out[j].LastBounceAt.Time; !a.Equal(b) {
+			return a.After(b)
+		}
+		return out[i].Address < out[j].Address
+	})
+	return out, nil
+}
+
+func (m *MemStore) Suppressed(ctx context.Context, addrs []string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	suppressed := map[string]bool{}
+	for k, rc := range m.recipients {
+		if rc.SuppressedAt.Valid && visible(ctx, k.tenant) {
+			suppressed[k.addr] = true
+		}
+	}
+	var out []string
+	for _, a := range addrs {
+		a = strings.ToLower(a)
+		if suppressed[a] {
+			out = append(out, a)
+			delete(suppressed, a)
+		}
+	}
+	return out, nil
+}
+
+func (m *MemStore) ResetRecipient(ctx context.Context, addr string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	addr = strings.ToLower(addr)
+	found := false
+	for k := range m.recipients {
+		if k.addr == addr && visible(ctx, k.tenant) {
+			delete(m.recipients, k)
+			found = true
+		}
+	}
+	if !found {
+		return sql.ErrNoRows
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// Los rebotes y la supresión de una dirección solo son visibles para el
+// inquilino que la envió; sin inquilino en el contexto se ven todos.
+func TestRecipientsTenantScoped(t *testing.T) {
+	for name, r := range testRepositories(t) {
+		t.Run(name, func(t *testing.T) {
+			const addr = "rebota-inquilino@example.com"
+			all := AllTenants(context.Background())
+			a := WithTenant(context.Background(), "a")
+			b := WithTenant(context.Background(), "b")
+			t.Cleanup(func() { r.ResetRecipient(all, addr) })
+
+			if _, err := r.RecordBounce(a, addr, "550 no existe", 1); err != nil {
+				t.Fatal(err)
+			}
+
+			if rc, err := r.GetRecipient(a, addr); err != nil || !rc.SuppressedAt.Valid || rc.TenantID != "a" {
+				t.Errorf("inquilino a: %+v, %v; se esperaba la dirección suprimida", rc, err)
+			}
+			if _, err := r.GetRecipient(b, addr); !errors.Is(err, sql.ErrNoRows) {
+				t.Errorf("inquilino b ve el historial de a: %v", err)
+			}
+			if list, err := r.ListRecipients(b, false); err != nil || len(list) > 0 {
+				t.Errorf("inquilino b lista %v, %v; se esperaba vacío", list, err)
+			}
+			if got, err := r.Suppressed(b, []string{addr}); err != nil || len(got) > 0 {
+				t.Errorf("inquilino b tiene suprimidas %v, %v", got, err)
+			}
+			if err := r.ResetRecipient(b, addr); !errors.Is(err, sql.ErrNoRows) {
+				t.Errorf("inquilino b borró el historial de a: %v", err)
+			}
+
+			got, err := r.Suppressed(all, []string{addr})
+			if err != nil || fmt.Sprint(got) != fmt.Sprint([]string{addr}) {
+				t.Errorf("sin inquilino: suprimidas %v, %v; se esperaba [%s]", got, err, addr)
+			}
+		})
+	}
+}
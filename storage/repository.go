@@ -0,0 +1,99 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// Repository es el acceso a datos que usan los handlers, el worker, el
+// scheduler y los callbacks. Store lo implementa sobre Postgres y MemStore
+// en memoria.
+type Repository interface {
+	// Esquema
+	Init(ctx context.Context) error
+	AppliedSchemaVersion(ctx context.Context) (int, error)
+
+	// Correos
+	InsertEmail(ctx context.Context, e Email) (int64, error)
+	Attachments(ctx context.Context, emailID int64) ([]Attachment, error)
+	RecordAttempt(ctx context.Context, a Attempt) error
+	ListAttempts(ctx context.Context, emailID int64) ([]Attempt, error)
+	GetEmail(ctx context.Context, id int64) (Email, error)
+	EmailStatuses(ctx context.Context, ids []int64) (map[int64]EmailStatus, error)
+	ListEmails(ctx context.Context) ([]Email, error)
+	ListEmailsFiltered(ctx context.Context, f EmailFilter) ([]Email, error)
+	StreamEmails(ctx context.Context, f EmailFilter, fn func(Email) error) error
+	ListEmailsByCorrelationID(ctx context.Context, id string) ([]Email, error)
+	ListByConversation(ctx context.Context, id string) ([]Email, error)
+	LastInConversation(ctx context.Context, id string) (Email, error)
+	ListEmailFields(ctx context.Context, f EmailFilter, fields []string) ([]map[string]any, error)
+	QueuePosition(ctx context.Context, id int64) (string, int64, error)
+	CountByStatus(ctx context.Context) (map[string]int64, error)
+	TemplateSendCounts(ctx context.Context, since time.Time) (map[int64]int64, error)
+	CountSentSince(ctx context.Context, since time.Time) (int64, error)
+	SendLatency(ctx context.Context, from, to time.Time) (LatencyStats, error)
+	DailyCounts(ctx context.Context, from, to time.Time, status string) ([]DayCount, error)
+	DeleteEmail(ctx context.Context, id int64) error
+	DeleteEmails(ctx context.Context, ids []int64) (int64, error)
+	DeleteByFilter(ctx context.Context, f DeleteFilter) (int64, error)
+	TruncateEmails(ctx context.Context) error
+	BackfillCompressedBodies(ctx context.Context) (int64, error)
+
+	// Cola
+	ClaimDue(ctx context.Context, limit int) ([]Email, error)
+	ClaimByIDs(ctx context.Context, ids []int64) ([]Email, error)
+	PendingDue(ctx context.Context) ([]DueRef, error)
+	ExpireStale(ctx context.Context, now time.Time) ([]Email, error)
+	RequeueClaimed(ctx context.Context, ids []int64) (int64, error)
+	DeferClaimed(ctx context.Context, ids []int64, at time.Time) (int64, error)
+	MarkSent(ctx context.Context, id int64) error
+	MarkFailed(ctx context.Context, id int64, msg, class string) error
+	MarkRetry(ctx context.Context, id int64, msg string, at time.Time) error
+	AddDelivered(ctx context.Context, id int64, rcpts []string) error
+	MarkCallback(ctx context.Context, id int64, status string, attempts int, msg string) error
+	TransitionStatus(ctx context.Context, id int64, from, to, reason string, a AuditEntry) error
+
+	// Plantillas
+	ListTemplates(ctx context.Context) ([]Template, error)
+	GetTemplate(ctx context.Context, id int64) (Template, error)
+	GetTemplateByName(ctx context.Context, name string) (Template, error)
+	GetTemplateByNameLocale(ctx context.Context, name, locale string) (Template, error)
+	InsertTemplate(ctx context.Context, t Template) (int64, error)
+	UpdateTemplate(ctx context.Context, t Template) error
+	DeleteTemplate(ctx context.Context, id int64) error
+	UpsertTemplates(ctx context.Context, ts []Template) (created, updated int, err error)
+	ReplaceTemplateBodies(ctx context.Context, changes []BodyChange, reason string) error
+	ListTemplateVersions(ctx context.Context, templateID int64) ([]TemplateVersion, error)
+
+	// Campañas
+	ListCampaigns(ctx context.Context) ([]Campaign, error)
+	GetCampaign(ctx context.Context, name string) (Campaign, error)
+	SetCampaignPaused(ctx context.Context, name string, paused bool) error
+	PendingInCampaign(ctx context.Context, name string) ([]DueRef, error)
+
+	// Auditoría
+	ListAudit(ctx context.Context, limit int) ([]AuditEntry, error)
+
+	// Destinatarios (rebotes y supresión)
+	RecordBounce(ctx context.Context, addr, msg string, threshold int) (Recipient, error)
+	GetRecipient(ctx context.Context, addr string) (Recipient, error)
+	ListRecipients(ctx context.Context, suppressedOnly bool) ([]Recipient, error)
+	Suppressed(ctx context.Context, addrs []string) ([]string, error)
+	ResetRecipient(ctx context.Context, addr string) error
+
+	// Envíos recurrentes
+	ListRecurring(ctx context.Context) ([]Recurring, error)
+	GetRecurring(ctx context.Context, id int64) (Recurring, error)
+	InsertRecurring(ctx context.Context, rc Recurring) (int64, error)
+	UpdateRecurring(ctx context.Context, rc Recurring) error
+	SetRecurringActive(ctx context.Context, id int64, active bool, next sql.NullTime) error
+	DeleteRecurring(ctx context.Context, id int64) error
+	DueRecurring(ctx context.Context) ([]Recurring, error)
+	AdvanceRecurring(ctx context.Context, id int64, prev, next time.Time) (bool, error)
+}
+
+var (
+	_ Repository = (*Store)(nil)
+	_ Repository = (*MemStore)(nil)
+)
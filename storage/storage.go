@@ -3,13 +3,36 @@ package storage
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/jackc/pgx/v5/pgconn"
 	_ "github.com/jackc/pgx/v5/stdlib"
 )
 
 type Store struct{ DB *sql.DB }
 
+// MemoryDSN selecciona el almacén en memoria en lugar de Postgres.
+const MemoryDSN = "memory://"
+
+// OpenRepository abre el almacén indicado por dsn: MemStore para
+// "memory://" (sin persistencia, para demos y pruebas) o Postgres en otro caso.
+func OpenRepository(dsn string) (Repository, error) {
+	if dsn == MemoryDSN {
+		return NewMemStore(), nil
+	}
+	return Open(dsn)
+}
+
+// Open prepara el pool de conexiones sin conectar: la base de datos puede
+// no estar disponible todavía. Init comprueba la conexión y migra.
 func Open(dsn string) (*Store, error) {
 	db, err := sql.Open("pgx", dsn)
 	if err != nil {
@@ -18,160 +41,2176 @@ func Open(dsn string) (*Store, error) {
 	db.SetMaxOpenConns(10)
 	db.SetMaxIdleConns(5)
 	db.SetConnMaxLifetime(30 * time.Minute)
+	return &Store{DB: db}, nil
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	if err := db.PingContext(ctx); err != nil {
-		return nil, err
+// Init comprueba la conexión y, salvo con AUTO_MIGRATE=false, aplica las
+// migraciones pendientes. Puede reintentarse hasta que la base de datos
+// responda.
+func (s *Store) Init(ctx context.Context) error {
+	if err := s.DB.PingContext(ctx); err != nil {
+		return err
 	}
-
-	s := &Store{DB: db}
-	if err := s.migrate(ctx); err != nil {
-		return nil, err
+	if getEnv("AUTO_MIGRATE", "true") == "true" {
+		return s.migrate(ctx)
 	}
-	return s, nil
+	return nil
 }
 
 // ==========================================================
-// MIGRACIÓN INICIAL
+// MIGRACIONES
 // ==========================================================
+
+// migrations es la lista ordenada de migraciones: la versión N del esquema
+// es la que tiene aplicadas las N primeras. Solo se añaden al final y cada
+// una es idempotente, ya que las bases anteriores a schema_migrations las
+// vuelven a ejecutar desde la versión 0.
+var migrations = []string{
+	`CREATE TABLE IF NOT EXISTS emails (
+		id BIGSERIAL PRIMARY KEY,
+		to_addr TEXT NOT NULL,
+		subject TEXT NOT NULL,
+		body TEXT NOT NULL,
+		status TEXT NOT NULL,
+		error TEXT,
+		created_at TIMESTAMPTZ DEFAULT NOW(),
+		sent_at TIMESTAMPTZ
+	);`,
+	`CREATE TABLE IF NOT EXISTS templates (
+		id BIGSERIAL PRIMARY KEY,
+		name TEXT NOT NULL,
+		subject TEXT NOT NULL,
+		body TEXT NOT NULL,
+		created_at TIMESTAMPTZ DEFAULT NOW(),
+		updated_at TIMESTAMPTZ DEFAULT NOW()
+	);`,
+	`ALTER TABLE templates ADD COLUMN IF NOT EXISTS cc TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE templates ADD COLUMN IF NOT EXISTS bcc TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE templates ADD COLUMN IF NOT EXISTS delims TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE emails ADD COLUMN IF NOT EXISTS cc TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE emails ADD COLUMN IF NOT EXISTS bcc TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE emails ADD COLUMN IF NOT EXISTS template_id BIGINT`,
+	`ALTER TABLE emails ADD COLUMN IF NOT EXISTS claimed_at TIMESTAMPTZ`,
+	`ALTER TABLE emails ADD COLUMN IF NOT EXISTS text_body TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE emails ADD COLUMN IF NOT EXISTS callback_url TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE emails ADD COLUMN IF NOT EXISTS callback_status TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE emails ADD COLUMN IF NOT EXISTS callback_attempts INT NOT NULL DEFAULT 0`,
+	`ALTER TABLE emails ADD COLUMN IF NOT EXISTS callback_error TEXT`,
+	`ALTER TABLE emails ADD COLUMN IF NOT EXISTS callback_at TIMESTAMPTZ`,
+	`ALTER TABLE emails ADD COLUMN IF NOT EXISTS priority INT NOT NULL DEFAULT 0`,
+	`ALTER TABLE emails ADD COLUMN IF NOT EXISTS send_at TIMESTAMPTZ`,
+	`ALTER TABLE emails ADD COLUMN IF NOT EXISTS date_header TIMESTAMPTZ`,
+	`ALTER TABLE emails ADD COLUMN IF NOT EXISTS subject_truncated BOOLEAN NOT NULL DEFAULT false`,
+	`ALTER TABLE templates ADD COLUMN IF NOT EXISTS subject_max_len INT NOT NULL DEFAULT 0`,
+	`ALTER TABLE emails ADD COLUMN IF NOT EXISTS from_addr TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE emails ADD COLUMN IF NOT EXISTS reply_to TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE emails ADD COLUMN IF NOT EXISTS return_path TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE emails ADD COLUMN IF NOT EXISTS request_dsn BOOLEAN NOT NULL DEFAULT false`,
+	`ALTER TABLE emails ADD COLUMN IF NOT EXISTS attempts INT NOT NULL DEFAULT 0`,
+	`ALTER TABLE emails ADD COLUMN IF NOT EXISTS error_class TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE emails ADD COLUMN IF NOT EXISTS next_retry_at TIMESTAMPTZ`,
+	`ALTER TABLE emails ADD COLUMN IF NOT EXISTS expires_at TIMESTAMPTZ`,
+	`ALTER TABLE emails ADD COLUMN IF NOT EXISTS warning TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE emails ADD COLUMN IF NOT EXISTS correlation_id TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE emails ADD COLUMN IF NOT EXISTS audit_bcc TEXT NOT NULL DEFAULT ''`,
+	`CREATE TABLE IF NOT EXISTS email_attachments (
+		id BIGSERIAL PRIMARY KEY,
+		email_id BIGINT NOT NULL REFERENCES emails(id) ON DELETE CASCADE,
+		filename TEXT NOT NULL,
+		content_type TEXT NOT NULL,
+		content BYTEA NOT NULL
+	);`,
+	`CREATE INDEX IF NOT EXISTS email_attachments_email_idx ON email_attachments (email_id)`,
+	`CREATE TABLE IF NOT EXISTS recurring (
+		id BIGSERIAL PRIMARY KEY,
+		name TEXT NOT NULL DEFAULT '',
+		cron_expr TEXT NOT NULL,
+		template_id BIGINT NOT NULL,
+		recipients TEXT NOT NULL,
+		variables JSONB NOT NULL DEFAULT '{}',
+		active BOOLEAN NOT NULL DEFAULT true,
+		next_run_at TIMESTAMPTZ,
+		last_run_at TIMESTAMPTZ,
+		created_at TIMESTAMPTZ DEFAULT NOW(),
+		updated_at TIMESTAMPTZ DEFAULT NOW()
+	);`,
+	`DROP INDEX IF EXISTS emails_dispatch_idx`,
+	`CREATE INDEX IF NOT EXISTS emails_due_idx ON emails (priority DESC, (COALESCE(next_retry_at, send_at, created_at)), created_at)
+	 WHERE status IN ('queued', 'scheduled')`,
+	`CREATE INDEX IF NOT EXISTS emails_recipient_status_idx ON emails (lower(to_addr), status, id)`,
+	`CREATE INDEX IF NOT EXISTS emails_correlation_idx ON emails (correlation_id) WHERE correlation_id <> ''`,
+	`CREATE INDEX IF NOT EXISTS emails_created_idx ON emails (created_at DESC, id DESC)`,
+	// Antes del índice único se renombran los duplicados previos,
+	// conservando el nombre en la versión más reciente.
+	`UPDATE templates t SET name = t.name || ' (' || t.id || ')'
+	 WHERE NOT EXISTS (SELECT 1 FROM pg_indexes WHERE indexname = 'templates_name_key')
+	   AND EXISTS (SELECT 1 FROM templates o WHERE o.name = t.name
+	       AND (o.updated_at > t.updated_at OR (o.updated_at = t.updated_at AND o.id > t.id)))`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS templates_name_key ON templates (name)`,
+	`ALTER TABLE templates ADD COLUMN IF NOT EXISTS variables_schema JSONB NOT NULL DEFAULT '[]'`,
+	`CREATE INDEX IF NOT EXISTS emails_sent_at_idx ON emails (sent_at) WHERE status = 'sent'`,
+	`ALTER TABLE emails ADD COLUMN IF NOT EXISTS conversation_id TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE emails ADD COLUMN IF NOT EXISTS message_id TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE emails ADD COLUMN IF NOT EXISTS in_reply_to TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE emails ADD COLUMN IF NOT EXISTS refs TEXT NOT NULL DEFAULT ''`,
+	`CREATE INDEX IF NOT EXISTS emails_conversation_idx ON emails (conversation_id, created_at DESC, id DESC) WHERE conversation_id <> ''`,
+	`CREATE TABLE IF NOT EXISTS recipients (
+		address TEXT PRIMARY KEY,
+		bounces INT NOT NULL DEFAULT 0,
+		last_bounce_at TIMESTAMPTZ,
+		last_error TEXT NOT NULL DEFAULT '',
+		suppressed_at TIMESTAMPTZ
+	);`,
+	`ALTER TABLE emails ADD COLUMN IF NOT EXISTS bulk BOOLEAN NOT NULL DEFAULT false`,
+	`ALTER TABLE emails ADD COLUMN IF NOT EXISTS list_id TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE templates ADD COLUMN IF NOT EXISTS bulk BOOLEAN NOT NULL DEFAULT false`,
+	`ALTER TABLE templates ADD COLUMN IF NOT EXISTS list_id TEXT NOT NULL DEFAULT ''`,
+	// Índice trigram para error_contains; sin permiso para pg_trgm la
+	// búsqueda funciona igual, recorriendo la tabla.
+	`DO $$
+	BEGIN
+		CREATE EXTENSION IF NOT EXISTS pg_trgm;
+		CREATE INDEX IF NOT EXISTS emails_error_trgm_idx ON emails USING gin (error gin_trgm_ops);
+	EXCEPTION WHEN OTHERS THEN
+		RAISE NOTICE 'pg_trgm no disponible: error_contains sin índice';
+	END $$`,
+	`ALTER TABLE emails ADD COLUMN IF NOT EXISTS headers JSONB NOT NULL DEFAULT '{}'`,
+	`UPDATE emails SET status='retrying' WHERE status='queued' AND next_retry_at IS NOT NULL`,
+	`DROP INDEX IF EXISTS emails_due_idx`,
+	`CREATE INDEX IF NOT EXISTS emails_due_idx ON emails (priority DESC, (COALESCE(next_retry_at, send_at, created_at)), created_at)
+	 WHERE status IN ('queued', 'scheduled', 'retrying')`,
+	`CREATE TABLE IF NOT EXISTS template_versions (
+		id BIGSERIAL PRIMARY KEY,
+		template_id BIGINT NOT NULL REFERENCES templates(id) ON DELETE CASCADE,
+		name TEXT NOT NULL,
+		subject TEXT NOT NULL,
+		body TEXT NOT NULL,
+		reason TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	);`,
+	`CREATE INDEX IF NOT EXISTS template_versions_template_idx ON template_versions (template_id, id DESC)`,
+	`ALTER TABLE emails ADD COLUMN IF NOT EXISTS timeout_seconds INT NOT NULL DEFAULT 0`,
+	// Un mismo nombre puede repetirse con distinto idioma.
+	`ALTER TABLE templates ADD COLUMN IF NOT EXISTS locale TEXT NOT NULL DEFAULT ''`,
+	`DROP INDEX IF EXISTS templates_name_key`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS templates_name_locale_key ON templates (name, locale)`,
+	`CREATE TABLE IF NOT EXISTS email_attempts (
+		id BIGSERIAL PRIMARY KEY,
+		email_id BIGINT NOT NULL REFERENCES emails(id) ON DELETE CASCADE,
+		attempted_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		provider TEXT NOT NULL DEFAULT '',
+		code INT NOT NULL DEFAULT 0,
+		error TEXT NOT NULL DEFAULT '',
+		duration_ms BIGINT NOT NULL DEFAULT 0
+	);`,
+	`CREATE INDEX IF NOT EXISTS email_attempts_email_idx ON email_attempts (email_id, id)`,
+	`ALTER TABLE emails ADD COLUMN IF NOT EXISTS test BOOLEAN NOT NULL DEFAULT false`,
+	`ALTER TABLE emails ADD COLUMN IF NOT EXISTS campaign TEXT NOT NULL DEFAULT ''`,
+	`CREATE INDEX IF NOT EXISTS emails_campaign_idx ON emails (campaign, status) WHERE campaign <> ''`,
+	`CREATE TABLE IF NOT EXISTS campaigns (
+		name TEXT PRIMARY KEY,
+		paused BOOLEAN NOT NULL DEFAULT false,
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	);`,
+	`CREATE TABLE IF NOT EXISTS audit_log (
+		id BIGSERIAL PRIMARY KEY,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		action TEXT NOT NULL,
+		email_id BIGINT,
+		detail TEXT NOT NULL DEFAULT '',
+		request_id TEXT NOT NULL DEFAULT ''
+	);`,
+	`ALTER TABLE emails ADD COLUMN IF NOT EXISTS body_compressed BYTEA`,
+	`ALTER TABLE emails ADD COLUMN IF NOT EXISTS compressed BOOLEAN NOT NULL DEFAULT false`,
+	`ALTER TABLE email_attempts ADD COLUMN IF NOT EXISTS transcript JSONB NOT NULL DEFAULT '[]'`,
+	`ALTER TABLE emails ADD COLUMN IF NOT EXISTS content_type TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE emails ADD COLUMN IF NOT EXISTS tenant_id TEXT NOT NULL DEFAULT ''`,
+	`CREATE INDEX IF NOT EXISTS emails_tenant_idx ON emails (tenant_id, created_at)`,
+	`ALTER TABLE templates ADD COLUMN IF NOT EXISTS tenant_id TEXT NOT NULL DEFAULT ''`,
+	`DROP INDEX IF EXISTS templates_name_locale_key`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS templates_tenant_name_locale_key ON templates (tenant_id, name, locale)`,
+	`ALTER TABLE templates ADD COLUMN IF NOT EXISTS max_per_minute INT NOT NULL DEFAULT 0`,
+	`CREATE INDEX IF NOT EXISTS emails_template_sent_idx ON emails (template_id, sent_at) WHERE status = 'sent'`,
+	`ALTER TABLE emails ADD COLUMN IF NOT EXISTS delivered_to TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE recipients ADD COLUMN IF NOT EXISTS tenant_id TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE recipients DROP CONSTRAINT IF EXISTS recipients_pkey`,
+	`ALTER TABLE recipients ADD PRIMARY KEY (tenant_id, address)`,
+	`ALTER TABLE recurring ADD COLUMN IF NOT EXISTS tenant_id TEXT NOT NULL DEFAULT ''`,
+	`UPDATE recurring SET tenant_id = templates.tenant_id FROM templates WHERE templates.id = recurring.template_id`,
+	`ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS tenant_id TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE campaigns DROP CONSTRAINT IF EXISTS campaigns_pkey`,
+	`ALTER TABLE campaigns ADD PRIMARY KEY (tenant_id, name)`,
+}
+
+// SchemaVersion es la versión de esquema que espera este binario.
+var SchemaVersion = len(migrations)
+
+// migrationLock es la clave del advisory lock que serializa las migraciones
+// entre instancias que arrancan a la vez.
+const migrationLock = 7248103
+
+// migrate aplica las migraciones pendientes, cada una en su transacción
+// junto con el registro de su versión.
 func (s *Store) migrate(ctx context.Context) error {
-	stmts := []string{
-		`CREATE TABLE IF NOT EXISTS emails (
-			id BIGSERIAL PRIMARY KEY,
-			to_addr TEXT NOT NULL,
-			subject TEXT NOT NULL,
-			body TEXT NOT NULL,
-			status TEXT NOT NULL,
-			error TEXT,
-			created_at TIMESTAMPTZ DEFAULT NOW(),
-			sent_at TIMESTAMPTZ
-		);`,
-		`CREATE TABLE IF NOT EXISTS templates (
-			id BIGSERIAL PRIMARY KEY,
-			name TEXT NOT NULL,
-			subject TEXT NOT NULL,
-			body TEXT NOT NULL,
-			created_at TIMESTAMPTZ DEFAULT NOW(),
-			updated_at TIMESTAMPTZ DEFAULT NOW()
-		);`,
-	}
-	for _, q := range stmts {
-		if _, err := s.DB.ExecContext(ctx, q); err != nil {
+	conn, err := s.DB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, migrationLock); err != nil {
+		return err
+	}
+	defer conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, migrationLock)
+
+	if _, err := conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+			version INT PRIMARY KEY,
+			applied_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		)`); err != nil {
+		return err
+	}
+	var current int
+	if err := conn.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&current); err != nil {
+		return err
+	}
+
+	for i := current; i < len(migrations); i++ {
+		tx, err := conn.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, migrations[i]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migración %d: %w", i+1, err)
+		}
+		if _, err := tx.ExecContext(ctx, `INSERT INTO schema_migrations (version) VALUES ($1)`, i+1); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
+// AppliedSchemaVersion devuelve la última migración aplicada en la base de
+// datos (0 si aún no hay ninguna).
+func (s *Store) AppliedSchemaVersion(ctx context.Context) (int, error) {
+	var v int
+	err := s.DB.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&v)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "42P01" {
+		return 0, nil
+	}
+	return v, err
+}
+
 // ==========================================================
 // EMAILS CRUD
 // ==========================================================
 type Email struct {
-	ID        int64
-	To        string
-	Subject   string
-	Body      string
-	Status    string
-	Error     sql.NullString
-	CreatedAt time.Time
-	SentAt    sql.NullTime
+	ID         int64          `json:"id"`
+	From       string         `json:"from,omitempty"`
+	ReplyTo    string         `json:"reply_to,omitempty"`
+	ReturnPath string         `json:"return_path,omitempty"`
+	To         string         `json:"to"`
+	Cc         []string       `json:"cc,omitempty"`
+	Bcc        []string       `json:"bcc,omitempty"`
+	Subject    string         `json:"subject"`
+	Body       string         `json:"body"`
+	TextBody   string         `json:"text_body,omitempty"`
+	Status     string         `json:"status"`
+	Error      sql.NullString `json:"error"`
+	TemplateID sql.NullInt64  `json:"template_id"`
+	CreatedAt  time.Time      `json:"created_at"`
+	SentAt     sql.NullTime   `json:"sent_at"`
+
+	// Priority mayor se despacha antes; SendAt programa el envío.
+	Priority int          `json:"priority"`
+	SendAt   sql.NullTime `json:"send_at"`
+	// ExpiresAt descarta el correo (estado expired) si no se envió antes.
+	ExpiresAt sql.NullTime `json:"expires_at"`
+	// Warning guarda avisos no fatales del envío (p. ej. un PDF no generado).
+	Warning string `json:"warning,omitempty"`
+	// CorrelationID es el X-Request-ID de la petición que creó el correo.
+	CorrelationID string `json:"correlation_id,omitempty"`
+	// AuditBcc es la copia de auditoría (GLOBAL_BCC) añadida al sobre, o
+	// vacío si el correo no la lleva.
+	AuditBcc string `json:"audit_bcc,omitempty"`
+	// ConversationID agrupa los correos de un mismo hilo. MessageID es la
+	// cabecera Message-ID del correo, e InReplyTo y References lo enlazan
+	// con los anteriores del hilo.
+	ConversationID string   `json:"conversation_id,omitempty"`
+	MessageID      string   `json:"message_id,omitempty"`
+	InReplyTo      string   `json:"in_reply_to,omitempty"`
+	References     []string `json:"references,omitempty"`
+	// Bulk marca un envío masivo (Precedence: bulk, Auto-Submitted) y
+	// ListID es su List-Id.
+	Bulk   bool   `json:"bulk,omitempty"`
+	ListID string `json:"list_id,omitempty"`
+	// Headers son las cabeceras X-* personalizadas (ver mailer.CheckHeaders).
+	Headers map[string]string `json:"headers,omitempty"`
+	// TimeoutSeconds sustituye el timeout SMTP de este envío; 0 = por defecto.
+	TimeoutSeconds int `json:"timeout_seconds,omitempty"`
+	// Test marca los envíos de prueba de POST /templates/{id}/test, que no
+	// cuentan en las estadísticas.
+	Test bool `json:"test,omitempty"`
+	// Campaign agrupa el correo en una campaña que puede pausarse (ver
+	// SetCampaignPaused).
+	Campaign string `json:"campaign,omitempty"`
+	// ContentType es "text/plain" si el cuerpo se envía como texto plano;
+	// vacío o "text/html" para el multipart/alternative habitual.
+	ContentType string `json:"content_type,omitempty"`
+	// TenantID es el inquilino dueño del correo (ver WithTenant).
+	TenantID string `json:"tenant_id,omitempty"`
+	// Attachments solo se usa al insertar; para leerlos, ver Attachments.
+	Attachments []Attachment `json:"-"`
+	// DateHeader fija la cabecera Date (solo para pruebas).
+	DateHeader sql.NullTime `json:"date_header"`
+	// SubjectTruncated indica que el asunto renderizado se recortó.
+	SubjectTruncated bool `json:"subject_truncated,omitempty"`
+	// RequestDSN indica que se pidieron acuses de entrega al relay.
+	RequestDSN bool `json:"request_dsn,omitempty"`
+
+	// Attempts cuenta los intentos de envío fallidos; ErrorClass es
+	// "transient" o "permanent" y NextRetryAt el próximo reintento.
+	Attempts    int          `json:"attempts"`
+	ErrorClass  string       `json:"error_class,omitempty"`
+	NextRetryAt sql.NullTime `json:"next_retry_at"`
+	// DeliveredTo son los destinatarios del sobre que ya aceptó un intento
+	// con fallo parcial; los reintentos no se los vuelven a enviar.
+	DeliveredTo []string `json:"delivered_to,omitempty"`
+
+	// Estado de la notificación al callback_url, independiente de Status.
+	CallbackURL      string         `json:"callback_url,omitempty"`
+	CallbackStatus   string         `json:"callback_status,omitempty"`
+	CallbackAttempts int            `json:"callback_attempts,omitempty"`
+	CallbackError    sql.NullString `json:"callback_error"`
+}
+
+// emailColumns es el orden de columnas que espera scanEmail.
+const emailColumns = `id, from_addr, reply_to, return_path, to_addr, cc, bcc, subject, body, body_compressed, compressed, text_body, status, error, template_id, created_at, sent_at,
+	callback_url, callback_status, callback_attempts, callback_error, priority, send_at, date_header, subject_truncated, request_dsn,
+	attempts, error_class, next_retry_at, expires_at, warning, correlation_id, audit_bcc, conversation_id, message_id, in_reply_to, refs,
+	bulk, list_id, headers, timeout_seconds, test, campaign, content_type, tenant_id, delivered_to`
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanEmail(sc scanner) (Email, error) {
+	var e Email
+	var cc, bcc, refs, delivered string
+	var headers, gz []byte
+	var compressed bool
+	err := sc.Scan(&e.ID, &e.From, &e.ReplyTo, &e.ReturnPath, &e.To, &cc, &bcc, &e.Subject, &e.Body, &gz, &compressed, &e.TextBody, &e.Status, &e.Error, &e.TemplateID, &e.CreatedAt, &e.SentAt,
+		&e.CallbackURL, &e.CallbackStatus, &e.CallbackAttempts, &e.CallbackError, &e.Priority, &e.SendAt, &e.DateHeader, &e.SubjectTruncated, &e.RequestDSN,
+		&e.Attempts, &e.ErrorClass, &e.NextRetryAt, &e.ExpiresAt, &e.Warning, &e.CorrelationID, &e.AuditBcc, &e.ConversationID, &e.MessageID, &e.InReplyTo, &refs,
+		&e.Bulk, &e.ListID, &headers, &e.TimeoutSeconds, &e.Test, &e.Campaign, &e.ContentType, &e.TenantID, &delivered)
+	e.Cc, e.Bcc = splitAddrs(cc), splitAddrs(bcc)
+	e.DeliveredTo = splitAddrs(delivered)
+	e.References = strings.Fields(refs)
+	if err == nil && len(headers) > 0 {
+		err = json.Unmarshal(headers, &e.Headers)
+	}
+	if err == nil && compressed {
+		e.Body, err = gunzipBody(gz)
+	}
+	return e, err
+}
+
+func scanEmails(rows *sql.Rows) ([]Email, error) {
+	defer rows.Close()
+
+	var out []Email
+	for rows.Next() {
+		e, err := scanEmail(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, e)
+	}
+	return out, rows.Err()
+}
+
+// ErrBodyTooLarge indica que el cuerpo supera MAX_BODY_BYTES.
+var ErrBodyTooLarge = errors.New("cuerpo demasiado grande")
+
+// MaxBodyBytes devuelve MAX_BODY_BYTES (por defecto 5 MiB), el máximo de
+// body más text_body de un correo.
+func MaxBodyBytes() int {
+	max, err := strconv.Atoi(getEnv("MAX_BODY_BYTES", "5242880"))
+	if err != nil || max <= 0 {
+		return 5 << 20
+	}
+	return max
+}
+
+// checkBodySize rechaza correos cuyo body más text_body supere
+// MaxBodyBytes antes de llegar a la base de datos.
+func checkBodySize(e Email) error {
+	if n, max := len(e.Body)+len(e.TextBody), MaxBodyBytes(); n > max {
+		return fmt.Errorf("%w: %d bytes (máximo %d)", ErrBodyTooLarge, n, max)
+	}
+	return nil
+}
+
+// InsertEmail guarda un correo con el estado indicado en e.Status
+// (queued si viene vacío) junto con sus adjuntos, en una transacción para
+// que el worker nunca reclame un correo sin ellos. Los envíos síncronos se
+// insertan como sending para que el worker no los reclame.
+func (s *Store) InsertEmail(ctx context.Context, e Email) (int64, error) {
+	if err := checkBodySize(e); err != nil {
+		return 0, err
+	}
+	if e.Status == "" {
+		e.Status = "queued"
+	}
+	var gz []byte
+	if CompressBodies() {
+		var err error
+		if gz, err = gzipBody(e.Body); err != nil {
+			return 0, err
+		}
+		e.Body = ""
+	}
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var id int64
+	err = tx.QueryRowContext(ctx,
+		`INSERT INTO emails (to_addr, cc, bcc, subject, body, text_body, template_id, status, callback_url, priority, send_at, date_header,
+		 subject_truncated, from_addr, reply_to, return_path, request_dsn, expires_at, warning, correlation_id,
+		 audit_bcc, conversation_id, message_id, in_reply_to, refs, bulk, list_id, headers, timeout_seconds, test, campaign,
+		 body_compressed, compressed, content_type, tenant_id)
+		 VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15,$16,$17,$18,$19,$20,$21,$22,$23,$24,$25,$26,$27,$28,$29,$30,$31,$32,$33,$34,$35) RETURNING id`,
+		e.To, joinAddrs(e.Cc), joinAddrs(e.Bcc), e.Subject, e.Body, e.TextBody, e.TemplateID, e.Status, e.CallbackURL,
+		e.Priority, e.SendAt, e.DateHeader, e.SubjectTruncated, e.From, e.ReplyTo, e.ReturnPath, e.RequestDSN, e.ExpiresAt,
+		e.Warning, e.CorrelationID, e.AuditBcc, e.ConversationID, e.MessageID, e.InReplyTo, strings.Join(e.References, " "),
+		e.Bulk, e.ListID, headersJSON(e.Headers), e.TimeoutSeconds, e.Test, e.Campaign,
+		gz, gz != nil, e.ContentType, tenantFor(ctx, e.TenantID)).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+	for _, a := range e.Attachments {
+		if err := insertAttachment(ctx, tx, id, a); err != nil {
+			return 0, err
+		}
+	}
+	return id, tx.Commit()
 }
 
-func (s *Store) InsertQueued(ctx context.Context, to, subject, body string) (int64, error) {
+// attachmentChunk es el tamaño de los bloques en que se copia un adjunto
+// con Open.
+const attachmentChunk = 1 << 20
+
+// insertAttachment guarda a. Si tiene Open, el contenido se añade por
+// bloques de attachmentChunk para no cargarlo entero en memoria.
+func insertAttachment(ctx context.Context, tx *sql.Tx, emailID int64, a Attachment) error {
+	if a.Open == nil {
+		_, err := tx.ExecContext(ctx,
+			`INSERT INTO email_attachments (email_id, filename, content_type, content) VALUES ($1, $2, $3, $4)`,
+			emailID, a.Filename, a.ContentType, a.Content)
+		return err
+	}
+
 	var id int64
-	err := s.DB.QueryRowContext(ctx,
-		`INSERT INTO emails (to_addr, subject, body, status)
-		 VALUES ($1,$2,$3,'queued') RETURNING id`, to, subject, body).Scan(&id)
-	return id, err
+	if err := tx.QueryRowContext(ctx,
+		`INSERT INTO email_attachments (email_id, filename, content_type, content) VALUES ($1, $2, $3, '') RETURNING id`,
+		emailID, a.Filename, a.ContentType).Scan(&id); err != nil {
+		return err
+	}
+	r, err := a.Open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	buf := make([]byte, attachmentChunk)
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			if _, err := tx.ExecContext(ctx,
+				`UPDATE email_attachments SET content = content || $2 WHERE id=$1`, id, buf[:n]); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
 }
 
-func (s *Store) MarkSent(ctx context.Context, id int64) error {
-	_, err := s.DB.ExecContext(ctx, `UPDATE emails SET status='sent', sent_at=NOW() WHERE id=$1`, id)
-	return err
+// emailTenant es el tenant_id del correo de una fila de email_attempts o
+// email_attachments, para limitarlas con tenantCond.
+const emailTenant = `(SELECT tenant_id FROM emails WHERE emails.id = email_id)`
+
+// Attachment es un fichero adjunto a un correo.
+type Attachment struct {
+	Filename    string
+	ContentType string
+	Content     []byte
+	// Open, si no es nil, sustituye a Content al guardar el adjunto: se lee
+	// de ahí sin cargarlo entero (p. ej. desde el archivo temporal de una
+	// subida). Los adjuntos leídos de la base de datos solo traen Content.
+	Open func() (io.ReadCloser, error)
 }
 
-func (s *Store) MarkFailed(ctx context.Context, id int64, msg string) error {
-	_, err := s.DB.ExecContext(ctx, `UPDATE emails SET status='failed', error=$1 WHERE id=$2`, msg, id)
-	return err
+// Bytes devuelve el contenido de a, leyéndolo de Open si lo tiene.
+func (a Attachment) Bytes() ([]byte, error) {
+	if a.Open == nil {
+		return a.Content, nil
+	}
+	r, err := a.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
 }
 
-func (s *Store) ListEmails(ctx context.Context) ([]Email, error) {
+// Attachments devuelve los adjuntos del correo en orden de inserción.
+func (s *Store) Attachments(ctx context.Context, emailID int64) ([]Attachment, error) {
+	args := []any{emailID}
+	cond := tenantCond(ctx, emailTenant, &args)
 	rows, err := s.DB.QueryContext(ctx,
-		`SELECT id, to_addr, subject, body, status, error, created_at, sent_at
-		 FROM emails ORDER BY created_at DESC`)
+		`SELECT filename, content_type, content FROM email_attachments WHERE email_id=$1`+cond+` ORDER BY id`, args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var out []Email
+	var out []Attachment
 	for rows.Next() {
-		var e Email
-		if err := rows.Scan(&e.ID, &e.To, &e.Subject, &e.Body, &e.Status, &e.Error, &e.CreatedAt, &e.SentAt); err != nil {
+		var a Attachment
+		if err := rows.Scan(&a.Filename, &a.ContentType, &a.Content); err != nil {
 			return nil, err
 		}
-		out = append(out, e)
+		out = append(out, a)
 	}
-	return out, nil
+	return out, rows.Err()
 }
 
-func (s *Store) DeleteEmail(ctx context.Context, id int64) error {
-	_, err := s.DB.ExecContext(ctx, `DELETE FROM emails WHERE id=$1`, id)
+// Attempt es un intento de envío de un correo: Provider son los relays
+// usados, Code el código SMTP de la respuesta (0 sin respuesta) y Error el
+// motivo del fallo.
+type Attempt struct {
+	ID          int64     `json:"id"`
+	EmailID     int64     `json:"email_id"`
+	AttemptedAt time.Time `json:"attempted_at"`
+	Provider    string    `json:"provider"`
+	Code        int       `json:"code"`
+	Error       string    `json:"error,omitempty"`
+	DurationMs  int64     `json:"duration_ms"`
+	// Transcript es la conversación SMTP de un intento fallido, con las
+	// credenciales ocultas.
+	Transcript []TranscriptLine `json:"transcript,omitempty"`
+}
+
+// TranscriptLine es una línea de la conversación SMTP (ver
+// mailer.TranscriptLine).
+type TranscriptLine struct {
+	Relay string `json:"relay"`
+	Dir   string `json:"dir"`
+	Line  string `json:"line"`
+}
+
+// RecordAttempt añade un intento al historial del correo a.EmailID.
+func (s *Store) RecordAttempt(ctx context.Context, a Attempt) error {
+	_, err := s.DB.ExecContext(ctx, `
+		INSERT INTO email_attempts (email_id, provider, code, error, duration_ms, transcript)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`, a.EmailID, a.Provider, a.Code, a.Error, a.DurationMs, transcriptJSON(a.Transcript))
 	return err
 }
 
-// ==========================================================
-// PLANTILLAS CRUD
-// ==========================================================
-type Template struct {
-	ID        int64
-	Name      string
-	Subject   string
-	Body      string
-	CreatedAt time.Time
-	UpdatedAt time.Time
+// ListAttempts devuelve los intentos de envío del correo, del más antiguo
+// al más reciente.
+func (s *Store) ListAttempts(ctx context.Context, emailID int64) ([]Attempt, error) {
+	args := []any{emailID}
+	cond := tenantCond(ctx, emailTenant, &args)
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT id, email_id, attempted_at, provider, code, error, duration_ms, transcript
+		FROM email_attempts WHERE email_id=$1`+cond+` ORDER BY id
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []Attempt{}
+	for rows.Next() {
+		var a Attempt
+		var transcript []byte
+		if err := rows.Scan(&a.ID, &a.EmailID, &a.AttemptedAt, &a.Provider, &a.Code, &a.Error, &a.DurationMs, &transcript); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(transcript, &a.Transcript); err != nil {
+			return nil, err
+		}
+		out = append(out, a)
+	}
+	return out, rows.Err()
 }
 
-func (s *Store) ListTemplates(ctx context.Context) ([]Template, error) {
-	rows, err := s.DB.QueryContext(ctx, `SELECT id, name, subject, body, created_at, updated_at FROM templates ORDER BY created_at DESC`)
+// dueAt es la expresión SQL del instante a partir del cual un correo
+// pendiente (con alias t) puede enviarse: el próximo reintento, la fecha
+// programada o la de creación.
+func dueAt(t string) string {
+	return fmt.Sprintf("COALESCE(%[1]s.next_retry_at, %[1]s.send_at, %[1]s.created_at)", t)
+}
+
+// campaignPaused es la condición SQL de que el correo con alias t pertenezca
+// a una campaña pausada.
+func campaignPaused(t string) string {
+	return fmt.Sprintf("EXISTS (SELECT 1 FROM campaigns c WHERE c.tenant_id = %[1]s.tenant_id AND c.name = %[1]s.campaign AND c.paused)", t)
+}
+
+// pendingStatuses son los estados que el worker considera para despachar:
+// en cola, programados y en espera de reintento tras un fallo transitorio.
+const pendingStatuses = `('queued', 'scheduled', 'retrying')`
+
+// DueAt es el equivalente en Go de la expresión SQL dueAt.
+func (e Email) DueAt() time.Time {
+	switch {
+	case e.NextRetryAt.Valid:
+		return e.NextRetryAt.Time
+	case e.SendAt.Valid:
+		return e.SendAt.Time
+	}
+	return e.CreatedAt
+}
+
+// Pending indica si el correo está en uno de los pendingStatuses.
+func (e Email) Pending() bool {
+	return e.Status == "queued" || e.Status == "scheduled" || e.Status == "retrying"
+}
+
+// ClaimDue marca como sending hasta limit correos pendientes cuyo momento de
+// envío ya llegó (en cola o programados) y los devuelve, ordenados por
+// priority DESC, send_at ASC, created_at ASC.
+//
+// Solo se reclama el correo pendiente más antiguo de cada destinatario y nunca
+// uno cuyo destinatario tenga otro envío en curso, lo que garantiza orden FIFO
+// estricto por destinatario aunque haya varios workers concurrentes. Los
+// correos de campañas pausadas ni se reclaman ni retienen a los demás.
+func (s *Store) ClaimDue(ctx context.Context, limit int) ([]Email, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+		UPDATE emails SET status='sending', claimed_at=NOW()
+		WHERE id IN (
+			SELECT e.id FROM emails e
+			WHERE e.status IN `+pendingStatuses+`
+			  AND `+dueAt("e")+` <= NOW()
+			  AND (e.expires_at IS NULL OR e.expires_at > NOW())
+			  AND NOT `+campaignPaused("e")+`
+			  AND NOT EXISTS (
+				SELECT 1 FROM emails p
+				WHERE lower(p.to_addr) = lower(e.to_addr)
+				  AND (p.status = 'sending'
+				       OR (p.status IN `+pendingStatuses+` AND `+dueAt("p")+` <= NOW() AND p.id < e.id
+				           AND NOT `+campaignPaused("p")+`))
+			  )
+			ORDER BY e.priority DESC, `+dueAt("e")+` ASC, e.created_at ASC
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING `+emailColumns, limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanEmails(rows)
+}
+
+// ExpireStale marca como expired los correos pendientes cuya caducidad ya
+// pasó en now y los devuelve.
+func (s *Store) ExpireStale(ctx context.Context, now time.Time) ([]Email, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+		UPDATE emails SET status='expired', error='caducado sin enviar'
+		WHERE status IN `+pendingStatuses+` AND expires_at <= $1
+		RETURNING `+emailColumns, now)
+	if err != nil {
+		return nil, err
+	}
+	return scanEmails(rows)
+}
+
+// ClaimByIDs marca como sending los correos indicados que sigan pendientes,
+// hayan llegado a su momento de envío, no hayan caducado ni sean de una
+// campaña pausada, y los devuelve.
+// Lo usan las colas externas, que deciden el orden de despacho. Como en
+// ClaimDue, se omite el correo cuyo destinatario tenga otro envío en curso
+// o un pendiente más antiguo, así que el orden FIFO por destinatario se
+// mantiene aunque la cola entregue los ids desordenados.
+func (s *Store) ClaimByIDs(ctx context.Context, ids []int64) ([]Email, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+		UPDATE emails e SET status='sending', claimed_at=NOW()
+		WHERE e.id = ANY($1)
+		  AND e.status IN `+pendingStatuses+`
+		  AND `+dueAt("e")+` <= NOW()
+		  AND (e.expires_at IS NULL OR e.expires_at > NOW())
+		  AND NOT `+campaignPaused("e")+`
+		  AND NOT EXISTS (
+			SELECT 1 FROM emails p
+			WHERE lower(p.to_addr) = lower(e.to_addr)
+			  AND (p.status = 'sending'
+			       OR (p.status IN `+pendingStatuses+` AND `+dueAt("p")+` <= NOW() AND p.id < e.id
+			           AND NOT `+campaignPaused("p")+`))
+		  )
+		RETURNING `+emailColumns, ids)
+	if err != nil {
+		return nil, err
+	}
+	return scanEmails(rows)
+}
+
+// DueRef es un correo pendiente, el momento en que podrá enviarse y su
+// prioridad.
+type DueRef struct {
+	ID       int64
+	At       time.Time
+	Priority int
+}
+
+// PendingDue devuelve todos los correos pendientes, para reconstruir una
+// cola externa a partir de la base de datos.
+func (s *Store) PendingDue(ctx context.Context) ([]DueRef, error) {
+	rows, err := s.DB.QueryContext(ctx,
+		`SELECT id, `+dueAt("e")+`, priority FROM emails e WHERE e.status IN `+pendingStatuses)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var list []Template
+	var out []DueRef
 	for rows.Next() {
-		var t Template
-		if err := rows.Scan(&t.ID, &t.Name, &t.Subject, &t.Body, &t.CreatedAt, &t.UpdatedAt); err != nil {
+		var d DueRef
+		if err := rows.Scan(&d.ID, &d.At, &d.Priority); err != nil {
 			return nil, err
 		}
-		list = append(list, t)
+		out = append(out, d)
 	}
-	return list, nil
+	return out, rows.Err()
 }
 
-func (s *Store) InsertTemplate(ctx context.Context, name, subject, body string) (int64, error) {
-	var id int64
-	err := s.DB.QueryRowContext(ctx, `
-		INSERT INTO templates (name, subject, body, created_at, updated_at)
-		VALUES ($1, $2, $3, now(), now())
-		RETURNING id
-	`, name, subject, body).Scan(&id)
-	return id, err
+// RequeueClaimed devuelve a queued (o a retrying, si ya tuvieron algún
+// intento) los correos indicados que sigan en sending (reclamados pero no
+// terminados, p. ej. al apagar el worker).
+func (s *Store) RequeueClaimed(ctx context.Context, ids []int64) (int64, error) {
+	res, err := s.DB.ExecContext(ctx,
+		`UPDATE emails SET status=CASE WHEN attempts > 0 THEN 'retrying' ELSE 'queued' END, claimed_at=NULL
+		 WHERE id = ANY($1) AND status='sending'`, ids)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// DeferClaimed devuelve a la cola, como RequeueClaimed, los correos indicados
+// que sigan en sending, pero sin que puedan reclamarse antes de at
+// (next_retry_at). No cuenta como intento.
+func (s *Store) DeferClaimed(ctx context.Context, ids []int64, at time.Time) (int64, error) {
+	res, err := s.DB.ExecContext(ctx,
+		`UPDATE emails SET status=CASE WHEN attempts > 0 THEN 'retrying' ELSE 'queued' END, claimed_at=NULL, next_retry_at=$2
+		 WHERE id = ANY($1) AND status='sending'`, ids, at)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
 }
 
-func (s *Store) UpdateTemplate(ctx context.Context, id int64, name, subject, body string) error {
+func (s *Store) MarkSent(ctx context.Context, id int64) error {
+	_, err := s.DB.ExecContext(ctx, `UPDATE emails SET status='sent', sent_at=NOW() WHERE id=$1`, id)
+	return err
+}
+
+// MarkFailed marca el correo como fallido de forma definitiva, guardando la
+// clase del error.
+func (s *Store) MarkFailed(ctx context.Context, id int64, msg, class string) error {
+	_, err := s.DB.ExecContext(ctx,
+		`UPDATE emails SET status='failed', error=$1, error_class=$2, attempts=attempts+1 WHERE id=$3`, msg, class, id)
+	return err
+}
+
+// AddDelivered anota rcpts en delivered_to del correo id.
+func (s *Store) AddDelivered(ctx context.Context, id int64, rcpts []string) error {
+	if len(rcpts) == 0 {
+		return nil
+	}
 	_, err := s.DB.ExecContext(ctx, `
-		UPDATE templates
-		SET name=$1, subject=$2, body=$3, updated_at=now()
-		WHERE id=$4
-	`, name, subject, body, id)
+		UPDATE emails SET delivered_to = CASE WHEN delivered_to = '' THEN $1 ELSE delivered_to || ',' || $1 END
+		WHERE id=$2`, joinAddrs(rcpts), id)
 	return err
 }
 
-func (s *Store) DeleteTemplate(ctx context.Context, id int64) error {
-	_, err := s.DB.ExecContext(ctx, `DELETE FROM templates WHERE id=$1`, id)
+// MarkRetry deja en retrying un correo con un fallo transitorio para que
+// el worker lo reintente a partir de at (next_retry_at).
+func (s *Store) MarkRetry(ctx context.Context, id int64, msg string, at time.Time) error {
+	_, err := s.DB.ExecContext(ctx, `
+		UPDATE emails
+		SET status='retrying', error=$1, error_class='transient', attempts=attempts+1, next_retry_at=$2, claimed_at=NULL
+		WHERE id=$3`, msg, at, id)
+	return err
+}
+
+// statusTransitions son los cambios de estado que un operador puede forzar a
+// mano con TransitionStatus. Un correo enviado no sale nunca de sent.
+var statusTransitions = map[string][]string{
+	"queued":    {"failed"},
+	"scheduled": {"queued", "failed"},
+	"retrying":  {"queued", "failed"},
+	"sending":   {"queued", "failed"},
+	"failed":    {"queued"},
+	"expired":   {"queued"},
+}
+
+// CanTransition indica si statusTransitions permite pasar de from a to.
+func CanTransition(from, to string) bool {
+	return slices.Contains(statusTransitions[from], to)
+}
+
+// TransitionStatus pasa el correo id de from a to y lo anota en el registro
+// de auditoría, en una transacción. Al volver a queued se descartan el
+// reintento y la fecha programada, de modo que se envía cuanto antes; al
+// pasar a failed se guarda el motivo como error. Devuelve sql.ErrNoRows si
+// el correo ya no está en from.
+func (s *Store) TransitionStatus(ctx context.Context, id int64, from, to, reason string, a AuditEntry) error {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var res sql.Result
+	switch to {
+	case "queued":
+		args := []any{id, from}
+		res, err = tx.ExecContext(ctx, `
+			UPDATE emails SET status='queued', claimed_at=NULL, next_retry_at=NULL, send_at=NULL
+			WHERE id=$1 AND status=$2`+tenantCond(ctx, "tenant_id", &args), args...)
+	default:
+		args := []any{id, from, to, reason}
+		res, err = tx.ExecContext(ctx, `
+			UPDATE emails SET status=$3, claimed_at=NULL, error=$4, error_class='manual'
+			WHERE id=$1 AND status=$2`+tenantCond(ctx, "tenant_id", &args), args...)
+	}
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return sql.ErrNoRows
+	}
+	if err := insertAudit(ctx, tx, a); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// EmailFilter agrupa los filtros opcionales del listado de correos.
+// DateField indica la columna usada por From/To: "created_at" (por defecto) o "sent_at".
+// ErrorContains busca el texto en el error, sin distinguir mayúsculas.
+type EmailFilter struct {
+	Status         string
+	CorrelationID  string
+	ConversationID string
+	CallbackStatus string
+	Recipient      string
+	ErrorContains  string
+	Campaign       string
+	From           time.Time
+	To             time.Time
+	DateField      string
+
+	// Paginación: After/Before son cursores (id de un correo) que devuelven
+	// los correos posteriores o anteriores a él en el orden del listado
+	// (created_at, id descendente). Offset es la alternativa clásica.
+	// Limit 0 = sin límite.
+	Limit  int
+	Offset int
+	After  int64
+	Before int64
+}
+
+// order devuelve ORDER BY, LIMIT y OFFSET del listado. Con Before se recorre
+// en orden inverso; el llamador debe invertir el resultado (ver reversed).
+func (f EmailFilter) order() string {
+	q := ` ORDER BY created_at DESC, id DESC`
+	if f.reversed() {
+		q = ` ORDER BY created_at ASC, id ASC`
+	}
+	if f.Limit > 0 {
+		q += fmt.Sprintf(" LIMIT %d", f.Limit)
+	}
+	if f.Offset > 0 {
+		q += fmt.Sprintf(" OFFSET %d", f.Offset)
+	}
+	return q
+}
+
+// reversed indica si la consulta se hace en orden ascendente (cursor Before).
+func (f EmailFilter) reversed() bool {
+	return f.Before > 0 && f.After == 0
+}
+
+// MarkCallback registra el resultado del último intento de callback.
+func (s *Store) MarkCallback(ctx context.Context, id int64, status string, attempts int, msg string) error {
+	_, err := s.DB.ExecContext(ctx,
+		`UPDATE emails SET callback_status=$1, callback_attempts=$2, callback_error=NULLIF($3,''), callback_at=NOW()
+		 WHERE id=$4`, status, attempts, msg, id)
 	return err
 }
+
+func (s *Store) ListEmails(ctx context.Context) ([]Email, error) {
+	return s.ListEmailsFiltered(ctx, EmailFilter{})
+}
+
+func (s *Store) ListEmailsFiltered(ctx context.Context, f EmailFilter) ([]Email, error) {
+	where, args := f.where(ctx)
+	rows, err := s.DB.QueryContext(ctx,
+		`SELECT `+emailColumns+` FROM emails`+where+f.order(), args...)
+	if err != nil {
+		return nil, err
+	}
+	list, err := scanEmails(rows)
+	if f.reversed() {
+		slices.Reverse(list)
+	}
+	return list, err
+}
+
+// StreamEmails recorre fila a fila, desde el cursor de la consulta, los
+// correos que devolvería ListEmailsFiltered y llama a fn con cada uno, sin
+// cargarlos todos en memoria. Se detiene con el primer error de fn. No
+// admite el cursor Before, que exige invertir el resultado.
+func (s *Store) StreamEmails(ctx context.Context, f EmailFilter, fn func(Email) error) error {
+	where, args := f.where(ctx)
+	rows, err := s.DB.QueryContext(ctx,
+		`SELECT `+emailColumns+` FROM emails`+where+f.order(), args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		e, err := scanEmail(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// ListEmailsByCorrelationID devuelve los correos creados por la petición
+// con ese id de correlación.
+func (s *Store) ListEmailsByCorrelationID(ctx context.Context, id string) ([]Email, error) {
+	return s.ListEmailsFiltered(ctx, EmailFilter{CorrelationID: id})
+}
+
+// ListByConversation devuelve los correos del hilo en orden cronológico.
+func (s *Store) ListByConversation(ctx context.Context, id string) ([]Email, error) {
+	list, err := s.ListEmailsFiltered(ctx, EmailFilter{ConversationID: id})
+	slices.Reverse(list)
+	return list, err
+}
+
+// LastInConversation devuelve el correo más reciente del hilo, o
+// sql.ErrNoRows si el hilo no tiene ninguno.
+func (s *Store) LastInConversation(ctx context.Context, id string) (Email, error) {
+	return lastInConversation(s.ListEmailsFiltered(ctx, EmailFilter{ConversationID: id, Limit: 1}))
+}
+
+func lastInConversation(list []Email, err error) (Email, error) {
+	if err != nil {
+		return Email{}, err
+	}
+	if len(list) == 0 {
+		return Email{}, sql.ErrNoRows
+	}
+	return list[0], nil
+}
+
+// emailFieldColumns es la lista blanca de campos que acepta ListEmailFields,
+// con la columna de la que sale cada uno.
+var emailFieldColumns = map[string]string{
+	"id":              "id",
+	"to":              "to_addr",
+	"cc":              "cc",
+	"bcc":             "bcc",
+	"subject":         "subject",
+	"body":            "body",
+	"text_body":       "text_body",
+	"status":          "status",
+	"error":           "error",
+	"error_class":     "error_class",
+	"attempts":        "attempts",
+	"template_id":     "template_id",
+	"priority":        "priority",
+	"created_at":      "created_at",
+	"send_at":         "send_at",
+	"sent_at":         "sent_at",
+	"expires_at":      "expires_at",
+	"callback_url":    "callback_url",
+	"callback_status": "callback_status",
+	"warning":         "warning",
+	"correlation_id":  "correlation_id",
+	"conversation_id": "conversation_id",
+	"message_id":      "message_id",
+}
+
+// ErrUnknownField indica un campo fuera de emailFieldColumns.
+var ErrUnknownField = errors.New("campo desconocido")
+
+// ListEmailFields es como ListEmailsFiltered pero selecciona solo los campos
+// indicados y devuelve cada correo como un mapa campo → valor.
+func (s *Store) ListEmailFields(ctx context.Context, f EmailFilter, fields []string) ([]map[string]any, error) {
+	cols := make([]string, len(fields))
+	for i, name := range fields {
+		col, ok := emailFieldColumns[name]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownField, name)
+		}
+		cols[i] = col
+	}
+	// Un cuerpo comprimido se descomprime aquí: se piden además sus columnas
+	// al final de la fila.
+	withBody := slices.Contains(fields, "body")
+	if withBody {
+		cols = append(cols, "body_compressed", "compressed")
+	}
+
+	where, args := f.where(ctx)
+	rows, err := s.DB.QueryContext(ctx,
+		`SELECT `+strings.Join(cols, ", ")+` FROM emails`+where+f.order(), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []map[string]any
+	for rows.Next() {
+		vals := make([]any, len(cols))
+		ptrs := make([]any, len(cols))
+		for i := range vals {
+			ptrs[i] = &vals[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return nil, err
+		}
+		item := make(map[string]any, len(fields))
+		for i, name := range fields {
+			switch name {
+			case "cc", "bcc":
+				v, _ := vals[i].(string)
+				item[name] = splitAddrs(v)
+				continue
+			case "body":
+				if compressed, _ := vals[len(cols)-1].(bool); compressed {
+					gz, _ := vals[len(cols)-2].([]byte)
+					body, err := gunzipBody(gz)
+					if err != nil {
+						return nil, err
+					}
+					item[name] = body
+					continue
+				}
+			}
+			item[name] = vals[i]
+		}
+		out = append(out, item)
+	}
+	if f.reversed() {
+		slices.Reverse(out)
+	}
+	return out, rows.Err()
+}
+
+// likeEscaper escapa los comodines de LIKE para buscar el texto literal.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// where construye la cláusula WHERE y sus argumentos posicionales,
+// limitada al inquilino del contexto.
+func (f EmailFilter) where(ctx context.Context) (string, []any) {
+	var conds []string
+	var args []any
+	add := func(cond string, v any) {
+		args = append(args, v)
+		conds = append(conds, strings.Replace(cond, "?", fmt.Sprintf("$%d", len(args)), 1))
+	}
+
+	col := "created_at"
+	if f.DateField == "sent_at" {
+		col = "sent_at"
+	}
+	if f.Status != "" {
+		add("status = ?", f.Status)
+	}
+	if f.CallbackStatus != "" {
+		add("callback_status = ?", f.CallbackStatus)
+	}
+	if f.CorrelationID != "" {
+		add("correlation_id = ?", f.CorrelationID)
+	}
+	if f.ConversationID != "" {
+		add("conversation_id = ?", f.ConversationID)
+	}
+	if f.Recipient != "" {
+		add("lower(to_addr) = lower(?)", f.Recipient)
+	}
+	if f.Campaign != "" {
+		add("campaign = ?", f.Campaign)
+	}
+	if f.ErrorContains != "" {
+		add(`error ILIKE ?`, "%"+likeEscaper.Replace(f.ErrorContains)+"%")
+	}
+	if !f.From.IsZero() {
+		add(col+" >= ?", f.From)
+	}
+	if !f.To.IsZero() {
+		add(col+" <= ?", f.To)
+	}
+	if f.After > 0 {
+		add("(created_at, id) < (SELECT created_at, id FROM emails WHERE id = ?)", f.After)
+	}
+	if f.Before > 0 {
+		add("(created_at, id) > (SELECT created_at, id FROM emails WHERE id = ?)", f.Before)
+	}
+	if t, ok := TenantFrom(ctx); ok {
+		add("tenant_id = ?", t)
+	}
+	if len(conds) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conds, " AND "), args
+}
+
+func (s *Store) GetEmail(ctx context.Context, id int64) (Email, error) {
+	args := []any{id}
+	cond := tenantCond(ctx, "tenant_id", &args)
+	return scanEmail(s.DB.QueryRowContext(ctx, `SELECT `+emailColumns+` FROM emails WHERE id=$1`+cond, args...))
+}
+
+// QueuePosition devuelve el estado del correo y cuántos correos pendientes se
+// procesarán antes que él, siguiendo el mismo orden que ClaimDue. La
+// posición cuenta los correos de todos los inquilinos: la cola es común.
+func (s *Store) QueuePosition(ctx context.Context, id int64) (string, int64, error) {
+	var status string
+	var pos int64
+	args := []any{id}
+	cond := tenantCond(ctx, "e.tenant_id", &args)
+	err := s.DB.QueryRowContext(ctx, `
+		SELECT e.status,
+		       (SELECT COUNT(*) FROM emails q
+		        WHERE q.status IN `+pendingStatuses+` AND q.id <> e.id
+		          AND (q.priority > e.priority
+		               OR (q.priority = e.priority AND `+dueAt("q")+` < `+dueAt("e")+`)
+		               OR (q.priority = e.priority AND `+dueAt("q")+` = `+dueAt("e")+` AND q.created_at < e.created_at)))
+		FROM emails e WHERE e.id = $1`+cond, args...).Scan(&status, &pos)
+	return status, pos, err
+}
+
+// CountSentSince devuelve cuántos correos se enviaron desde since.
+func (s *Store) CountSentSince(ctx context.Context, since time.Time) (int64, error) {
+	var n int64
+	args := []any{since}
+	cond := tenantCond(ctx, "tenant_id", &args)
+	err := s.DB.QueryRowContext(ctx,
+		`SELECT COUNT(*) FROM emails WHERE status='sent' AND sent_at >= $1`+cond, args...).Scan(&n)
+	return n, err
+}
+
+// TemplateSendCounts devuelve cuántos correos de cada plantilla se enviaron
+// desde since, sin contar los de prueba. Lo usa el límite max_per_minute.
+func (s *Store) TemplateSendCounts(ctx context.Context, since time.Time) (map[int64]int64, error) {
+	args := []any{since}
+	cond := tenantCond(ctx, "tenant_id", &args)
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT template_id, COUNT(*) FROM emails
+		WHERE status='sent' AND sent_at >= $1 AND template_id IS NOT NULL AND NOT test`+cond+`
+		GROUP BY template_id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := map[int64]int64{}
+	for rows.Next() {
+		var id, n int64
+		if err := rows.Scan(&id, &n); err != nil {
+			return nil, err
+		}
+		out[id] = n
+	}
+	return out, rows.Err()
+}
+
+// CountByStatus devuelve el número de correos agrupados por estado, sin
+// contar los de prueba.
+func (s *Store) CountByStatus(ctx context.Context) (map[string]int64, error) {
+	var args []any
+	cond := tenantCond(ctx, "tenant_id", &args)
+	rows, err := s.DB.QueryContext(ctx, `SELECT status, COUNT(*) FROM emails WHERE NOT test`+cond+` GROUP BY status`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := map[string]int64{}
+	for rows.Next() {
+		var status string
+		var n int64
+		if err := rows.Scan(&status, &n); err != nil {
+			return nil, err
+		}
+		out[status] = n
+	}
+	return out, rows.Err()
+}
+
+// LatencyStats resume, en segundos, la latencia de envío de los correos
+// enviados en una ventana de tiempo.
+type LatencyStats struct {
+	Count int64
+	P50   float64
+	P90   float64
+	P95   float64
+	P99   float64
+}
+
+// SendLatency calcula los percentiles de sent_at menos el momento en que el
+// correo pudo enviarse (send_at para los programados, created_at para el
+// resto) de los correos enviados entre from y to, sin contar los de prueba.
+func (s *Store) SendLatency(ctx context.Context, from, to time.Time) (LatencyStats, error) {
+	var st LatencyStats
+	var p50, p90, p95, p99 sql.NullFloat64
+	args := []any{from, to}
+	cond := tenantCond(ctx, "e.tenant_id", &args)
+	err := s.DB.QueryRowContext(ctx, `
+		SELECT COUNT(*),
+			percentile_cont(0.50) WITHIN GROUP (ORDER BY lat),
+			percentile_cont(0.90) WITHIN GROUP (ORDER BY lat),
+			percentile_cont(0.95) WITHIN GROUP (ORDER BY lat),
+			percentile_cont(0.99) WITHIN GROUP (ORDER BY lat)
+		FROM (
+			SELECT EXTRACT(EPOCH FROM sent_at - COALESCE(send_at, created_at)) AS lat
+			FROM emails e
+			WHERE e.status = 'sent' AND e.sent_at >= $1 AND e.sent_at <= $2 AND NOT e.test`+cond+`
+		) l`, args...).Scan(&st.Count, &p50, &p90, &p95, &p99)
+	st.P50, st.P90, st.P95, st.P99 = p50.Float64, p90.Float64, p95.Float64, p99.Float64
+	return st, err
+}
+
+// DayCount es el número de correos creados en un día (UTC, "2006-01-02").
+type DayCount struct {
+	Date  string `json:"date"`
+	Count int64  `json:"count"`
+}
+
+// DailyCounts cuenta los correos creados por día entre los días from y to
+// (ambos incluidos, en UTC), opcionalmente solo los de un estado, sin contar
+// los de prueba. Los días sin correos aparecen con 0.
+func (s *Store) DailyCounts(ctx context.Context, from, to time.Time, status string) ([]DayCount, error) {
+	from, end := dayRange(from, to)
+	q := `SELECT to_char(date_trunc('day', created_at AT TIME ZONE 'UTC'), 'YYYY-MM-DD'), COUNT(*)
+		FROM emails WHERE created_at >= $1 AND created_at < $2 AND NOT test`
+	args := []any{from, end}
+	if status != "" {
+		q += ` AND status = $3`
+		args = append(args, status)
+	}
+	q += tenantCond(ctx, "tenant_id", &args)
+	rows, err := s.DB.QueryContext(ctx, q+` GROUP BY 1`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := map[string]int64{}
+	for rows.Next() {
+		var day string
+		var n int64
+		if err := rows.Scan(&day, &n); err != nil {
+			return nil, err
+		}
+		counts[day] = n
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return fillDays(from, end, counts), nil
+}
+
+// dayRange convierte los días from y to en el intervalo [inicio de from,
+// inicio del día siguiente a to) en UTC.
+func dayRange(from, to time.Time) (time.Time, time.Time) {
+	day := func(t time.Time) time.Time {
+		t = t.UTC()
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	}
+	return day(from), day(to).AddDate(0, 0, 1)
+}
+
+// fillDays devuelve un DayCount por día de [from, end), con 0 en los días
+// que no están en counts.
+func fillDays(from, end time.Time, counts map[string]int64) []DayCount {
+	out := []DayCount{}
+	for d := from; d.Before(end); d = d.AddDate(0, 0, 1) {
+		key := d.Format(time.DateOnly)
+		out = append(out, DayCount{Date: key, Count: counts[key]})
+	}
+	return out
+}
+
+// DeleteEmail borra el correo id; devuelve sql.ErrNoRows si no existe.
+func (s *Store) DeleteEmail(ctx context.Context, id int64) error {
+	args := []any{id}
+	cond := tenantCond(ctx, "tenant_id", &args)
+	res, err := s.DB.ExecContext(ctx, `DELETE FROM emails WHERE id=$1`+cond, args...)
+	return mustAffect(res, err)
+}
+
+// DeleteEmails elimina en una sola sentencia los correos indicados.
+func (s *Store) DeleteEmails(ctx context.Context, ids []int64) (int64, error) {
+	args := []any{ids}
+	cond := tenantCond(ctx, "tenant_id", &args)
+	res, err := s.DB.ExecContext(ctx, `DELETE FROM emails WHERE id = ANY($1)`+cond, args...)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// EmailStatus es el estado resumido de un correo para consultas por lotes.
+type EmailStatus struct {
+	Status string     `json:"status"`
+	Error  *string    `json:"error"`
+	SentAt *time.Time `json:"sent_at"`
+}
+
+// EmailStatuses devuelve el estado de los correos ids en una sola consulta;
+// los ids que no existen no aparecen en el mapa.
+func (s *Store) EmailStatuses(ctx context.Context, ids []int64) (map[int64]EmailStatus, error) {
+	args := []any{ids}
+	cond := tenantCond(ctx, "tenant_id", &args)
+	rows, err := s.DB.QueryContext(ctx,
+		`SELECT id, status, error, sent_at FROM emails WHERE id = ANY($1)`+cond, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make(map[int64]EmailStatus, len(ids))
+	for rows.Next() {
+		var id int64
+		var status string
+		var msg sql.NullString
+		var sentAt sql.NullTime
+		if err := rows.Scan(&id, &status, &msg, &sentAt); err != nil {
+			return nil, err
+		}
+		out[id] = EmailStatus{Status: status, Error: nullString(msg), SentAt: nullTime(sentAt)}
+	}
+	return out, rows.Err()
+}
+
+// DeleteFilter define un borrado masivo por estado y/o antigüedad.
+type DeleteFilter struct {
+	Status string
+	Before time.Time
+}
+
+func (s *Store) DeleteByFilter(ctx context.Context, f DeleteFilter) (int64, error) {
+	var conds []string
+	var args []any
+	if f.Status != "" {
+		args = append(args, f.Status)
+		conds = append(conds, fmt.Sprintf("status = $%d", len(args)))
+	}
+	if !f.Before.IsZero() {
+		args = append(args, f.Before)
+		conds = append(conds, fmt.Sprintf("created_at < $%d", len(args)))
+	}
+	if len(conds) == 0 {
+		return 0, fmt.Errorf("filtro vacío")
+	}
+	cond := tenantCond(ctx, "tenant_id", &args)
+	res, err := s.DB.ExecContext(ctx, `DELETE FROM emails WHERE `+strings.Join(conds, " AND ")+cond, args...)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// TruncateEmails borra todos los correos, sus adjuntos e intentos de una vez y
+// reinicia los ids. Solo para entornos de prueba (ver ALLOW_TRUNCATE).
+func (s *Store) TruncateEmails(ctx context.Context) error {
+	_, err := s.DB.ExecContext(ctx, `TRUNCATE emails, email_attachments, email_attempts RESTART IDENTITY`)
+	return err
+}
+
+// ==========================================================
+// PLANTILLAS CRUD
+// ==========================================================
+type Template struct {
+	ID      int64    `json:"id"`
+	Name    string   `json:"name"`
+	Subject string   `json:"subject"`
+	Body    string   `json:"body"`
+	Cc      []string `json:"cc,omitempty"`
+	Bcc     []string `json:"bcc,omitempty"`
+	// Locale es el idioma de esta variante del nombre; vacío = sin idioma.
+	Locale string `json:"locale,omitempty"`
+	// Delims son los delimitadores de acción ("[[ ]]"); vacío = "{{ }}".
+	Delims string `json:"delims,omitempty"`
+	// SubjectMaxLen recorta el asunto renderizado; 0 = usar SUBJECT_MAX_LEN.
+	SubjectMaxLen int `json:"subject_max_len,omitempty"`
+	// VariablesSchema declara las variables que acepta la plantilla; vacío
+	// = sin validación.
+	VariablesSchema []VariableSpec `json:"variables_schema,omitempty"`
+	// Bulk y ListID se aplican a todos los envíos de la plantilla.
+	Bulk   bool   `json:"bulk,omitempty"`
+	ListID string `json:"list_id,omitempty"`
+	// MaxPerMinute limita los envíos de la plantilla por minuto; 0 = sin
+	// límite. Lo aplica el worker aplazando los que lo superan.
+	MaxPerMinute int       `json:"max_per_minute,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+	// TenantID es el inquilino dueño de la plantilla (ver WithTenant).
+	TenantID string `json:"tenant_id,omitempty"`
+}
+
+// VariableSpec describe una variable de plantilla. Type es string, number,
+// integer, boolean, array u object.
+type VariableSpec struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Required bool   `json:"required,omitempty"`
+}
+
+// templateColumns es el orden de columnas que espera scanTemplate.
+const templateColumns = `id, name, locale, subject, body, cc, bcc, delims, subject_max_len, variables_schema, bulk, list_id, max_per_minute, created_at, updated_at, tenant_id`
+
+func scanTemplate(sc scanner) (Template, error) {
+	var t Template
+	var cc, bcc string
+	var schema []byte
+	err := sc.Scan(&t.ID, &t.Name, &t.Locale, &t.Subject, &t.Body, &cc, &bcc, &t.Delims, &t.SubjectMaxLen, &schema, &t.Bulk, &t.ListID, &t.MaxPerMinute, &t.CreatedAt, &t.UpdatedAt, &t.TenantID)
+	if err != nil {
+		return t, err
+	}
+	t.Cc, t.Bcc = splitAddrs(cc), splitAddrs(bcc)
+	if len(schema) > 0 {
+		err = json.Unmarshal(schema, &t.VariablesSchema)
+	}
+	return t, err
+}
+
+// headersJSON serializa las cabeceras personalizadas para la columna JSONB.
+func headersJSON(h map[string]string) []byte {
+	if len(h) == 0 {
+		return []byte("{}")
+	}
+	b, _ := json.Marshal(h)
+	return b
+}
+
+// transcriptJSON serializa la transcripción SMTP para la columna JSONB.
+func transcriptJSON(lines []TranscriptLine) []byte {
+	if len(lines) == 0 {
+		return []byte("[]")
+	}
+	b, _ := json.Marshal(lines)
+	return b
+}
+
+// schemaJSON serializa el esquema de variables para la columna JSONB.
+func schemaJSON(specs []VariableSpec) []byte {
+	if len(specs) == 0 {
+		return []byte("[]")
+	}
+	b, _ := json.Marshal(specs)
+	return b
+}
+
+func (s *Store) ListTemplates(ctx context.Context) ([]Template, error) {
+	var args []any
+	cond := tenantCond(ctx, "tenant_id", &args)
+	rows, err := s.DB.QueryContext(ctx, `SELECT `+templateColumns+` FROM templates WHERE true`+cond+` ORDER BY created_at DESC`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []Template
+	for rows.Next() {
+		t, err := scanTemplate(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, t)
+	}
+	return list, rows.Err()
+}
+
+func (s *Store) GetTemplate(ctx context.Context, id int64) (Template, error) {
+	args := []any{id}
+	cond := tenantCond(ctx, "tenant_id", &args)
+	return scanTemplate(s.DB.QueryRowContext(ctx, `SELECT `+templateColumns+` FROM templates WHERE id=$1`+cond, args...))
+}
+
+// GetTemplateByName devuelve la plantilla con ese nombre, preferentemente
+// la variante sin idioma.
+func (s *Store) GetTemplateByName(ctx context.Context, name string) (Template, error) {
+	args := []any{name}
+	cond := tenantCond(ctx, "tenant_id", &args)
+	return scanTemplate(s.DB.QueryRowContext(ctx,
+		`SELECT `+templateColumns+` FROM templates WHERE name=$1`+cond+` ORDER BY locale <> '', locale LIMIT 1`, args...))
+}
+
+// GetTemplateByNameLocale devuelve la variante de la plantilla name más
+// cercana a locale, probando los idiomas de LocaleChain en orden.
+func (s *Store) GetTemplateByNameLocale(ctx context.Context, name, locale string) (Template, error) {
+	args := []any{name, LocaleChain(locale)}
+	cond := tenantCond(ctx, "tenant_id", &args)
+	return scanTemplate(s.DB.QueryRowContext(ctx, `
+		SELECT `+templateColumns+` FROM templates
+		WHERE name=$1 AND locale = ANY($2::text[])`+cond+`
+		ORDER BY array_position($2::text[], locale)
+		LIMIT 1
+	`, args...))
+}
+
+// ErrDuplicateTemplateName indica que el inquilino ya tiene otra plantilla
+// con ese nombre e idioma.
+var ErrDuplicateTemplateName = errors.New("ya existe una plantilla con ese nombre e idioma")
+
+// templateErr traduce la violación del índice único
+// templates_tenant_name_locale_key.
+func templateErr(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" && pgErr.ConstraintName == "templates_tenant_name_locale_key" {
+		return ErrDuplicateTemplateName
+	}
+	return err
+}
+
+func (s *Store) InsertTemplate(ctx context.Context, t Template) (int64, error) {
+	var id int64
+	err := s.DB.QueryRowContext(ctx, `
+		INSERT INTO templates (name, subject, body, cc, bcc, delims, subject_max_len, variables_schema, bulk, list_id, locale, tenant_id,
+		                       max_per_minute, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, now(), now())
+		RETURNING id
+	`, t.Name, t.Subject, t.Body, joinAddrs(t.Cc), joinAddrs(t.Bcc), t.Delims, t.SubjectMaxLen, schemaJSON(t.VariablesSchema),
+		t.Bulk, t.ListID, t.Locale, tenantFor(ctx, t.TenantID), t.MaxPerMinute).Scan(&id)
+	return id, templateErr(err)
+}
+
+// UpdateTemplate guarda t; devuelve sql.ErrNoRows si la plantilla no existe.
+func (s *Store) UpdateTemplate(ctx context.Context, t Template) error {
+	args := []any{t.Name, t.Subject, t.Body, joinAddrs(t.Cc), joinAddrs(t.Bcc), t.Delims, t.SubjectMaxLen, schemaJSON(t.VariablesSchema),
+		t.Bulk, t.ListID, t.Locale, t.MaxPerMinute, t.ID}
+	cond := tenantCond(ctx, "tenant_id", &args)
+	res, err := s.DB.ExecContext(ctx, `
+		UPDATE templates
+		SET name=$1, subject=$2, body=$3, cc=$4, bcc=$5, delims=$6, subject_max_len=$7, variables_schema=$8, bulk=$9, list_id=$10,
+		    locale=$11, max_per_minute=$12, updated_at=now()
+		WHERE id=$13`+cond, args...)
+	return mustAffect(res, templateErr(err))
+}
+
+// DeleteTemplate borra la plantilla id; devuelve sql.ErrNoRows si no existe.
+func (s *Store) DeleteTemplate(ctx context.Context, id int64) error {
+	args := []any{id}
+	cond := tenantCond(ctx, "tenant_id", &args)
+	res, err := s.DB.ExecContext(ctx, `DELETE FROM templates WHERE id=$1`+cond, args...)
+	return mustAffect(res, err)
+}
+
+// UpsertTemplates guarda ts en una sola transacción, actualizando la
+// plantilla con el mismo nombre e idioma o creándola si no existe.
+// Si alguna falla no se aplica ninguna.
+func (s *Store) UpsertTemplates(ctx context.Context, ts []Template) (created, updated int, err error) {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+
+	for _, t := range ts {
+		var id int64
+		tenant := tenantFor(ctx, t.TenantID)
+		err := tx.QueryRowContext(ctx,
+			`SELECT id FROM templates WHERE tenant_id=$1 AND name=$2 AND locale=$3 FOR UPDATE`, tenant, t.Name, t.Locale).Scan(&id)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			_, err = tx.ExecContext(ctx, `
+				INSERT INTO templates (name, subject, body, cc, bcc, delims, subject_max_len, variables_schema, bulk, list_id, locale, tenant_id,
+				                       max_per_minute, created_at, updated_at)
+				VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, now(), now())
+			`, t.Name, t.Subject, t.Body, joinAddrs(t.Cc), joinAddrs(t.Bcc), t.Delims, t.SubjectMaxLen, schemaJSON(t.VariablesSchema),
+				t.Bulk, t.ListID, t.Locale, tenant, t.MaxPerMinute)
+			created++
+		case err == nil:
+			_, err = tx.ExecContext(ctx, `
+				UPDATE templates
+				SET subject=$1, body=$2, cc=$3, bcc=$4, delims=$5, subject_max_len=$6, variables_schema=$7, bulk=$8, list_id=$9,
+				    max_per_minute=$10, updated_at=now()
+				WHERE id=$11
+			`, t.Subject, t.Body, joinAddrs(t.Cc), joinAddrs(t.Bcc), t.Delims, t.SubjectMaxLen, schemaJSON(t.VariablesSchema),
+				t.Bulk, t.ListID, t.MaxPerMinute, id)
+			updated++
+		}
+		if err != nil {
+			return 0, 0, fmt.Errorf("plantilla %q: %w", t.Name, templateErr(err))
+		}
+	}
+	return created, updated, tx.Commit()
+}
+
+// TemplateVersion es una copia de una plantilla tomada antes de un cambio
+// masivo, para poder consultar o restaurar el contenido anterior.
+type TemplateVersion struct {
+	ID         int64     `json:"id"`
+	TemplateID int64     `json:"template_id"`
+	Name       string    `json:"name"`
+	Subject    string    `json:"subject"`
+	Body       string    `json:"body"`
+	Reason     string    `json:"reason,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// BodyChange es el reemplazo del cuerpo de una plantilla: OldBody es el
+// cuerpo a partir del que se calculó NewBody.
+type BodyChange struct {
+	ID      int64
+	OldBody string
+	NewBody string
+}
+
+// ErrTemplateChanged indica que una plantilla se modificó entre la lectura
+// y el reemplazo de su cuerpo.
+var ErrTemplateChanged = errors.New("la plantilla cambió durante el reemplazo")
+
+// ReplaceTemplateBodies aplica changes en una sola transacción, guardando
+// antes una versión de cada plantilla con reason. Si el cuerpo de alguna ya
+// no es OldBody devuelve ErrTemplateChanged y no se aplica ninguno.
+func (s *Store) ReplaceTemplateBodies(ctx context.Context, changes []BodyChange, reason string) error {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, c := range changes {
+		args := []any{c.ID, c.OldBody, reason}
+		cond := tenantCond(ctx, "tenant_id", &args)
+		res, err := tx.ExecContext(ctx, `
+			INSERT INTO template_versions (template_id, name, subject, body, reason)
+			SELECT id, name, subject, body, $3 FROM templates WHERE id=$1 AND body=$2`+cond, args...)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return fmt.Errorf("%w: %d", ErrTemplateChanged, c.ID)
+		}
+		if _, err := tx.ExecContext(ctx,
+			`UPDATE templates SET body=$1, updated_at=now() WHERE id=$2`, c.NewBody, c.ID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// ListTemplateVersions devuelve las versiones guardadas de una plantilla,
+// de la más reciente a la más antigua.
+func (s *Store) ListTemplateVersions(ctx context.Context, templateID int64) ([]TemplateVersion, error) {
+	args := []any{templateID}
+	cond := tenantCond(ctx, `(SELECT tenant_id FROM templates WHERE templates.id = template_id)`, &args)
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT id, template_id, name, subject, body, reason, created_at
+		FROM template_versions WHERE template_id=$1`+cond+` ORDER BY id DESC`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []TemplateVersion
+	for rows.Next() {
+		var v TemplateVersion
+		if err := rows.Scan(&v.ID, &v.TemplateID, &v.Name, &v.Subject, &v.Body, &v.Reason, &v.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, v)
+	}
+	return out, rows.Err()
+}
+
+// ==========================================================
+// ENVÍOS RECURRENTES
+// ==========================================================
+
+// Recurring genera un correo por destinatario a partir de una plantilla
+// cada vez que se cumple la expresión cron. TenantID es el inquilino de la
+// plantilla: solo él ve y modifica la recurrencia.
+type Recurring struct {
+	ID         int64          `json:"id"`
+	Name       string         `json:"name"`
+	Cron       string         `json:"cron"`
+	TemplateID int64          `json:"template_id"`
+	Recipients []string       `json:"recipients"`
+	Variables  map[string]any `json:"variables,omitempty"`
+	Active     bool           `json:"active"`
+	NextRunAt  sql.NullTime   `json:"next_run_at"`
+	LastRunAt  sql.NullTime   `json:"last_run_at"`
+	CreatedAt  time.Time      `json:"created_at"`
+	UpdatedAt  time.Time      `json:"updated_at"`
+	TenantID   string         `json:"tenant_id,omitempty"`
+}
+
+const recurringColumns = `id, name, cron_expr, template_id, recipients, variables, active, next_run_at, last_run_at,
+	created_at, updated_at, tenant_id`
+
+func scanRecurring(sc scanner) (Recurring, error) {
+	var rc Recurring
+	var recipients string
+	var vars []byte
+	err := sc.Scan(&rc.ID, &rc.Name, &rc.Cron, &rc.TemplateID, &recipients, &vars, &rc.Active, &rc.NextRunAt, &rc.LastRunAt,
+		&rc.CreatedAt, &rc.UpdatedAt, &rc.TenantID)
+	if err != nil {
+		return rc, err
+	}
+	rc.Recipients = splitAddrs(recipients)
+	if len(vars) > 0 {
+		err = json.Unmarshal(vars, &rc.Variables)
+	}
+	return rc, err
+}
+
+func (s *Store) ListRecurring(ctx context.Context) ([]Recurring, error) {
+	var args []any
+	cond := tenantCond(ctx, "tenant_id", &args)
+	rows, err := s.DB.QueryContext(ctx, `SELECT `+recurringColumns+` FROM recurring WHERE true`+cond+` ORDER BY id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []Recurring
+	for rows.Next() {
+		rc, err := scanRecurring(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, rc)
+	}
+	return list, rows.Err()
+}
+
+func (s *Store) GetRecurring(ctx context.Context, id int64) (Recurring, error) {
+	args := []any{id}
+	cond := tenantCond(ctx, "tenant_id", &args)
+	return scanRecurring(s.DB.QueryRowContext(ctx, `SELECT `+recurringColumns+` FROM recurring WHERE id=$1`+cond, args...))
+}
+
+func (s *Store) InsertRecurring(ctx context.Context, rc Recurring) (int64, error) {
+	vars, err := json.Marshal(rc.Variables)
+	if err != nil {
+		return 0, err
+	}
+	var id int64
+	err = s.DB.QueryRowContext(ctx, `
+		INSERT INTO recurring (name, cron_expr, template_id, recipients, variables, active, next_run_at, tenant_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id
+	`, rc.Name, rc.Cron, rc.TemplateID, joinAddrs(rc.Recipients), vars, rc.Active, rc.NextRunAt, tenantFor(ctx, rc.TenantID)).Scan(&id)
+	return id, err
+}
+
+// UpdateRecurring reemplaza la definición; devuelve sql.ErrNoRows si no existe.
+func (s *Store) UpdateRecurring(ctx context.Context, rc Recurring) error {
+	vars, err := json.Marshal(rc.Variables)
+	if err != nil {
+		return err
+	}
+	args := []any{rc.Name, rc.Cron, rc.TemplateID, joinAddrs(rc.Recipients), vars, rc.Active, rc.NextRunAt, rc.ID}
+	cond := tenantCond(ctx, "tenant_id", &args)
+	res, err := s.DB.ExecContext(ctx, `
+		UPDATE recurring
+		SET name=$1, cron_expr=$2, template_id=$3, recipients=$4, variables=$5, active=$6, next_run_at=$7, updated_at=now()
+		WHERE id=$8`+cond, args...)
+	return mustAffect(res, err)
+}
+
+// SetRecurringActive pausa o reanuda una recurrencia. Al reanudar se fija la
+// próxima ejecución para no materializar las ocurrencias perdidas.
+func (s *Store) SetRecurringActive(ctx context.Context, id int64, active bool, next sql.NullTime) error {
+	args := []any{active, next, id}
+	cond := tenantCond(ctx, "tenant_id", &args)
+	res, err := s.DB.ExecContext(ctx,
+		`UPDATE recurring SET active=$1, next_run_at=$2, updated_at=now() WHERE id=$3`+cond, args...)
+	return mustAffect(res, err)
+}
+
+func (s *Store) DeleteRecurring(ctx context.Context, id int64) error {
+	args := []any{id}
+	cond := tenantCond(ctx, "tenant_id", &args)
+	res, err := s.DB.ExecContext(ctx, `DELETE FROM recurring WHERE id=$1`+cond, args...)
+	return mustAffect(res, err)
+}
+
+// DueRecurring devuelve las recurrencias activas cuya próxima ejecución ya llegó.
+func (s *Store) DueRecurring(ctx context.Context) ([]Recurring, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT `+recurringColumns+` FROM recurring
+		WHERE active AND next_run_at <= NOW()
+		ORDER BY next_run_at`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []Recurring
+	for rows.Next() {
+		rc, err := scanRecurring(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, rc)
+	}
+	return list, rows.Err()
+}
+
+// AdvanceRecurring mueve next_run_at de prev a next solo si nadie lo hizo
+// antes, de modo que con varias instancias cada ocurrencia se materializa
+// una sola vez. Devuelve false si otra instancia ganó.
+func (s *Store) AdvanceRecurring(ctx context.Context, id int64, prev, next time.Time) (bool, error) {
+	res, err := s.DB.ExecContext(ctx, `
+		UPDATE recurring SET next_run_at=$1, last_run_at=NOW()
+		WHERE id=$2 AND active AND next_run_at=$3
+	`, next, id, prev)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// mustAffect convierte un UPDATE/DELETE que no tocó filas en sql.ErrNoRows.
+func mustAffect(res sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// ==========================================================
+// DESTINATARIOS (REBOTES Y SUPRESIÓN)
+// ==========================================================
+
+// Recipient es el historial de rebotes permanentes de una dirección (en
+// minúsculas) dentro de un inquilino: cada uno ve y suprime solo los
+// rebotes de sus propios envíos. SuppressedAt indica desde cuándo no se le
+// envía nada.
+type Recipient struct {
+	Address      string       `json:"address"`
+	Bounces      int          `json:"bounces"`
+	LastBounceAt sql.NullTime `json:"last_bounce_at"`
+	LastError    string       `json:"last_error,omitempty"`
+	SuppressedAt sql.NullTime `json:"suppressed_at"`
+	TenantID     string       `json:"tenant_id,omitempty"`
+}
+
+// BounceThreshold devuelve BOUNCE_SUPPRESS_THRESHOLD: los rebotes
+// permanentes tras los que se suprime una dirección (3 por defecto; 0 no
+// suprime nunca).
+func BounceThreshold() int {
+	n, err := strconv.Atoi(getEnv("BOUNCE_SUPPRESS_THRESHOLD", "3"))
+	if err != nil || n < 0 {
+		return 3
+	}
+	return n
+}
+
+const recipientColumns = `address, bounces, last_bounce_at, last_error, suppressed_at, tenant_id`
+
+func scanRecipient(sc scanner) (Recipient, error) {
+	var rc Recipient
+	err := sc.Scan(&rc.Address, &rc.Bounces, &rc.LastBounceAt, &rc.LastError, &rc.SuppressedAt, &rc.TenantID)
+	return rc, err
+}
+
+// RecordBounce suma un rebote permanente a addr, en el inquilino del
+// contexto, y la suprime al llegar a threshold rebotes (threshold <= 0 no
+// suprime nunca).
+func (s *Store) RecordBounce(ctx context.Context, addr, msg string, threshold int) (Recipient, error) {
+	return scanRecipient(s.DB.QueryRowContext(ctx, `
+		INSERT INTO recipients (address, bounces, last_bounce_at, last_error, suppressed_at, tenant_id)
+		VALUES (lower($1), 1, NOW(), $2, CASE WHEN $3 > 0 AND 1 >= $3 THEN NOW() END, $4)
+		ON CONFLICT (tenant_id, address) DO UPDATE SET
+			bounces = recipients.bounces + 1,
+			last_bounce_at = NOW(),
+			last_error = EXCLUDED.last_error,
+			suppressed_at = COALESCE(recipients.suppressed_at,
+				CASE WHEN $3 > 0 AND recipients.bounces + 1 >= $3 THEN NOW() END)
+		RETURNING `+recipientColumns, addr, msg, threshold, tenantFor(ctx, "")))
+}
+
+// GetRecipient devuelve el historial de addr, o sql.ErrNoRows si nunca rebotó.
+func (s *Store) GetRecipient(ctx context.Context, addr string) (Recipient, error) {
+	args := []any{addr}
+	cond := tenantCond(ctx, "tenant_id", &args)
+	return scanRecipient(s.DB.QueryRowContext(ctx,
+		`SELECT `+recipientColumns+` FROM recipients WHERE address = lower($1)`+cond+`
+		ORDER BY last_bounce_at DESC NULLS LAST LIMIT 1`, args...))
+}
+
+// ListRecipients devuelve las direcciones con rebotes, o solo las
+// suprimidas, de la más reciente a la más antigua.
+func (s *Store) ListRecipients(ctx context.Context, suppressedOnly bool) ([]Recipient, error) {
+	var args []any
+	q := `SELECT ` + recipientColumns + ` FROM recipients WHERE true` + tenantCond(ctx, "tenant_id", &args)
+	if suppressedOnly {
+		q += ` AND suppressed_at IS NOT NULL`
+	}
+	rows, err := s.DB.QueryContext(ctx, q+` ORDER BY last_bounce_at DESC NULLS LAST, address`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []Recipient{}
+	for rows.Next() {
+		rc, err := scanRecipient(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, rc)
+	}
+	return out, rows.Err()
+}
+
+// Suppressed devuelve las direcciones de addrs que están suprimidas, en
+// minúsculas.
+func (s *Store) Suppressed(ctx context.Context, addrs []string) ([]string, error) {
+	lower := make([]string, len(addrs))
+	for i, a := range addrs {
+		lower[i] = strings.ToLower(a)
+	}
+	args := []any{lower}
+	cond := tenantCond(ctx, "tenant_id", &args)
+	rows, err := s.DB.QueryContext(ctx,
+		`SELECT DISTINCT address FROM recipients WHERE suppressed_at IS NOT NULL AND address = ANY($1)`+cond, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []string
+	for rows.Next() {
+		var a string
+		if err := rows.Scan(&a); err != nil {
+			return nil, err
+		}
+		out = append(out, a)
+	}
+	return out, rows.Err()
+}
+
+// ResetRecipient borra el historial de addr, levantando la supresión.
+func (s *Store) ResetRecipient(ctx context.Context, addr string) error {
+	args := []any{addr}
+	cond := tenantCond(ctx, "tenant_id", &args)
+	res, err := s.DB.ExecContext(ctx, `DELETE FROM recipients WHERE address = lower($1)`+cond, args...)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// ==========================================================
+// CAMPAÑAS
+// ==========================================================
+
+// Campaign resume el estado de una campaña: si está pausada y cuántos de
+// sus correos esperan en cola (queued, scheduled o retrying), se están
+// enviando, se enviaron o fallaron. Las campañas son de cada inquilino: dos
+// inquilinos pueden usar el mismo nombre sin afectarse.
+type Campaign struct {
+	Name     string `json:"name"`
+	Paused   bool   `json:"paused"`
+	Queued   int64  `json:"queued"`
+	Sending  int64  `json:"sending"`
+	Sent     int64  `json:"sent"`
+	Failed   int64  `json:"failed"`
+	TenantID string `json:"tenant_id,omitempty"`
+}
+
+// campaignQuery agrega por campaña los correos del inquilino del contexto;
+// las campañas pausadas sin correos también aparecen.
+func campaignQuery(ctx context.Context, args *[]any) string {
+	return `
+	SELECT n.name, COALESCE(c.paused, false),
+	       COUNT(e.id) FILTER (WHERE e.status IN ` + pendingStatuses + `),
+	       COUNT(e.id) FILTER (WHERE e.status = 'sending'),
+	       COUNT(e.id) FILTER (WHERE e.status = 'sent'),
+	       COUNT(e.id) FILTER (WHERE e.status = 'failed'),
+	       n.tenant_id
+	FROM (SELECT DISTINCT tenant_id, campaign AS name FROM emails WHERE campaign <> ''` + tenantCond(ctx, "tenant_id", args) + `
+	      UNION SELECT tenant_id, name FROM campaigns WHERE true` + tenantCond(ctx, "tenant_id", args) + `) n
+	LEFT JOIN campaigns c ON c.tenant_id = n.tenant_id AND c.name = n.name
+	LEFT JOIN emails e ON e.tenant_id = n.tenant_id AND e.campaign = n.name`
+}
+
+func scanCampaign(sc scanner) (Campaign, error) {
+	var c Campaign
+	err := sc.Scan(&c.Name, &c.Paused, &c.Queued, &c.Sending, &c.Sent, &c.Failed, &c.TenantID)
+	return c, err
+}
+
+// ListCampaigns devuelve todas las campañas ordenadas por nombre.
+func (s *Store) ListCampaigns(ctx context.Context) ([]Campaign, error) {
+	var args []any
+	rows, err := s.DB.QueryContext(ctx, campaignQuery(ctx, &args)+` GROUP BY n.tenant_id, n.name, c.paused ORDER BY n.name, n.tenant_id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []Campaign{}
+	for rows.Next() {
+		c, err := scanCampaign(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, c)
+	}
+	return out, rows.Err()
+}
+
+// GetCampaign devuelve la campaña name, o sql.ErrNoRows si no tiene correos
+// ni se ha pausado nunca.
+func (s *Store) GetCampaign(ctx context.Context, name string) (Campaign, error) {
+	args := []any{name}
+	return scanCampaign(s.DB.QueryRowContext(ctx,
+		campaignQuery(ctx, &args)+` WHERE n.name = $1 GROUP BY n.tenant_id, n.name, c.paused`, args...))
+}
+
+// SetCampaignPaused pausa o reanuda la campaña name del inquilino del
+// contexto. Mientras está pausada sus correos pendientes siguen en cola pero
+// el worker no los reclama; los que ya se estaban enviando terminan.
+func (s *Store) SetCampaignPaused(ctx context.Context, name string, paused bool) error {
+	_, err := s.DB.ExecContext(ctx, `
+		INSERT INTO campaigns (tenant_id, name, paused, updated_at) VALUES ($1, $2, $3, NOW())
+		ON CONFLICT (tenant_id, name) DO UPDATE SET paused = EXCLUDED.paused, updated_at = NOW()
+	`, tenantFor(ctx, ""), name, paused)
+	return err
+}
+
+// PendingInCampaign devuelve los correos pendientes de la campaña del
+// inquilino del contexto, para volver a encolarlos al reanudarla.
+func (s *Store) PendingInCampaign(ctx context.Context, name string) ([]DueRef, error) {
+	args := []any{name}
+	cond := tenantCond(ctx, "e.tenant_id", &args)
+	rows, err := s.DB.QueryContext(ctx,
+		`SELECT id, `+dueAt("e")+`, priority FROM emails e WHERE e.campaign = $1 AND e.status IN `+pendingStatuses+cond, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []DueRef
+	for rows.Next() {
+		var d DueRef
+		if err := rows.Scan(&d.ID, &d.At, &d.Priority); err != nil {
+			return nil, err
+		}
+		out = append(out, d)
+	}
+	return out, rows.Err()
+}
+
+// ==========================================================
+// AUDITORÍA
+// ==========================================================
+
+// AuditEntry es una operación manual de un administrador, como forzar el
+// estado de un correo. EmailID es 0 si no afecta a un correo concreto.
+type AuditEntry struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Action    string    `json:"action"`
+	EmailID   int64     `json:"email_id,omitempty"`
+	Detail    string    `json:"detail,omitempty"`
+	RequestID string    `json:"request_id,omitempty"`
+}
+
+func insertAudit(ctx context.Context, tx *sql.Tx, a AuditEntry) error {
+	_, err := tx.ExecContext(ctx,
+		`INSERT INTO audit_log (action, email_id, detail, request_id) VALUES ($1, NULLIF($2, 0), $3, $4)`,
+		a.Action, a.EmailID, a.Detail, a.RequestID)
+	return err
+}
+
+// ListAudit devuelve las últimas limit entradas del registro de auditoría,
+// de la más reciente a la más antigua.
+func (s *Store) ListAudit(ctx context.Context, limit int) ([]AuditEntry, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT id, created_at, action, COALESCE(email_id, 0), detail, request_id
+		FROM audit_log ORDER BY id DESC LIMIT $1
+	`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []AuditEntry{}
+	for rows.Next() {
+		var a AuditEntry
+		if err := rows.Scan(&a.ID, &a.CreatedAt, &a.Action, &a.EmailID, &a.Detail, &a.RequestID); err != nil {
+			return nil, err
+		}
+		out = append(out, a)
+	}
+	return out, rows.Err()
+}
+
+// ==========================================================
+// UTILIDADES
+// ==========================================================
+
+// Las listas de direcciones se guardan separadas por comas.
+func joinAddrs(addrs []string) string {
+	return strings.Join(addrs, ",")
+}
+
+func splitAddrs(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
+
+func getEnv(k, d string) string {
+	if v := os.Getenv(k); v != "" {
+		return v
+	}
+	return d
+}
@@ -0,0 +1,57 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+)
+
+// Inquilinos: cuando la petición lleva un inquilino en el contexto
+// (WithTenant) las consultas de correos y plantillas solo ven sus filas y
+// las inserciones se guardan con su tenant_id. Sin inquilino en el contexto
+// (worker, scheduler, modo de un solo inquilino) se ven todas las filas.
+
+type tenantKey struct{}
+
+// WithTenant devuelve un contexto cuyas consultas se limitan al inquilino.
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// AllTenants quita el inquilino del contexto, para las consultas globales
+// que se hacen durante una petición (p. ej. la profundidad de la cola).
+func AllTenants(ctx context.Context) context.Context {
+	return context.WithValue(ctx, tenantKey{}, nil)
+}
+
+// TenantFrom devuelve el inquilino del contexto, si lo hay.
+func TenantFrom(ctx context.Context) (string, bool) {
+	t, ok := ctx.Value(tenantKey{}).(string)
+	return t, ok
+}
+
+// tenantCond devuelve la condición " AND col = $n" que limita la consulta
+// al inquilino del contexto, añadiendo su valor a args, o "" si no hay.
+// col puede ser una expresión, p. ej. el tenant_id del correo de un adjunto.
+func tenantCond(ctx context.Context, col string, args *[]any) string {
+	t, ok := TenantFrom(ctx)
+	if !ok {
+		return ""
+	}
+	*args = append(*args, t)
+	return fmt.Sprintf(" AND %s = $%d", col, len(*args))
+}
+
+// tenantFor es el tenant_id con el que se guarda una fila nueva: el del
+// contexto o, sin él, own (el de la propia fila).
+func tenantFor(ctx context.Context, own string) string {
+	if t, ok := TenantFrom(ctx); ok {
+		return t
+	}
+	return own
+}
+
+// visible indica si una fila del inquilino tenant se ve con ctx.
+func visible(ctx context.Context, tenant string) bool {
+	t, ok := TenantFrom(ctx)
+	return !ok || t == tenant
+}
@@ -0,0 +1,85 @@
+package tlsconf
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Política TLS de las conexiones salientes (SMTP con STARTTLS, callbacks y
+// renderizador de PDF):
+//
+//   - TLS_MIN_VERSION: versión mínima aceptada, "1.0" a "1.3" (por defecto
+//     "1.2"). Un servidor que negocie una versión inferior se rechaza.
+//   - TLS_CIPHER_SUITES: lista separada por comas de suites permitidas con
+//     los nombres de crypto/tls (p. ej. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256).
+//     Solo afecta a TLS 1.2 o inferior; las de TLS 1.3 no son configurables.
+
+var versions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// Check valida TLS_MIN_VERSION y TLS_CIPHER_SUITES al arrancar.
+func Check() error {
+	_, err := build("")
+	return err
+}
+
+// Config devuelve la configuración TLS para conectarse a serverName. Con
+// valores inválidos (ver Check) se usa TLS 1.2 sin restringir suites.
+func Config(serverName string) *tls.Config {
+	c, err := build(serverName)
+	if err != nil {
+		return &tls.Config{ServerName: serverName, MinVersion: tls.VersionTLS12}
+	}
+	return c
+}
+
+// Transport devuelve un transporte HTTP con la política TLS aplicada.
+func Transport() *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.TLSClientConfig = Config("")
+	return t
+}
+
+func build(serverName string) (*tls.Config, error) {
+	v := getEnv("TLS_MIN_VERSION", "1.2")
+	min, ok := versions[v]
+	if !ok {
+		return nil, fmt.Errorf("TLS_MIN_VERSION inválido: %q (use 1.0, 1.1, 1.2 o 1.3)", v)
+	}
+	c := &tls.Config{ServerName: serverName, MinVersion: min}
+
+	raw := getEnv("TLS_CIPHER_SUITES", "")
+	if raw == "" {
+		return c, nil
+	}
+	known := map[string]uint16{}
+	for _, s := range tls.CipherSuites() {
+		known[s.Name] = s.ID
+	}
+	for _, name := range strings.Split(raw, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		id, ok := known[name]
+		if !ok {
+			return nil, fmt.Errorf("TLS_CIPHER_SUITES: suite desconocida o insegura %q", name)
+		}
+		c.CipherSuites = append(c.CipherSuites, id)
+	}
+	return c, nil
+}
+
+func getEnv(k, d string) string {
+	if v := os.Getenv(k); v != "" {
+		return v
+	}
+	return d
+}
@@ -0,0 +1,94 @@
+package verify
+
+import (
+	"bufio"
+	"os"
+	"strings"
+	"sync"
+)
+
+// DomainList es un conjunto de dominios recargable en caliente. Una dirección
+// coincide si su dominio o cualquiera de sus dominios padre está en la lista.
+type DomainList struct {
+	mu      sync.RWMutex
+	domains map[string]struct{}
+}
+
+// LoadDisposable carga la lista de dominios desechables desde
+// DISPOSABLE_DOMAINS (separados por comas) y DISPOSABLE_DOMAINS_FILE
+// (uno por línea, se ignoran líneas vacías y comentarios con #).
+func LoadDisposable() (*DomainList, error) {
+	l := &DomainList{}
+	return l, l.Reload()
+}
+
+// Reload vuelve a leer la configuración. Si el fichero no puede leerse se
+// conserva la lista anterior.
+func (l *DomainList) Reload() error {
+	set := map[string]struct{}{}
+	for _, d := range strings.Split(getEnv("DISPOSABLE_DOMAINS", ""), ",") {
+		addDomain(set, d)
+	}
+
+	if path := getEnv("DISPOSABLE_DOMAINS_FILE", ""); path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		sc := bufio.NewScanner(f)
+		for sc.Scan() {
+			line := sc.Text()
+			if i := strings.Index(line, "#"); i >= 0 {
+				line = line[:i]
+			}
+			addDomain(set, line)
+		}
+		if err := sc.Err(); err != nil {
+			return err
+		}
+	}
+
+	l.mu.Lock()
+	l.domains = set
+	l.mu.Unlock()
+	return nil
+}
+
+// Len devuelve el número de dominios cargados.
+func (l *DomainList) Len() int {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return len(l.domains)
+}
+
+// Contains indica si el dominio de address (o uno de sus padres) está en la lista.
+func (l *DomainList) Contains(address string) bool {
+	domain := strings.ToLower(strings.TrimSpace(address))
+	if i := strings.LastIndex(domain, "@"); i >= 0 {
+		domain = domain[i+1:]
+	}
+	domain = strings.TrimSuffix(domain, ">")
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	for domain != "" {
+		if _, ok := l.domains[domain]; ok {
+			return true
+		}
+		i := strings.Index(domain, ".")
+		if i < 0 {
+			break
+		}
+		domain = domain[i+1:]
+	}
+	return false
+}
+
+func addDomain(set map[string]struct{}, d string) {
+	d = strings.ToLower(strings.TrimSpace(d))
+	if d != "" {
+		set[d] = struct{}{}
+	}
+}
@@ -0,0 +1,54 @@
+package verify
+
+import (
+	"strings"
+)
+
+// defaultRoleAddresses son las partes locales de buzones de sistema a los que
+// casi nunca se envía a propósito.
+var defaultRoleAddresses = []string{
+	"postmaster",
+	"abuse",
+	"hostmaster",
+	"webmaster",
+	"mailer-daemon",
+	"noreply",
+	"no-reply",
+	"donotreply",
+	"do-not-reply",
+}
+
+// loadRoleAddresses lee ROLE_ADDRESSES (partes locales separadas por comas);
+// si no está definida usa defaultRoleAddresses.
+func loadRoleAddresses() map[string]struct{} {
+	list := defaultRoleAddresses
+	if v := getEnv("ROLE_ADDRESSES", ""); v != "" {
+		list = strings.Split(v, ",")
+	}
+	set := map[string]struct{}{}
+	for _, p := range list {
+		if p = strings.ToLower(strings.TrimSpace(p)); p != "" {
+			set[p] = struct{}{}
+		}
+	}
+	return set
+}
+
+// IsRole indica si la parte local de address (sin +etiqueta) es un buzón de
+// sistema de la lista ROLE_ADDRESSES, p. ej. postmaster@ o noreply@.
+func (v *Verifier) IsRole(address string) bool {
+	local := strings.ToLower(strings.TrimSpace(address))
+	if i := strings.LastIndex(local, "<"); i >= 0 {
+		local = local[i+1:]
+	}
+	i := strings.LastIndex(local, "@")
+	if i < 0 {
+		return false
+	}
+	local = local[:i]
+	if j := strings.Index(local, "+"); j >= 0 {
+		local = local[:j]
+	}
+	_, ok := v.RoleAddresses[local]
+	return ok
+}
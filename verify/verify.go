@@ -0,0 +1,212 @@
+package verify
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Check es el resultado de una comprobación individual.
+type Check struct {
+	OK      bool     `json:"ok"`
+	Skipped bool     `json:"skipped,omitempty"`
+	Error   string   `json:"error,omitempty"`
+	Hosts   []string `json:"hosts,omitempty"`
+}
+
+// Result agrupa las comprobaciones realizadas sobre una dirección.
+type Result struct {
+	Address    string           `json:"address"`
+	Valid      bool             `json:"valid"`
+	Disposable bool             `json:"disposable"`
+	Role       bool             `json:"role_address"`
+	Checks     map[string]Check `json:"checks"`
+}
+
+// Verifier valida direcciones por sintaxis, registros MX y, opcionalmente,
+// con una sonda SMTP que solo llega hasta RCPT TO (no envía nada).
+type Verifier struct {
+	SMTPProbe bool
+	Timeout   time.Duration
+	CacheTTL  time.Duration
+
+	// Disposable es la lista de dominios desechables; con BlockDisposable
+	// las direcciones que coinciden se consideran inválidas.
+	Disposable      *DomainList
+	BlockDisposable bool
+
+	// RoleAddresses son las partes locales de buzones de sistema
+	// (postmaster, noreply...); con BlockRole se consideran inválidas.
+	RoleAddresses map[string]struct{}
+	BlockRole     bool
+
+	mu    sync.Mutex
+	cache map[string]mxEntry
+}
+
+type mxEntry struct {
+	hosts   []string
+	err     error
+	expires time.Time
+}
+
+// New crea un verificador configurado desde el entorno:
+// VALIDATE_SMTP_PROBE (por defecto false), MX_CACHE_TTL (por defecto 5m),
+// BLOCK_DISPOSABLE y la lista de dominios desechables (ver LoadDisposable),
+// BLOCK_ROLE_ADDRESSES (por defecto false) y ROLE_ADDRESSES.
+func New() (*Verifier, error) {
+	ttl, err := time.ParseDuration(getEnv("MX_CACHE_TTL", "5m"))
+	if err != nil {
+		ttl = 5 * time.Minute
+	}
+	disposable, err := LoadDisposable()
+	return &Verifier{
+		SMTPProbe:       getEnv("VALIDATE_SMTP_PROBE", "false") == "true",
+		Timeout:         10 * time.Second,
+		CacheTTL:        ttl,
+		Disposable:      disposable,
+		BlockDisposable: getEnv("BLOCK_DISPOSABLE", "false") == "true",
+		RoleAddresses:   loadRoleAddresses(),
+		BlockRole:       getEnv("BLOCK_ROLE_ADDRESSES", "false") == "true",
+		cache:           map[string]mxEntry{},
+	}, err
+}
+
+// IsDisposable indica si la dirección pertenece a un dominio desechable.
+func (v *Verifier) IsDisposable(address string) bool {
+	return v.Disposable.Contains(address)
+}
+
+// Verify ejecuta las comprobaciones en orden; si una falla, las siguientes
+// se marcan como omitidas.
+func (v *Verifier) Verify(ctx context.Context, address string) Result {
+	res := Result{Address: address, Checks: map[string]Check{}}
+
+	addr, err := mail.ParseAddress(address)
+	if err != nil || !strings.Contains(addr.Address, "@") {
+		res.Checks["syntax"] = Check{Error: "formato de dirección inválido"}
+		res.Checks["mx"] = Check{Skipped: true}
+		res.Checks["smtp"] = Check{Skipped: true}
+		return res
+	}
+	res.Checks["syntax"] = Check{OK: true}
+
+	res.Disposable = v.IsDisposable(addr.Address)
+	res.Checks["disposable"] = Check{OK: !res.Disposable}
+	res.Role = v.IsRole(addr.Address)
+	res.Checks["role"] = Check{OK: !res.Role}
+	if res.Disposable && v.BlockDisposable || res.Role && v.BlockRole {
+		res.Checks["mx"] = Check{Skipped: true}
+		res.Checks["smtp"] = Check{Skipped: true}
+		return res
+	}
+
+	domain := strings.ToLower(addr.Address[strings.LastIndex(addr.Address, "@")+1:])
+	hosts, err := v.LookupMX(ctx, domain)
+	if err != nil {
+		res.Checks["mx"] = Check{Error: err.Error()}
+		res.Checks["smtp"] = Check{Skipped: true}
+		return res
+	}
+	res.Checks["mx"] = Check{OK: true, Hosts: hosts}
+
+	if !v.SMTPProbe {
+		res.Checks["smtp"] = Check{Skipped: true}
+		res.Valid = true
+		return res
+	}
+	if err := v.probe(hosts[0], addr.Address); err != nil {
+		res.Checks["smtp"] = Check{Error: err.Error()}
+		return res
+	}
+	res.Checks["smtp"] = Check{OK: true}
+	res.Valid = true
+	return res
+}
+
+// LookupMX devuelve los servidores de correo del dominio ordenados por
+// preferencia. Si no hay registros MX se usa el propio dominio cuando
+// resuelve (RFC 5321, MX implícito). Los resultados se cachean CacheTTL.
+func (v *Verifier) LookupMX(ctx context.Context, domain string) ([]string, error) {
+	v.mu.Lock()
+	if e, ok := v.cache[domain]; ok && time.Now().Before(e.expires) {
+		v.mu.Unlock()
+		return e.hosts, e.err
+	}
+	v.mu.Unlock()
+
+	hosts, err := lookupMX(ctx, domain)
+
+	v.mu.Lock()
+	v.cache[domain] = mxEntry{hosts: hosts, err: err, expires: time.Now().Add(v.CacheTTL)}
+	v.mu.Unlock()
+	return hosts, err
+}
+
+func lookupMX(ctx context.Context, domain string) ([]string, error) {
+	var r net.Resolver
+	mxs, err := r.LookupMX(ctx, domain)
+	if err == nil && len(mxs) > 0 {
+		sort.Slice(mxs, func(i, j int) bool { return mxs[i].Pref < mxs[j].Pref })
+		hosts := make([]string, 0, len(mxs))
+		for _, mx := range mxs {
+			h := strings.TrimSuffix(mx.Host, ".")
+			if h == "" {
+				// MX nulo (RFC 7505): el dominio no acepta correo.
+				return nil, fmt.Errorf("el dominio no acepta correo")
+			}
+			hosts = append(hosts, h)
+		}
+		return hosts, nil
+	}
+
+	if _, herr := r.LookupHost(ctx, domain); herr == nil {
+		return []string{domain}, nil
+	}
+	return nil, fmt.Errorf("el dominio no tiene registros MX")
+}
+
+// probe abre una conversación SMTP con el servidor MX y comprueba si acepta
+// el destinatario, cerrando la sesión antes de DATA.
+func (v *Verifier) probe(host, address string) error {
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(host, "25"), v.Timeout)
+	if err != nil {
+		return fmt.Errorf("no se pudo conectar a %s: %v", host, err)
+	}
+	conn.SetDeadline(time.Now().Add(v.Timeout))
+
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if err := c.Hello(getEnv("VALIDATE_HELO_DOMAIN", "localhost")); err != nil {
+		return err
+	}
+	from := getEnv("VALIDATE_MAIL_FROM", getEnv("FROM_EMAIL", "postmaster@localhost"))
+	if err := c.Mail(from); err != nil {
+		return err
+	}
+	if err := c.Rcpt(address); err != nil {
+		return fmt.Errorf("destinatario rechazado: %v", err)
+	}
+	c.Reset()
+	c.Quit()
+	return nil
+}
+
+func getEnv(k, d string) string {
+	if v := os.Getenv(k); v != "" {
+		return v
+	}
+	return d
+}
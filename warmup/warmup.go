@@ -0,0 +1,114 @@
+package warmup
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"mailer-service/storage"
+)
+
+// Rampa de calentamiento de una IP de envío nueva:
+//
+//   - WARMUP_SCHEDULE: cupo de envíos de cada día separado por comas, p. ej.
+//     "50,100,200,400"; el primero es el del día 1. Vacío la desactiva.
+//   - WARMUP_START: fecha del día 1 (YYYY-MM-DD). Obligatoria con
+//     WARMUP_SCHEDULE.
+//
+// Los días van de medianoche a medianoche UTC y se cuentan los correos ya
+// enviados (Store.CountSentSince). Pasado el último día no hay límite.
+
+// Ramp limita los envíos diarios según el calendario de calentamiento.
+// Un *Ramp nil no limita nada.
+type Ramp struct {
+	Store    storage.Repository
+	Schedule []int
+	Start    time.Time
+}
+
+// Status es el cupo del día en curso y su uso.
+type Status struct {
+	Day       int       `json:"day"`
+	Cap       int       `json:"cap"`
+	Sent      int64     `json:"sent"`
+	Remaining int64     `json:"remaining"`
+	ResetsAt  time.Time `json:"resets_at"`
+}
+
+// FromEnv crea la rampa a partir de WARMUP_SCHEDULE y WARMUP_START.
+// Devuelve nil si no está configurada y un error si la configuración es
+// inválida.
+func FromEnv(s storage.Repository) (*Ramp, error) {
+	raw := strings.TrimSpace(os.Getenv("WARMUP_SCHEDULE"))
+	if raw == "" {
+		return nil, nil
+	}
+	var schedule []int
+	for _, part := range strings.Split(raw, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("WARMUP_SCHEDULE inválido: %q no es un cupo diario", part)
+		}
+		schedule = append(schedule, n)
+	}
+	start, err := time.Parse(time.DateOnly, os.Getenv("WARMUP_START"))
+	if err != nil {
+		return nil, fmt.Errorf("WARMUP_START inválido: se espera YYYY-MM-DD")
+	}
+	return &Ramp{Store: s, Schedule: schedule, Start: start}, nil
+}
+
+// Cap devuelve el día de calentamiento de now y su cupo. ok es false si no
+// hay límite (rampa nil o calendario terminado). Antes de WARMUP_START se
+// aplica el cupo del día 1.
+func (r *Ramp) Cap(now time.Time) (day, limit int, ok bool) {
+	if r == nil {
+		return 0, 0, false
+	}
+	day = int(startOfDay(now).Sub(r.Start)/(24*time.Hour)) + 1
+	if day < 1 {
+		day = 1
+	}
+	if day > len(r.Schedule) {
+		return day, 0, false
+	}
+	return day, r.Schedule[day-1], true
+}
+
+// Status devuelve el cupo de hoy y cuántos correos se han enviado ya. ok es
+// false si no hay límite.
+func (r *Ramp) Status(ctx context.Context, now time.Time) (st Status, ok bool, err error) {
+	day, limit, ok := r.Cap(now)
+	if !ok {
+		return Status{}, false, nil
+	}
+	today := startOfDay(now)
+	sent, err := r.Store.CountSentSince(ctx, today)
+	if err != nil {
+		return Status{}, false, err
+	}
+	return Status{
+		Day:       day,
+		Cap:       limit,
+		Sent:      sent,
+		Remaining: max(int64(limit)-sent, 0),
+		ResetsAt:  today.AddDate(0, 0, 1),
+	}, true, nil
+}
+
+// Limit devuelve cuántos envíos de n caben aún en el cupo de hoy.
+func (r *Ramp) Limit(ctx context.Context, n int) (int, error) {
+	st, ok, err := r.Status(ctx, time.Now())
+	if err != nil || !ok {
+		return n, err
+	}
+	return int(min(int64(n), st.Remaining)), nil
+}
+
+func startOfDay(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,135 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"mailer-service/storage"
+	"mailer-service/tlsconf"
+)
+
+// Dispatcher notifica al callback_url de cada correo su estado final.
+// El resultado de la notificación se guarda aparte del estado del correo.
+type Dispatcher struct {
+	Store      storage.Repository
+	Client     *http.Client
+	MaxRetries int
+	Backoff    time.Duration
+	// Secret firma cada notificación en X-Mailer-Signature (ver Sign);
+	// vacío = sin firma.
+	Secret string
+}
+
+// Event es el cuerpo JSON enviado al callback.
+type Event struct {
+	ID     int64      `json:"id"`
+	To     string     `json:"to"`
+	Status string     `json:"status"`
+	Error  string     `json:"error,omitempty"`
+	SentAt *time.Time `json:"sent_at,omitempty"`
+}
+
+// New crea un dispatcher con CALLBACK_MAX_RETRIES reintentos (por defecto 3)
+// que firma con WEBHOOK_SIGNING_SECRET.
+func New(s storage.Repository) *Dispatcher {
+	retries, err := strconv.Atoi(getEnv("CALLBACK_MAX_RETRIES", "3"))
+	if err != nil || retries < 0 {
+		retries = 3
+	}
+	return &Dispatcher{
+		Store:      s,
+		Client:     &http.Client{Timeout: 10 * time.Second, Transport: tlsconf.Transport()},
+		MaxRetries: retries,
+		Backoff:    time.Second,
+		Secret:     getEnv("WEBHOOK_SIGNING_SECRET", ""),
+	}
+}
+
+// Sign devuelve el valor de X-Mailer-Signature para payload enviado en el
+// instante ts: "t=<segundos Unix>,v1=<firma>", donde la firma es
+// hex(HMAC-SHA256(secret, t + "." + payload)).
+func Sign(secret string, ts time.Time, payload []byte) string {
+	t := strconv.FormatInt(ts.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(t + "."))
+	mac.Write(payload)
+	return "t=" + t + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Notify carga el correo y, si tiene callback_url, le envía su estado
+// reintentando con backoff exponencial. Pensado para ejecutarse en una goroutine.
+func (d *Dispatcher) Notify(id int64) {
+	ctx := context.Background()
+	e, err := d.Store.GetEmail(ctx, id)
+	if err != nil {
+		log.Printf("Callback: no se pudo cargar el correo %d: %v", id, err)
+		return
+	}
+	if e.CallbackURL == "" {
+		return
+	}
+
+	ev := Event{ID: e.ID, To: e.To, Status: e.Status, Error: e.Error.String}
+	if e.SentAt.Valid {
+		ev.SentAt = &e.SentAt.Time
+	}
+	payload, _ := json.Marshal(ev)
+
+	backoff := d.Backoff
+	attempts := 0
+	for {
+		attempts++
+		err = d.post(ctx, e.CallbackURL, payload)
+		if err == nil {
+			_ = d.Store.MarkCallback(ctx, id, "delivered", attempts, "")
+			return
+		}
+		if attempts > d.MaxRetries {
+			break
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+
+	log.Printf("Callback del correo %d falló tras %d intentos: %v", id, attempts, err)
+	_ = d.Store.MarkCallback(ctx, id, "failed", attempts, err.Error())
+}
+
+func (d *Dispatcher) post(ctx context.Context, url string, payload []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	// Cada intento se firma con su propia marca de tiempo.
+	if d.Secret != "" {
+		req.Header.Set("X-Mailer-Signature", Sign(d.Secret, time.Now(), payload))
+	}
+
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("respuesta %d del callback", resp.StatusCode)
+	}
+	return nil
+}
+
+func getEnv(k, d string) string {
+	if v := os.Getenv(k); v != "" {
+		return v
+	}
+	return d
+}
@@ -0,0 +1,89 @@
+package webhook
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"mailer-service/storage"
+)
+
+// callbackReceiver responde 500 a las primeras failures peticiones y 200 al
+// resto, anotando cuándo llegó cada una.
+func callbackReceiver(t *testing.T, failures int) (*httptest.Server, func() []time.Time) {
+	t.Helper()
+	var mu sync.Mutex
+	var hits []time.Time
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		hits = append(hits, time.Now())
+		n := len(hits)
+		mu.Unlock()
+		if n <= failures {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv, func() []time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]time.Time(nil), hits...)
+	}
+}
+
+func notifyOnce(t *testing.T, url string, d *Dispatcher) storage.Email {
+	t.Helper()
+	ctx := context.Background()
+	id, err := d.Store.InsertEmail(ctx, storage.Email{To: "a@example.com", Subject: "s", Body: "b", Status: "sent", CallbackURL: url})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Notify(id)
+	e, err := d.Store.GetEmail(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+// Un receptor que falla dos veces recibe el tercer intento tras esperar
+// Backoff y luego el doble.
+func TestNotifyRetriesWithBackoff(t *testing.T) {
+	srv, hits := callbackReceiver(t, 2)
+	const backoff = 30 * time.Millisecond
+	d := &Dispatcher{Store: storage.NewMemStore(), Client: srv.Client(), MaxRetries: 3, Backoff: backoff}
+
+	e := notifyOnce(t, srv.URL, d)
+
+	got := hits()
+	if len(got) != 3 {
+		t.Fatalf("%d peticiones al callback, se esperaban 3", len(got))
+	}
+	if e.CallbackStatus != "delivered" || e.CallbackAttempts != 3 {
+		t.Errorf("callback %q tras %d intentos, se esperaba delivered tras 3", e.CallbackStatus, e.CallbackAttempts)
+	}
+	for i, want := range []time.Duration{backoff, 2 * backoff} {
+		if gap := got[i+1].Sub(got[i]); gap < want {
+			t.Errorf("espera antes del intento %d: %s, se esperaba al menos %s", i+2, gap, want)
+		}
+	}
+}
+
+// Sin éxito tras MaxRetries reintentos el callback queda como failed.
+func TestNotifyGivesUpAfterMaxRetries(t *testing.T) {
+	srv, hits := callbackReceiver(t, 100)
+	d := &Dispatcher{Store: storage.NewMemStore(), Client: srv.Client(), MaxRetries: 2, Backoff: time.Millisecond}
+
+	e := notifyOnce(t, srv.URL, d)
+
+	if n := len(hits()); n != 3 {
+		t.Errorf("%d peticiones al callback, se esperaban 3 (1 + 2 reintentos)", n)
+	}
+	if e.CallbackStatus != "failed" || e.CallbackAttempts != 3 || !e.CallbackError.Valid {
+		t.Errorf("callback %q tras %d intentos (error %q), se esperaba failed tras 3",
+			e.CallbackStatus, e.CallbackAttempts, e.CallbackError.String)
+	}
+}
@@ -0,0 +1,462 @@
+package worker
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/mail"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"mailer-service/alert"
+	"mailer-service/mailer"
+	"mailer-service/queue"
+	"mailer-service/ratelimit"
+	"mailer-service/storage"
+	"mailer-service/warmup"
+	"mailer-service/webhook"
+)
+
+// Worker procesa en segundo plano los correos en cola y los programados
+// cuya fecha de envío ya llegó.
+type Worker struct {
+	Store        storage.Repository
+	Queue        queue.Queue
+	Callbacks    *webhook.Dispatcher
+	Alerts       *alert.Notifier
+	Limiter      *ratelimit.Bucket
+	Warmup       *warmup.Ramp
+	Concurrency  int
+	PollInterval time.Duration
+	// MaxAttempts es el total de intentos para fallos transitorios;
+	// RetryBackoff la espera antes del primer reintento, que se duplica
+	// en cada intento.
+	MaxAttempts  int
+	RetryBackoff time.Duration
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+
+	mu       sync.Mutex
+	inFlight map[int64]struct{}
+}
+
+// New crea un worker configurado desde el entorno:
+// WORKER_CONCURRENCY (por defecto 4), WORKER_POLL_INTERVAL (por defecto 2s),
+// SEND_MAX_ATTEMPTS (por defecto 3) y SEND_RETRY_BACKOFF (por defecto 1m).
+func New(s storage.Repository) *Worker {
+	conc, err := strconv.Atoi(getEnv("WORKER_CONCURRENCY", "4"))
+	if err != nil || conc <= 0 {
+		conc = 4
+	}
+	interval, err := time.ParseDuration(getEnv("WORKER_POLL_INTERVAL", "2s"))
+	if err != nil || interval <= 0 {
+		interval = 2 * time.Second
+	}
+	attempts, err := strconv.Atoi(getEnv("SEND_MAX_ATTEMPTS", "3"))
+	if err != nil || attempts <= 0 {
+		attempts = 3
+	}
+	backoff, err := time.ParseDuration(getEnv("SEND_RETRY_BACKOFF", "1m"))
+	if err != nil || backoff <= 0 {
+		backoff = time.Minute
+	}
+	return &Worker{
+		Store:        s,
+		Queue:        queue.NewDB(s),
+		Callbacks:    webhook.New(s),
+		Alerts:       alert.FromEnv(),
+		Concurrency:  conc,
+		PollInterval: interval,
+		MaxAttempts:  attempts,
+		RetryBackoff: backoff,
+		stop:         make(chan struct{}),
+		inFlight:     map[int64]struct{}{},
+	}
+}
+
+// Start lanza el bucle de sondeo en una goroutine.
+func (w *Worker) Start() {
+	w.wg.Add(1)
+	go w.loop()
+	log.Printf("Worker iniciado (concurrencia=%d, intervalo=%s)", w.Concurrency, w.PollInterval)
+}
+
+// Stop deja de reclamar correos y espera a que terminen los envíos en curso
+// hasta que venza ctx. Los correos reclamados que no llegaron a enviarse se
+// devuelven a queued para que los procese otra instancia o el próximo arranque.
+func (w *Worker) Stop(ctx context.Context) error {
+	close(w.stop)
+
+	done := make(chan struct{})
+	go func() {
+		w.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+	}
+
+	w.mu.Lock()
+	ids := make([]int64, 0, len(w.inFlight))
+	for id := range w.inFlight {
+		ids = append(ids, id)
+	}
+	w.mu.Unlock()
+	if len(ids) == 0 {
+		return nil
+	}
+
+	// ctx ya venció: se usa un contexto propio para poder escribir en la BD.
+	rctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	n, err := w.Queue.Nack(rctx, ids)
+	if err != nil {
+		return err
+	}
+	log.Printf("Worker detenido: %d correos devueltos a la cola", n)
+	return nil
+}
+
+func (w *Worker) loop() {
+	defer w.wg.Done()
+	t := time.NewTicker(w.PollInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-t.C:
+			w.processBatch()
+		}
+	}
+}
+
+// Flush procesa de inmediato, lote a lote, todos los correos ya listos sin
+// esperar al intervalo de sondeo y devuelve cuántos se enviaron (con éxito o
+// no). Se detiene al vaciarse la cola, al agotarse el límite global o el
+// cupo de calentamiento, al aplazarse un lote entero por el límite de sus
+// plantillas, al vencer ctx o al detenerse el worker.
+func (w *Worker) Flush(ctx context.Context) (int, error) {
+	total := 0
+	for {
+		select {
+		case <-w.stop:
+			return total, nil
+		default:
+		}
+		if err := ctx.Err(); err != nil {
+			return total, err
+		}
+		n := w.processBatch()
+		if n == 0 {
+			return total, nil
+		}
+		total += n
+	}
+}
+
+// processBatch reclama hasta Concurrency correos de la cola y los envía en
+// paralelo. Cada lote contiene como mucho un correo por destinatario (ver
+// ClaimDue y ClaimByIDs). Devuelve cuántos correos envió.
+func (w *Worker) processBatch() int {
+	ctx := context.Background()
+
+	expired, err := w.Store.ExpireStale(ctx, time.Now())
+	if err != nil {
+		log.Println("Error expirando correos:", err)
+	}
+	for _, e := range expired {
+		log.Printf("Correo %d expirado sin enviar", e.ID)
+		if e.CallbackURL != "" {
+			go w.Callbacks.Notify(e.ID)
+		}
+	}
+
+	// Con el límite global agotado los correos esperan en cola.
+	n := w.Limiter.Limit(w.Concurrency)
+	// Con el cupo diario de calentamiento agotado esperan al día siguiente.
+	n, err = w.Warmup.Limit(ctx, n)
+	if err != nil {
+		log.Println("Error consultando el cupo de calentamiento:", err)
+		return 0
+	}
+	if n == 0 {
+		return 0
+	}
+	items, err := w.Queue.Claim(ctx, n)
+	if err != nil {
+		log.Println("Error reclamando correos:", err)
+		return 0
+	}
+
+	allowed := items[:0]
+	var deferred []int64
+	for _, e := range items {
+		if w.Limiter.Allow() {
+			allowed = append(allowed, e)
+		} else {
+			deferred = append(deferred, e.ID)
+		}
+	}
+	if len(deferred) > 0 {
+		if _, err := w.Queue.Nack(ctx, deferred); err != nil {
+			log.Println("Error devolviendo correos a la cola:", err)
+		}
+	}
+	items = w.throttleTemplates(ctx, allowed)
+
+	w.mu.Lock()
+	for _, e := range items {
+		w.inFlight[e.ID] = struct{}{}
+	}
+	w.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, e := range items {
+		wg.Add(1)
+		go func(e storage.Email) {
+			defer wg.Done()
+			w.send(ctx, e)
+		}(e)
+	}
+	wg.Wait()
+	return len(items)
+}
+
+// throttleTemplates aplica el max_per_minute de las plantillas: los correos
+// que superarían el cupo de su plantilla en el último minuto se aplazan y
+// devuelve el resto. Si no se puede leer el cupo o el uso, los correos
+// afectados se aplazan un intervalo de sondeo.
+func (w *Worker) throttleTemplates(ctx context.Context, items []storage.Email) []storage.Email {
+	// caps guarda el cupo de cada plantilla del lote; -1 si no se pudo leer.
+	caps := map[int64]int{}
+	limited := false
+	for _, e := range items {
+		id := e.TemplateID.Int64
+		if _, ok := caps[id]; ok || !e.TemplateID.Valid {
+			continue
+		}
+		// Una plantilla borrada ya no limita sus correos pendientes.
+		t, err := w.Store.GetTemplate(ctx, id)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("Error leyendo la plantilla %d: %v", id, err)
+			t.MaxPerMinute = -1
+		}
+		caps[id] = t.MaxPerMinute
+		limited = limited || t.MaxPerMinute != 0
+	}
+	if !limited {
+		return items
+	}
+
+	used, err := w.Store.TemplateSendCounts(ctx, time.Now().Add(-time.Minute))
+	if err != nil {
+		log.Println("Error consultando los envíos por plantilla:", err)
+	}
+	allowed := items[:0]
+	deferred := map[time.Duration][]int64{}
+	for _, e := range items {
+		id := e.TemplateID.Int64
+		c := caps[id]
+		if !e.TemplateID.Valid || c == 0 {
+			allowed = append(allowed, e)
+			continue
+		}
+		if c > 0 && err == nil && used[id] < int64(c) {
+			used[id]++
+			allowed = append(allowed, e)
+			continue
+		}
+		// Se vuelve a probar cuando, al ritmo del cupo, se libere un hueco.
+		wait := w.PollInterval
+		if c > 0 && err == nil {
+			wait = max(time.Minute/time.Duration(c), wait)
+		}
+		deferred[wait] = append(deferred[wait], e.ID)
+	}
+	for wait, ids := range deferred {
+		at := time.Now().Add(wait)
+		if _, err := w.Store.DeferClaimed(ctx, ids, at); err != nil {
+			log.Println("Error aplazando correos:", err)
+			continue
+		}
+		for _, id := range ids {
+			_ = w.Queue.Ack(ctx, id)
+			_ = w.Queue.Enqueue(ctx, id, at)
+		}
+		log.Printf("%d correos aplazados hasta %s por el límite de su plantilla", len(ids), at.Format(time.RFC3339))
+	}
+	return allowed
+}
+
+func (w *Worker) send(ctx context.Context, e storage.Email) {
+	defer func() {
+		w.mu.Lock()
+		delete(w.inFlight, e.ID)
+		w.mu.Unlock()
+	}()
+
+	// Si no se pueden leer los adjuntos no se envía: el error de la BD
+	// es transitorio y el correo se reintenta.
+	atts, err := w.Store.Attachments(ctx, e.ID)
+	if err == nil {
+		e, err = w.dropSuppressed(ctx, e)
+	}
+	if err == nil {
+		var att mailer.Attempt
+		att, err = mailer.Deliver(mailer.Message{
+			From:       e.From,
+			ReplyTo:    e.ReplyTo,
+			ReturnPath: e.ReturnPath,
+			To:         e.To,
+			Cc:         e.Cc,
+			Bcc:        e.Bcc,
+			Subject:    e.Subject,
+			Body:       e.Body,
+			TextBody:   e.TextBody,
+			Date:       e.DateHeader.Time,
+			RequestDSN: e.RequestDSN,
+
+			Attachments: mailAttachments(atts),
+			AuditBcc:    e.AuditBcc,
+			MessageID:   e.MessageID,
+			InReplyTo:   e.InReplyTo,
+			References:  e.References,
+			Bulk:        e.Bulk,
+			ListID:      e.ListID,
+			Headers:     e.Headers,
+			ContentType: e.ContentType,
+			Delivered:   e.DeliveredTo,
+		}, time.Duration(e.TimeoutSeconds)*time.Second)
+		w.recordAttempt(ctx, e.ID, att, err)
+	}
+	defer w.Queue.Ack(ctx, e.ID)
+	class := mailer.Classify(err)
+	if errors.Is(err, errSuppressed) {
+		class = mailer.ClassPermanent
+	}
+	switch {
+	case err == nil:
+		_ = w.Store.MarkSent(ctx, e.ID)
+	case class == mailer.ClassTransient && e.Attempts+1 < w.MaxAttempts:
+		// Fallo transitorio: se reintenta más tarde y aún no se notifica.
+		at := time.Now().Add(w.RetryBackoff << e.Attempts)
+		log.Printf("Error transitorio enviando correo %d (intento %d), reintento %s: %v",
+			e.ID, e.Attempts+1, at.Format(time.RFC3339), err)
+		_ = w.Store.MarkRetry(ctx, e.ID, err.Error(), at)
+		_ = w.Queue.Enqueue(ctx, e.ID, at)
+		return
+	default:
+		log.Printf("Error enviando correo %d (%s): %v", e.ID, class, err)
+		_ = w.Store.MarkFailed(ctx, e.ID, err.Error(), class)
+		w.recordBounces(storage.WithTenant(ctx, e.TenantID), err)
+		w.Alerts.Failed(e, err.Error())
+	}
+	if e.CallbackURL != "" {
+		go w.Callbacks.Notify(e.ID)
+	}
+}
+
+// errSuppressed indica que el destinatario principal fue suprimido por
+// rebotes después de encolar el correo.
+var errSuppressed = errors.New("destinatario suprimido por rebotes")
+
+// dropSuppressed quita de Cc/Bcc las direcciones suprimidas en el inquilino
+// del correo desde que se encoló; si lo está To, devuelve errSuppressed.
+func (w *Worker) dropSuppressed(ctx context.Context, e storage.Email) (storage.Email, error) {
+	var addrs []string
+	for _, a := range append(append([]string{e.To}, e.Cc...), e.Bcc...) {
+		addrs = append(addrs, bareAddr(a))
+	}
+	suppressed, err := w.Store.Suppressed(storage.WithTenant(ctx, e.TenantID), addrs)
+	if err != nil || len(suppressed) == 0 {
+		return e, err
+	}
+	set := map[string]bool{}
+	for _, a := range suppressed {
+		set[a] = true
+	}
+	isSuppressed := func(a string) bool { return set[strings.ToLower(bareAddr(a))] }
+	if isSuppressed(e.To) {
+		return e, fmt.Errorf("%w: %s", errSuppressed, e.To)
+	}
+	e.Cc = slices.DeleteFunc(slices.Clone(e.Cc), isSuppressed)
+	e.Bcc = slices.DeleteFunc(slices.Clone(e.Bcc), isSuppressed)
+	return e, nil
+}
+
+// recordAttempt guarda el intento de envío en el historial del correo.
+func (w *Worker) recordAttempt(ctx context.Context, id int64, a mailer.Attempt, err error) {
+	at := storage.Attempt{
+		EmailID:    id,
+		Provider:   strings.Join(a.Relays, ","),
+		Code:       a.Code,
+		DurationMs: a.Duration.Milliseconds(),
+	}
+	if err != nil {
+		at.Error = err.Error()
+	}
+	for _, l := range a.Transcript {
+		at.Transcript = append(at.Transcript, storage.TranscriptLine(l))
+	}
+	if err := w.Store.RecordAttempt(ctx, at); err != nil {
+		log.Printf("Error registrando intento del correo %d: %v", id, err)
+	}
+	// Con un fallo parcial, los destinatarios que ya lo recibieron no se
+	// incluyen en el reintento.
+	if err != nil {
+		if err := w.Store.AddDelivered(ctx, id, a.Delivered); err != nil {
+			log.Printf("Error anotando destinatarios entregados del correo %d: %v", id, err)
+		}
+	}
+}
+
+// recordBounces suma un rebote a cada destinatario rechazado de forma
+// permanente por el relay, en el inquilino de ctx.
+func (w *Worker) recordBounces(ctx context.Context, err error) {
+	for _, b := range mailer.HardBounces(err) {
+		rc, err := w.Store.RecordBounce(ctx, b.Addr, b.Err.Error(), storage.BounceThreshold())
+		if err != nil {
+			log.Printf("Error registrando rebote de %s: %v", b.Addr, err)
+			continue
+		}
+		if rc.Bounces == storage.BounceThreshold() {
+			log.Printf("Dirección %s suprimida tras %d rebotes", rc.Address, rc.Bounces)
+		}
+	}
+}
+
+// bareAddr devuelve la dirección sin el nombre visible.
+func bareAddr(a string) string {
+	if p, err := mail.ParseAddress(a); err == nil {
+		return p.Address
+	}
+	return a
+}
+
+// mailAttachments convierte los adjuntos guardados al formato del mailer.
+func mailAttachments(in []storage.Attachment) []mailer.Attachment {
+	out := make([]mailer.Attachment, 0, len(in))
+	for _, a := range in {
+		out = append(out, mailer.Attachment{Filename: a.Filename, ContentType: a.ContentType, Content: a.Content})
+	}
+	return out
+}
+
+func getEnv(k, d string) string {
+	if v := os.Getenv(k); v != "" {
+		return v
+	}
+	return d
+}
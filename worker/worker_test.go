@@ -0,0 +1,95 @@
+package worker
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"mailer-service/storage"
+)
+
+// stalledRelay acepta conexiones SMTP y nunca responde, de modo que los
+// envíos quedan en curso hasta que se cierra.
+func stalledRelay(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	var conns []net.Conn
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			conns = append(conns, c)
+			mu.Unlock()
+		}
+	}()
+	t.Cleanup(func() {
+		ln.Close()
+		mu.Lock()
+		defer mu.Unlock()
+		for _, c := range conns {
+			c.Close()
+		}
+	})
+	return ln.Addr().String()
+}
+
+// Stop devuelve a la cola los correos reclamados cuyo envío no terminó
+// antes de vencer el plazo de apagado.
+func TestStopRequeuesClaimed(t *testing.T) {
+	host, port, _ := net.SplitHostPort(stalledRelay(t))
+	t.Setenv("SMTP_HOST", host)
+	t.Setenv("SMTP_PORT", port)
+	t.Setenv("SMTP_AUTH", "none")
+
+	ctx := context.Background()
+	s := storage.NewMemStore()
+	var ids []int64
+	for _, to := range []string{"a@example.com", "b@example.com"} {
+		id, err := s.InsertEmail(ctx, storage.Email{To: to, Subject: "s", Body: "b", Status: "queued"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+
+	w := New(s)
+	w.PollInterval = 10 * time.Millisecond
+	w.Start()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		counts, _ := s.CountByStatus(ctx)
+		if counts["sending"] == int64(len(ids)) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("los correos no llegaron a reclamarse: %v", counts)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	stopCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if err := w.Stop(stopCtx); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range ids {
+		e, err := s.GetEmail(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e.Status != "queued" {
+			t.Errorf("correo %d en %q tras Stop, se esperaba queued", id, e.Status)
+		}
+	}
+}